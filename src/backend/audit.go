@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-server/config"
+	"golang-server/log"
+	"golang-server/types"
+	"os"
+)
+
+// auditor records every command in the audit log, and checks it against the operator roles if
+// config.UseOperatorRoles is set.
+type auditor struct {
+	logger    *log.AuditLogger
+	operators map[string]string       //operator -> role, nil without operator roles
+	teleop    map[int]log.AuditRecord //latest teleop record of each robot
+}
+
+func initAuditor() *auditor {
+	a := &auditor{teleop: map[int]log.AuditRecord{}}
+	var err error
+	if a.logger, err = log.InitAuditLogger(); err != nil {
+		logger.Error("Failed to create the audit log, commands are not audited", "err", err)
+	}
+	if config.UseOperatorRoles {
+		if a.operators, err = loadOperators(config.OperatorsFile); err != nil {
+			logger.Error("Failed to load the operators, everyone is a viewer", "file", config.OperatorsFile, "err", err)
+			a.operators = map[string]string{}
+		}
+	}
+	return a
+}
+
+// loadOperators reads a JSON object from operator names to roles.
+func loadOperators(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	operators := map[string]string{}
+	if err := json.Unmarshal(data, &operators); err != nil {
+		return nil, err
+	}
+	for name, role := range operators {
+		if role != types.RoleViewer && role != types.RoleOperator && role != types.RoleSupervisor {
+			return nil, fmt.Errorf("operator %q has unknown role %q", name, role)
+		}
+	}
+	return operators, nil
+}
+
+// role returns the role of an operator, or an empty string without operator roles.
+func (a *auditor) role(operator string) string {
+	if a.operators == nil {
+		return ""
+	}
+	if role, exist := a.operators[operator]; exist {
+		return role
+	}
+	return types.RoleViewer
+}
+
+// allowed tells if a role may send a command while the robots are in the given motion state. Everyone
+// may stop the robots, see types.RoleViewer.
+func allowed(role string, commandType, motion int) bool {
+	switch {
+	case role == "" || role == types.RoleSupervisor || commandType == types.StopCommand:
+		return true
+	case role == types.RoleOperator:
+		return commandType != types.ResumeCommand || motion != types.MotionStopped
+	}
+	return false
+}
+
+// write adds a record to the audit log. Teleop commands are repeated while the operator drives, so
+// they are only recorded when something else than the time has changed.
+func (a *auditor) write(record log.AuditRecord) {
+	if record.Command == commandNames[types.TeleopCommand] {
+		previous, exist := a.teleop[record.Robot]
+		a.teleop[record.Robot] = record
+		if exist && *previous.Velocity == *record.Velocity && previous.Outcome == record.Outcome &&
+			previous.Reason == record.Reason && previous.Source == record.Source && previous.Operator == record.Operator {
+			return
+		}
+	}
+	if err := a.logger.Write(record); err != nil {
+		logger.Error("Failed to write the audit log", "err", err)
+	}
+}
+
+func (a *auditor) Close() error {
+	return a.logger.Close()
+}
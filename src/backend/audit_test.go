@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"golang-server/log"
+	"golang-server/types"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func auditRecords(t *testing.T, buf *bytes.Buffer) []log.AuditRecord {
+	t.Helper()
+	records := []log.AuditRecord{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var record log.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		role                string
+		commandType, motion int
+		allowed             bool
+	}{
+		{"", types.ResumeCommand, types.MotionStopped, true}, //without operator roles
+		{types.RoleViewer, types.StopCommand, types.MotionRunning, true},
+		{types.RoleViewer, types.ManualCommand, types.MotionRunning, false},
+		{types.RoleViewer, types.PauseCommand, types.MotionRunning, false},
+		{types.RoleOperator, types.TeleopCommand, types.MotionRunning, true},
+		{types.RoleOperator, types.ResumeCommand, types.MotionPaused, true},
+		{types.RoleOperator, types.ResumeCommand, types.MotionStopped, false},
+		{types.RoleSupervisor, types.ResumeCommand, types.MotionStopped, true},
+	}
+	for _, test := range tests {
+		if got := allowed(test.role, test.commandType, test.motion); got != test.allowed {
+			t.Errorf("%q, command %d, motion %d: expected %v, got %v", test.role, test.commandType, test.motion, test.allowed, got)
+		}
+	}
+}
+
+func TestLoadOperators(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "operators.json")
+	os.WriteFile(path, []byte(`{"alice": "supervisor", "bob": "operator"}`), 0666)
+	operators, err := loadOperators(path)
+	if err != nil || operators["alice"] != types.RoleSupervisor || operators["bob"] != types.RoleOperator {
+		t.Fatalf("Got %v, %v", operators, err)
+	}
+	os.WriteFile(path, []byte(`{"alice": "admin"}`), 0666)
+	if _, err := loadOperators(path); err == nil {
+		t.Error("Unknown role accepted")
+	}
+}
+
+func TestHandleCommandAudit(t *testing.T) {
+	var buf bytes.Buffer
+	audit := &auditor{logger: log.NewAuditLogger(nopCloser{&buf}), operators: map[string]string{"bob": types.RoleOperator}, teleop: map[int]log.AuditRecord{}}
+	state := initFullSlamState()
+	state.id2index[1] = 0
+	state.multiRobot = append(state.multiRobot, *initRobotState(10, 20, 90))
+	chPublish := make(chan types.PublishMsg, 8)
+	chPublishControl := make(chan types.PublishMsg, 8)
+	chB2gNotice := make(chan string, 8)
+	handle := func(command types.Command, motion int) int {
+		command.Source = types.SourceGui
+		return state.handleCommand(command, motion, map[int]struct{}{}, audit, chPublish, chPublishControl, chB2gNotice)
+	}
+
+	handle(types.Command{CommandType: types.ManualCommand, Id: 1, X: 10, Y: 30, Operator: "bob"}, types.MotionRunning)
+	handle(types.Command{CommandType: types.ManualCommand, Id: 7, X: 10, Y: 30, Operator: "bob"}, types.MotionRunning)
+	handle(types.Command{CommandType: types.ManualCommand, Id: 1, X: 10, Y: 30, Operator: "eve"}, types.MotionRunning)
+	motion := handle(types.Command{CommandType: types.StopCommand, Id: -1, Operator: "eve"}, types.MotionRunning)
+	handle(types.Command{CommandType: types.ResumeCommand, Id: -1, Operator: "bob"}, motion)
+	for i := 0; i < 3; i++ {
+		handle(types.Command{CommandType: types.TeleopCommand, Id: 1, Linear: 100, Operator: "bob"}, motion)
+	}
+
+	records := auditRecords(t, &buf)
+	if len(records) != 6 {
+		t.Fatalf("Expected 6 records, the repeated teleop command once, got %d: %+v", len(records), records)
+	}
+	goal := records[0]
+	//the robot faces along the y axis of the map, so 10 cm along y is 100 mm straight ahead
+	if goal.Outcome != log.AuditSent || goal.Command != "goal" || goal.Role != types.RoleOperator || goal.Source != types.SourceGui ||
+		*goal.Requested != [2]int{10, 30} || *goal.Map != [2]int{10, 30} || goal.Body == nil || goal.Body[0] != 100 || goal.Body[1] != 0 {
+		t.Errorf("Expected the goal to be sent in the body frame, got %+v", goal)
+	}
+	if msg := <-chPublish; msg.Kind != types.PublishTarget || msg.Values != *goal.Body {
+		t.Errorf("The published goal %+v does not match the audit record", msg)
+	}
+	expected := []struct{ outcome, reason, role string }{
+		{log.AuditDropped, "unknown_robot", types.RoleOperator},
+		{log.AuditRejected, "not allowed for viewer", types.RoleViewer},
+		{log.AuditSent, "", types.RoleViewer}, //everyone may stop the robots
+		{log.AuditRejected, "not allowed for operator", types.RoleOperator},
+		{log.AuditDropped, "stopped", types.RoleOperator},
+	}
+	for i, e := range expected {
+		if r := records[i+1]; r.Outcome != e.outcome || r.Reason != e.reason || r.Role != e.role {
+			t.Errorf("Record %d: expected %s %q as %s, got %+v", i+1, e.outcome, e.reason, e.role, r)
+		}
+	}
+	if len(chPublish) != 0 {
+		t.Errorf("Expected only the first goal to be published, %d more were", len(chPublish))
+	}
+}
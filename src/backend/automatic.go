@@ -1,7 +1,6 @@
 package backend
 
 import (
-	"golang-server/log"
 	"math"
 )
 
@@ -17,7 +16,7 @@ func (s *fullSlamState) findClosestRobot(x, y int) int {
 		}
 	}
 	if closestRobot == -1 {
-		log.GGeneralLogger.Println("Tried to find closest robot, but no robots were found.")
+		logger.Warn("Tried to find closest robot, but no robots were found.")
 	}
 	return closestRobot
 }
@@ -3,14 +3,20 @@ package backend
 import (
 	"fmt"
 	"golang-server/config"
+	"golang-server/geometry"
 	"golang-server/log"
+	"golang-server/metrics"
 	"golang-server/types"
-	"golang-server/utilities"
-	"math"
-	"strings"
+	"maps"
+	"slices"
 	"time"
 )
 
+var logger = log.Component("backend")
+
+// newTicker is replaced by the scenario tests, which tick the backend themselves.
+var newTicker = func(d time.Duration) <-chan time.Time { return time.NewTicker(d).C }
+
 // using binary flags to represent the map to allow bitwise operations
 const (
 	mapOpen     uint8 = 1 << iota //1
@@ -24,10 +30,31 @@ func initRobotState(x, y, theta int) *types.RobotState {
 
 type fullSlamState struct {
 	areaMap     [config.MapSize][config.MapSize]uint8
-	newObstacle [][2]int //new since last gui update
-	newOpen     [][2]int //new since last gui update
+	logOdds     [config.MapSize][config.MapSize]float32 //areaMap is derived from this, see sensormodel.go
+	newObstacle [][2]int                                //new since last gui update
+	newOpen     [][2]int                                //new since last gui update
+	newUnknown  [][2]int                                //new since last gui update
 	multiRobot  []types.RobotState
 	id2index    map[int]int
+	scanWindows map[int][]scanEntry     //only used with config.UseScanMatching
+	corrections map[int]geometry.Pose2D //only used with config.UseScanMatching
+	graph       *poseGraph              //nil unless config.UsePoseGraph
+	localMaps   map[int]*localMap       //only used with config.UseMapMerging
+	merges      mergeState              //see mapmerge.go
+	rawPoses    map[int][3]int          //latest pose reported by each robot, x, y [mm], theta [degrees]
+	zones       []types.Zone            //see geofence.go
+	zoneChanged bool                    //since last gui update
+	inKeepOut   map[int]string          //keep-out zone each robot is inside, if any
+	goals       map[int]activeGoal      //see traffic.go
+	traffic     []types.TrafficConflict
+	waiting     map[int]int             //robot id -> id of the robot it waits for
+	blocked     map[int]bool            //robots already reported as blocked
+	cellCounts  map[uint8]int           //number of cells with each areaMap value
+	coverage    coverageState           //see coverage.go
+	dynamic     dynamicState            //see dynamic.go
+	costmap     *costmap                //see costmap.go
+	telemetry   map[int]*robotTelemetry //see telemetry.go
+	tracks      map[int][][2]float64    //trajectory of each robot, see export.go
 }
 
 func initFullSlamState() *fullSlamState {
@@ -38,6 +65,24 @@ func initFullSlamState() *fullSlamState {
 		}
 	}
 	s.id2index = make(map[int]int)
+	s.scanWindows = make(map[int][]scanEntry)
+	s.corrections = make(map[int]geometry.Pose2D)
+	s.localMaps = make(map[int]*localMap)
+	s.merges.results = make(chan []types.MergeProposal, 1)
+	s.rawPoses = make(map[int][3]int)
+	s.inKeepOut = make(map[int]string)
+	s.goals = make(map[int]activeGoal)
+	s.waiting = make(map[int]int)
+	s.blocked = make(map[int]bool)
+	s.cellCounts = map[uint8]int{mapUnknown: config.MapSize * config.MapSize}
+	s.coverage = initCoverageState()
+	s.dynamic = initDynamicState()
+	s.costmap = initCostmap()
+	s.telemetry = make(map[int]*robotTelemetry)
+	s.tracks = make(map[int][][2]float64)
+	if config.UsePoseGraph {
+		s.graph = newPoseGraph()
+	}
 
 	return &s
 }
@@ -45,246 +90,379 @@ func initFullSlamState() *fullSlamState {
 // The map is very large and sending it gives a warning. This only sends updates.
 
 func ThreadBackend(
-	chPublish chan<- [3]int,
+	chPublish chan<- types.PublishMsg,
+	chPublishControl chan<- types.PublishMsg,
 	chReceive <-chan types.AdvMsg,
 	chCamera <-chan types.CameraMsg,
 	chB2gRobotPendingInit chan<- int,
 	chB2gUpdate chan<- types.UpdateGui,
 	chG2bRobotInit <-chan [4]int,
 	chG2bCommand <-chan types.Command,
+	chB2gMergeProposal chan<- types.MergeProposal,
+	chG2bMergeReject <-chan int,
+	chG2bZones <-chan []types.Zone,
+	chB2gNotice chan<- string,
+	chG2bExport <-chan types.ExportRequest,
+	chShutdown <-chan chan struct{},
 ) {
 	var state *fullSlamState = initFullSlamState()
+	if zones, err := loadZones(config.GeofenceFile); err != nil {
+		logger.Error("Failed to load geofences", "file", config.GeofenceFile, "err", err)
+	} else if len(zones) > 0 {
+		state.zones, state.zoneChanged = zones, true
+		state.setRegionsOfInterest()
+		state.setKeepOutLayer()
+		logger.Info("Loaded geofences", "file", config.GeofenceFile, "zones", len(zones))
+	}
 
-	prevMsg := types.AdvMsg{}
-	positionLogger := log.InitPositionLogger()
+	trajectoryLogger, err := log.InitTrajectoryLogger(config.TrajectoryFormat)
+	if err != nil {
+		logger.Error("Failed to create the trajectory log, trajectories are not logged", "err", err)
+	}
+	coverageLogger, err := initCoverageLogger()
+	if err != nil {
+		logger.Error("Failed to create the coverage log, coverage is not logged", "err", err)
+	}
+	audit := initAuditor()
 	pendingInit := map[int]struct{}{} //simple and efficient way in golang to create a set to check values.
-	guiUpdateTicker := time.NewTicker(time.Second / config.GuiFrameRate)
+	motion := types.MotionRunning     //set by the emergency stop, pause and resume commands
+	guiUpdateTick := newTicker(time.Second / config.GuiFrameRate)
+	var trafficTick <-chan time.Time //nil, and never ready, without traffic management
+	if config.UseTrafficManagement {
+		trafficTick = newTicker(config.TrafficCheckInterval * time.Millisecond)
+	}
+	var decayTick <-chan time.Time //nil without decay and change detection
+	if config.UseCellDecay || config.UseChangeDetection {
+		decayTick = newTicker(config.CellDecayInterval * time.Second)
+	}
+	var mapSaved time.Time              //map.png is saved every config.MapSaveInterval
+	var graphResults <-chan graphResult //nil without the pose graph, see startOptimization
+	if state.graph != nil {
+		graphResults = state.graph.results
+	}
+	var event string    //handled in the previous iteration, for metrics
+	var start time.Time //when the event was received
 	for {
+		if event != "" {
+			metrics.BackendLoopSeconds.Observe(time.Since(start).Seconds(), event)
+		}
 		select {
-		case <-guiUpdateTicker.C:
-			//update gui
-			chB2gUpdate <- types.UpdateGui{
-				MultiRobot:  state.multiRobot,
-				Id2index:    state.id2index,
+		case <-guiUpdateTick:
+			event, start = "gui_update", time.Now()
+			metrics.MapCells.Set(float64(state.cellCounts[mapOpen]), "open")
+			metrics.MapCells.Set(float64(state.cellCounts[mapObstacle]), "obstacle")
+			metrics.MapCells.Set(float64(state.cellCounts[mapUnknown]), "unknown")
+			coverage := state.coverageStats(start)
+			if err := coverageLogger.write(start, coverage); err != nil {
+				logger.Error("Failed to write the coverage log", "err", err)
+			}
+			if start.Sub(mapSaved) >= config.MapSaveInterval*time.Second {
+				mapSaved = start
+				if err := state.saveSessionMap(); err != nil {
+					logger.Error("Failed to save the map", "err", err)
+				}
+			}
+			//update gui, with copies of the robots since the gui reads them on other goroutines
+			update := types.UpdateGui{
+				MultiRobot:  slices.Clone(state.multiRobot),
+				Id2index:    maps.Clone(state.id2index),
 				NewOpen:     state.newOpen,
 				NewObstacle: state.newObstacle,
+				NewUnknown:  state.newUnknown,
+				PoseGraph:   state.graph.takeView(),
+				Motion:      motion,
+				Traffic:     state.traffic,
+				Coverage:    coverage,
+				Changes:     state.dynamic.takeChanges(),
+				Telemetry:   state.telemetryView(motion, start),
+			}
+			if config.UseCostmap {
+				update.Costmap = state.takeCostmap()
+			}
+			if state.zoneChanged {
+				update.Zones, update.ZonesChanged = state.zones, true
+				state.zoneChanged = false
 			}
-			//reset newOpen and newObstacle
+			chB2gUpdate <- update
+			//reset newOpen, newObstacle and newUnknown
 			state.newOpen = [][2]int{}
 			state.newObstacle = [][2]int{}
-		case command := <-chG2bCommand:
-			switch command.CommandType {
-			case types.AutomaticCommand:
-				id := state.findClosestRobot(command.X, command.Y)
-				if id == -1 {
-					//already logged in findClosestRobot()
-					return
-				}
-				//convert to mm because robot uses mm, and rotate back from init to get robot body coordinates
-				robot := state.getRobot(id)
-				xRobotBody, yRobotBody := utilities.Rotate(float64(command.X-robot.XInit)*10, float64(command.Y-robot.YInit)*10, -float64(robot.ThetaInit))
-				chPublish <- [3]int{id, int(xRobotBody), int(yRobotBody)}
-				log.GGeneralLogger.Println("Publishing automatic input to robot with ID: ", command.Id, " x: ", command.X, " y: ", command.Y, ".")
-			case types.ManualCommand:
-				//convert to mm because robot uses mm, and rotate back from init to get robot body coordinates
-				robot := state.getRobot(command.Id)
-				xRobotBody, yRobotBody := utilities.Rotate(float64(command.X-robot.XInit)*10, float64(command.Y-robot.YInit)*10, -float64(robot.ThetaInit))
-				chPublish <- [3]int{command.Id, int(xRobotBody), int(yRobotBody)}
-				log.GGeneralLogger.Println("Publishing manual input to robot with ID: ", command.Id, " x: ", command.X, " y: ", command.Y, ".")
+			state.newUnknown = [][2]int{}
+			if config.UseMapMerging {
+				state.startMerges(time.Now())
 			}
+		case command := <-chG2bCommand:
+			event, start = "command", time.Now()
+			motion = state.handleCommand(command, motion, pendingInit, audit, chPublish, chPublishControl, chB2gNotice)
 		case msg := <-chReceive:
+			event, start = "adv", time.Now()
 			if _, exist := pendingInit[msg.Id]; exist {
-				//skip
+				if config.UseMapMerging {
+					state.updateLocalMap(msg)
+				}
 			} else if _, exist := state.id2index[msg.Id]; !exist {
 				pendingInit[msg.Id] = struct{}{}
 				chB2gRobotPendingInit <- msg.Id //Buffered channel, so it will not block.
+				if motion != types.MotionRunning {
+					//a new robot must not drive while the others are stopped
+					publishMotion(chPublishControl, motion, []int{msg.Id})
+				}
 			} else {
 				//robot update
-				newX, newY := utilities.Rotate(float64(msg.X/10), float64(msg.Y/10), float64(state.getRobot(msg.Id).ThetaInit))
-				index := state.id2index[msg.Id]
-				state.multiRobot[index].X = int(newX) + state.getRobot(msg.Id).XInit
-				state.multiRobot[index].Y = int(newY) + state.getRobot(msg.Id).YInit
-				state.multiRobot[index].Theta = msg.Theta + state.getRobot(msg.Id).ThetaInit
-				state.multiRobot[index].IrTowerAngle = msg.IrTowerAngle
+				state.updateRobotPose(msg)
+				state.recordTelemetry(msg, start)
+				odometry := state.getRobot(msg.Id)
+				if state.graph != nil {
+					state.applyGraphCorrection(msg.Id)
+				}
+				if config.UseScanMatching {
+					state.applyPoseCorrection(msg.Id)
+				}
+				if state.graph != nil {
+					state.updatePoseGraph(msg.Id, odometry, msg)
+				}
+				state.updateDistance(msg.Id)
+				state.recordTrajectory(msg.Id)
+				if zone, entered := state.checkRobotZones(msg.Id); entered {
+					notify(chB2gNotice, fmt.Sprintf("Robot %d entered keep-out zone %s.", msg.Id, zone))
+					if config.GeofenceAutoStop && motion != types.MotionStopped {
+						motion = types.MotionStopped
+						robots := state.knownRobots(pendingInit)
+						publishMotion(chPublishControl, motion, robots)
+						logMotion(motion, robots)
+					}
+				}
 
 				// Her kommer oppdateringer fra roboten inn. Få den til å sende inn
 				// Kovariansmatrisen fra Kalmanfilteret også slik at det kan
 				// brukes i NEES!
 
 				//map update, dependent upon an updated robot
-				state.addIrSensorData(msg.Id, msg.Ir1x, msg.Ir1y)
-				state.addIrSensorData(msg.Id, msg.Ir2x, msg.Ir2y)
-				state.addIrSensorData(msg.Id, msg.Ir3x, msg.Ir3y)
-				state.addIrSensorData(msg.Id, msg.Ir4x, msg.Ir4y)
-				//log position
-				if msg.X != prevMsg.X || msg.Y != prevMsg.Y || msg.Theta != prevMsg.Theta {
-					covarianceMatrixString := formatCovarianceMatrix([5 * 5]float32{
-						msg.CovarianceMatrixNumber1, msg.CovarianceMatrixNumber2, msg.CovarianceMatrixNumber3, msg.CovarianceMatrixNumber4, msg.CovarianceMatrixNumber5,
-						msg.CovarianceMatrixNumber6, msg.CovarianceMatrixNumber7, msg.CovarianceMatrixNumber8, msg.CovarianceMatrixNumber9, msg.CovarianceMatrixNumber10,
-						msg.CovarianceMatrixNumber11, msg.CovarianceMatrixNumber12, msg.CovarianceMatrixNumber13, msg.CovarianceMatrixNumber14, msg.CovarianceMatrixNumber15,
-						msg.CovarianceMatrixNumber16, msg.CovarianceMatrixNumber17, msg.CovarianceMatrixNumber18, msg.CovarianceMatrixNumber19, msg.CovarianceMatrixNumber20,
-						msg.CovarianceMatrixNumber21, msg.CovarianceMatrixNumber22, msg.CovarianceMatrixNumber23, msg.CovarianceMatrixNumber24, msg.CovarianceMatrixNumber25,
-					})
-					positionLogger.Printf("%d %d %d %d %s\n", msg.Id, state.getRobot(msg.Id).X, state.getRobot(msg.Id).Y, state.getRobot(msg.Id).Theta, covarianceMatrixString)
+				state.coverage.mapper = msg.Id
+				if config.UseScanMatching {
+					state.addToScanWindow(msg.Id, scanEntry{ir: [4][2]int{{msg.Ir1x, msg.Ir1y}, {msg.Ir2x, msg.Ir2y}, {msg.Ir3x, msg.Ir3y}, {msg.Ir4x, msg.Ir4y}}})
+				} else {
+					state.addIrSensorData(msg.Id, msg.Ir1x, msg.Ir1y)
+					state.addIrSensorData(msg.Id, msg.Ir2x, msg.Ir2y)
+					state.addIrSensorData(msg.Id, msg.Ir3x, msg.Ir3y)
+					state.addIrSensorData(msg.Id, msg.Ir4x, msg.Ir4y)
+				}
+				state.coverage.mapper = -1
+				//log trajectory
+				robot := state.getRobot(msg.Id)
+				row := log.NewTrajectoryRow()
+				row.Id, row.RawX, row.RawY, row.RawTheta = msg.Id, msg.X, msg.Y, msg.Theta
+				row.X, row.Y, row.Theta = robot.X, robot.Y, robot.Theta
+				row.Covariance = msg.Covariance()
+				row.Ir = [4][2]int{{msg.Ir1x, msg.Ir1y}, {msg.Ir2x, msg.Ir2y}, {msg.Ir3x, msg.Ir3y}, {msg.Ir4x, msg.Ir4y}}
+				row.IrTowerAngle, row.Valid = msg.IrTowerAngle, msg.Valid
+				if err := trajectoryLogger.Write(row); err != nil {
+					logger.Error("Failed to write the trajectory log", "robot", msg.Id, "err", err)
 				}
 			}
-			prevMsg = msg
 		case cam := <-chCamera:
+			event, start = "camera", time.Now()
 			if _, exist := state.id2index[cam.Id]; !exist {
-				log.GGeneralLogger.Printf("Camera message for unknown robot id=%d ignored (no init)", cam.Id)
+				if local, exist := state.localMaps[cam.Id]; exist {
+					local.addCameraFrame(cam)
+					continue
+				}
+				logger.Debug("Camera message for uninitialized robot ignored", "robot", cam.Id)
 				continue
 			}
-			state.addCameraSegment(cam.Id, cam.StartMM, cam.WidthMM, cam.DistanceMM)
+			state.coverage.mapper = cam.Id
+			if config.UseScanMatching {
+				state.addToScanWindow(cam.Id, scanEntry{camera: &cam})
+			} else {
+				state.addCameraFrame(cam.Id, cam)
+			}
+			state.coverage.mapper = -1
 		case init := <-chG2bRobotInit:
+			event, start = "init", time.Now()
 			id := init[0]
+			if _, exist := state.id2index[id]; exist {
+				state.relocalizeRobot(id, init[1], init[2], init[3])
+				continue
+			}
 			state.id2index[id] = len(state.multiRobot)
 			state.multiRobot = append(state.multiRobot, *initRobotState(init[1], init[2], init[3]))
 			delete(pendingInit, id)
+			if _, exist := state.localMaps[id]; exist {
+				state.coverage.mapper = id
+				state.mergeLocalMap(id, init[1], init[2], init[3])
+				state.coverage.mapper = -1
+			}
+		case <-trafficTick:
+			event, start = "traffic", time.Now()
+			if motion == types.MotionRunning {
+				state.traffic = state.planTraffic()
+				state.applyTraffic(chPublishControl, chB2gNotice, state.traffic)
+			}
+		case <-decayTick:
+			event, start = "decay", time.Now()
+			state.tickDynamic()
+		case result := <-graphResults:
+			event, start = "pose_graph", time.Now()
+			state.applyGraphResult(result)
+		case proposals := <-state.merges.results:
+			event, start = "map_merge", time.Now()
+			for _, proposal := range state.applyMerges(proposals) {
+				chB2gMergeProposal <- proposal
+			}
+		case id := <-chG2bMergeReject:
+			event, start = "merge_reject", time.Now()
+			state.rejectMerge(id)
+		case zones := <-chG2bZones:
+			event, start = "zones", time.Now()
+			state.setZones(zones)
+			state.setRegionsOfInterest()
+		case request := <-chG2bExport:
+			event, start = "export", time.Now()
+			state.handleExport(request, chB2gNotice)
+		case done := <-chShutdown:
+			if err := state.saveSessionMap(); err != nil {
+				logger.Error("Failed to save the map", "err", err)
+			}
+			if err := writeCoverageSummary(state.coverageStats(time.Now())); err != nil {
+				logger.Error("Failed to write the coverage summary", "err", err)
+			}
+			coverageLogger.Close()
+			trajectoryLogger.Close()
+			audit.Close()
+			close(done)
+			return
 		}
 	}
 }
 
-func formatCovarianceMatrix(matrix [5 * 5]float32) string {
-	var sb strings.Builder
-	for i, value := range matrix {
-		if i > 0 {
-			sb.WriteString(",")
-		}
-		sb.WriteString(fmt.Sprintf("%f", value))
-	}
-	return sb.String()
-}
-
 func (s *fullSlamState) setMapValue(x, y int, value uint8) {
+	s.coverage.countDiscovered(x, y, value)
+	s.detectChange(x, y, value)
+	if value == mapObstacle || s.areaMap[x][y] == mapObstacle {
+		s.costmap.dirty = true
+	}
+	s.cellCounts[s.areaMap[x][y]]--
+	s.cellCounts[value]++
 	s.areaMap[x][y] = value
 	switch value {
 	case mapOpen:
 		s.newOpen = append(s.newOpen, [2]int{x, y})
 	case mapObstacle:
 		s.newObstacle = append(s.newObstacle, [2]int{x, y})
+	case mapUnknown:
+		s.newUnknown = append(s.newUnknown, [2]int{x, y})
 	}
 }
 
+// updateRobotPose transforms the pose in the message from the robot frame to the map.
+func (s *fullSlamState) updateRobotPose(msg types.AdvMsg) {
+	robot := &s.multiRobot[s.id2index[msg.Id]]
+	setBodyPose(robot, initPose(*robot).Compose(odometryPose(msg.X, msg.Y, msg.Theta)))
+	robot.IrTowerAngle = msg.IrTowerAngle
+	s.rawPoses[msg.Id] = [3]int{msg.X, msg.Y, msg.Theta}
+}
+
+// relocalizeRobot moves an initialized robot to a pose given by the operator. The initial pose is
+// recomputed so that the latest pose reported by the robot ends up at the new pose.
+func (s *fullSlamState) relocalizeRobot(id, x, y, theta int) {
+	raw := s.rawPoses[id]
+	index := s.id2index[id]
+	robot := &s.multiRobot[index]
+	target := geometry.NewPose(geometry.Cm(x), geometry.Cm(y), geometry.Deg(theta))
+	robot.XInit, robot.YInit, robot.ThetaInit = target.Compose(odometryPose(raw[0], raw[1], raw[2]).Inverse()).Round()
+	setBodyPose(robot, target)
+	s.coverage.lastPose[id] = [2]int{x, y} //the jump is not travelled
+
+	//corrections and collected data belong to the old pose
+	delete(s.corrections, id)
+	s.scanWindows[id] = nil
+	if s.graph != nil {
+		s.graph.relocalize(id)
+	}
+	logger.Info("Re-localizing robot", "robot", id, "x", x, "y", y, "theta", theta)
+}
+
 func (s *fullSlamState) getRobot(id int) types.RobotState {
 	return s.multiRobot[s.id2index[id]]
 }
 
 func (s *fullSlamState) addIrSensorData(id, irX, irY int) {
-	xMap, yMap := s.transformIrSensorData(id, irX, irY)
-	s.addLineToMap(id, xMap, yMap)
+	s.addIrRay(id, irPoint(s.getRobot(id), irX, irY))
 }
 
-func (s *fullSlamState) transformIrSensorData(id, xBodyFrame, yBodyFrame int) (int, int) {
-	// IR data is given in mm and it is relative to the body, so it must be scaled, rotated and transelated to the map.
-
-	//rotate
-	theta := s.multiRobot[s.id2index[id]].Theta
-	xBodyFrameRotated, yBodyFrameRotated := utilities.Rotate(float64(xBodyFrame), float64(yBodyFrame), float64(theta))
-
-	//scale and transelate
-	xMap := math.Round(xBodyFrameRotated/10) + float64(s.multiRobot[s.id2index[id]].X)
-	yMap := math.Round(yBodyFrameRotated/10) + float64(s.multiRobot[s.id2index[id]].Y)
+func transformIrSensorData(robot types.RobotState, xBodyFrame, yBodyFrame int) (int, int) {
+	return irPoint(robot, xBodyFrame, yBodyFrame).Round()
+}
 
-	return int(xMap), int(yMap)
+// irPoint returns an IR reading in map coordinates, without rounding it to a cell.
+func irPoint(robot types.RobotState, xBodyFrame, yBodyFrame int) geometry.Vec2 {
+	// IR data is given in mm in the body frame
+	return bodyPose(robot).Apply(geometry.VecMm(geometry.Mm(xBodyFrame), geometry.Mm(yBodyFrame)))
 }
 
 func (s *fullSlamState) addLineToMap(id, x1, y1 int) {
-	//x0, y0, x1, y1 is given in map coordinates. With origo as defined in the config.
-	x0 := s.getRobot(id).X
-	y0 := s.getRobot(id).Y
-
-	lineLength := math.Sqrt(math.Pow(float64(x0-x1), 2) + math.Pow(float64(y0-y1), 2))
-
-	var obstruction bool
-	if lineLength < config.IrSensorMaxDistance {
-		obstruction = true
-	} else {
-		obstruction = false
-
-		//shorten the line to config.IrSensorMaxDistance, needed for bresenham algorithm
-		scale := config.IrSensorMaxDistance / lineLength
-		x1 = x0 + int(scale*float64(x1-x0))
-		y1 = y0 + int(scale*float64(y1-y0))
+	//x1, y1 is given in map coordinates. With origo as defined in the config.
+	s.addIrRay(id, geometry.Vec2{X: float64(x1), Y: float64(y1)})
+}
 
+// addIrRay adds an IR reading from the robot to end, given in map coordinates. A reading at the max
+// distance of the sensor did not hit anything.
+func (s *fullSlamState) addIrRay(id int, end geometry.Vec2) {
+	origin := bodyPose(s.getRobot(id)).Position()
+	ray := end.Sub(origin)
+	lineLength := float64(ray.Norm())
+	obstruction := lineLength < config.IrSensorMaxDistance
+	if !obstruction {
+		//shorten the line to config.IrSensorMaxDistance
+		end = origin.Add(ray.Scale(config.IrSensorMaxDistance / lineLength))
 	}
 
-	//get map index values
-	x0Index, y0Index := calculateMapIndex(x0, y0)
-	x1Index, y1Index := calculateMapIndex(x1, y1)
-	//get values in map range
-	x1Index = min(max(x1Index, 0), config.MapSize-1)
-	y1Index = min(max(y1Index, 0), config.MapSize-1)
-	x0Index = min(max(x0Index, 0), config.MapSize-1)
-	y0Index = min(max(y0Index, 0), config.MapSize-1)
-
-	indexPoints := utilities.BresenhamAlgorithm(x0Index, y0Index, x1Index, y1Index)
-	for i := 0; i < len(indexPoints); i++ {
-		x := indexPoints[i][0]
-		y := indexPoints[i][1]
-		s.setMapValue(x, y, mapOpen)
-	}
-	if obstruction {
-		s.setMapValue(x1Index, y1Index, mapObstacle)
+	updates := cellUpdates{}
+	updates.addRay(irModelFor(id), clampedMapPoint(origin), clampedMapPoint(end), obstruction)
+	if s.graph != nil && s.graph.recordRay(id, origin, end, obstruction) {
+		s.applyUpdates(updates)
+	} else {
+		s.applyUnrecorded(updates)
 	}
 }
 
-// addCameraSegment converts a camera line segment (given in mm in robot body frame)
-// into map indices and marks the segment cells as obstacles. It also marks
-// cells between the robot and each obstacle cell as open (free space).
-func (s *fullSlamState) addCameraSegment(id, startMM, widthMM, distanceMM int) {
-
-	// Adjust distance for camera mounting offset
-	adjDist := distanceMM + config.CameraMountOffsetMM
-
-	// mm to cm for map coordinates (camera upside down)
-	x1Map, y1Map := int(-(startMM+widthMM)/10), int(adjDist/10)
-	x2Map, y2Map := int(-(startMM)/10), int(adjDist/10)
-
-	// Get robot pose
+// addCameraFrame converts the camera line segments of a frame (given in mm in the camera frame) into
+// map indices and marks the segment cells as obstacles. It also marks cells between the robot and
+// each obstacle cell as open (free space). A frame without segments means that nothing was seen, and
+// the free space inside the field of view is cleared.
+func (s *fullSlamState) addCameraFrame(id int, cam types.CameraMsg) {
 	robot := s.getRobot(id)
-	x_pos, y_pos, theta, ir_tower_angle := robot.X, robot.Y, robot.Theta, robot.IrTowerAngle
-
-	// Rotate segment endpoints to map frame. Theta 90 and angle 90 equals no rotation
-	x1Rotated, y1Rotated := utilities.Rotate(float64(x1Map), float64(y1Map), float64(theta+ir_tower_angle-180))
-	x2Rotated, y2Rotated := utilities.Rotate(float64(x2Map), float64(y2Map), float64(theta+ir_tower_angle-180))
-
-	// Translate to map coordinates
-	x1Map = int(math.Round(x1Rotated)) + x_pos
-	y1Map = int(math.Round(y1Rotated)) + y_pos
-	x2Map = int(math.Round(x2Rotated)) + x_pos
-	y2Map = int(math.Round(y2Rotated)) + y_pos
-
-	// get indices and clamp to map
-	x1Index, y1Index := calculateMapIndex(x1Map, y1Map)
-	x2Index, y2Index := calculateMapIndex(x2Map, y2Map)
-	x1Index = min(max(x1Index, 0), config.MapSize-1)
-	y1Index = min(max(y1Index, 0), config.MapSize-1)
-	x2Index = min(max(x2Index, 0), config.MapSize-1)
-	y2Index = min(max(y2Index, 0), config.MapSize-1)
-
-	// get the segment cells
-	segmentPoints := utilities.BresenhamAlgorithm(x1Index, y1Index, x2Index, y2Index)
-
-	// robot index
-	rxIndex, ryIndex := calculateMapIndex(robot.X, robot.Y)
-	rxIndex = min(max(rxIndex, 0), config.MapSize-1)
-	ryIndex = min(max(ryIndex, 0), config.MapSize-1)
-
-	for _, p := range segmentPoints {
-		sx := p[0]
-		sy := p[1]
-		// mark segment cell as obstacle
-		s.setMapValue(sx, sy, mapObstacle)
-
-		// mark free space between robot and obstacle (exclude obstacle cell itself)
-		lineToObs := utilities.BresenhamAlgorithm(rxIndex, ryIndex, sx, sy)
-		if len(lineToObs) > 1 {
-			for i := 0; i < len(lineToObs)-1; i++ {
-				lx := lineToObs[i][0]
-				ly := lineToObs[i][1]
-				s.setMapValue(lx, ly, mapOpen)
-			}
+	robot.IrTowerAngle = cam.IrTowerAngle //the tower may have turned since the frame was captured
+	model := cameraModelFor(id)
+	origin := bodyPose(robot).Position()
+	originIndex := clampedMapPoint(origin)
+	recorded := s.graph != nil && s.graph.currentKeyframe(id) != nil
+
+	updates := cellUpdates{}
+	if len(cam.Segments) == 0 {
+		arc := model.fieldOfView(robot, config.CameraClearRangeCm)
+		if recorded {
+			s.graph.recordWedge(id, origin, cameraPose(robot))
+		}
+		for i, p := range arc {
+			arc[i] = clampedMapPoint(p)
+		}
+		updates.addWedge(model, originIndex, arc)
+	}
+	for _, segment := range cam.Segments {
+		p1, p2, ok := model.segmentEndpoints(robot, segment.StartMM, segment.WidthMM, segment.DistanceMM)
+		if !ok {
+			continue
 		}
+		if recorded {
+			s.graph.recordSegment(id, origin, p1, p2)
+		}
+		updates.addSegment(model, originIndex, clampedMapPoint(p1), clampedMapPoint(p2))
+	}
+	if recorded {
+		s.applyUpdates(updates)
+	} else {
+		s.applyUnrecorded(updates)
 	}
 }
 
@@ -292,3 +470,23 @@ func calculateMapIndex(x, y int) (int, int) {
 	//Input is given in map coordinates (i.e. robot positions) with normal axis and origo as defined in the config.
 	return config.MapCenterX + x, config.MapCenterY - y
 }
+
+func calculateMapCoordinates(xIndex, yIndex int) (int, int) {
+	//Inverse of calculateMapIndex()
+	return xIndex - config.MapCenterX, config.MapCenterY - yIndex
+}
+
+// clampedMapIndex is calculateMapIndex() limited to the map range.
+func clampedMapIndex(x, y int) (int, int) {
+	xIndex, yIndex := calculateMapIndex(x, y)
+	return min(max(xIndex, 0), config.MapSize-1), min(max(yIndex, 0), config.MapSize-1)
+}
+
+// clampedMapPoint is clampedMapIndex for a point between cell centers.
+func clampedMapPoint(p geometry.Vec2) geometry.Vec2 {
+	limit := float64(config.MapSize - 1)
+	return geometry.Vec2{
+		X: min(max(float64(config.MapCenterX)+p.X, 0), limit),
+		Y: min(max(float64(config.MapCenterY)-p.Y, 0), limit),
+	}
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"golang-server/config"
+	"golang-server/types"
 	"golang-server/utilities"
 	"math"
 	"testing"
@@ -121,3 +122,35 @@ func TestIrSensorDataAdd(t *testing.T) {
 		t.Errorf("Function addIrSensorData did not respect the max distance. #3")
 	}
 }
+
+func TestRelocalizeRobot(t *testing.T) {
+	state := initFullSlamState()
+	id := 1
+	state.id2index[id] = 0
+	state.multiRobot = append(state.multiRobot, *initRobotState(0, 0, 90))
+	state.updateRobotPose(types.AdvMsg{Id: id, X: 500, Y: 0, Theta: 0})
+
+	//the operator puts the robot at [100, 50] facing down
+	state.relocalizeRobot(id, 100, 50, -90)
+	robot := state.getRobot(id)
+	if robot.X != 100 || robot.Y != 50 || robot.Theta != -90 {
+		t.Fatalf("Function relocalizeRobot did not move the robot. Got: (%d, %d, %d)", robot.X, robot.Y, robot.Theta)
+	}
+
+	//driving 20 cm forward in the robot frame now moves the robot down in the map
+	state.updateRobotPose(types.AdvMsg{Id: id, X: 700, Y: 0, Theta: 0})
+	robot = state.getRobot(id)
+	if robot.X != 100 || robot.Y != 30 || robot.Theta != -90 {
+		t.Errorf("Odometry after re-localization is not relative to the new pose. Expected: (100, 30, -90). Got: (%d, %d, %d)", robot.X, robot.Y, robot.Theta)
+	}
+}
+
+func TestCellCounts(t *testing.T) {
+	s := initFullSlamState()
+	s.setMapValue(10, 10, mapOpen)
+	s.setMapValue(10, 11, mapObstacle)
+	s.setMapValue(10, 11, mapOpen)
+	if s.cellCounts[mapOpen] != 2 || s.cellCounts[mapObstacle] != 0 || s.cellCounts[mapUnknown] != config.MapSize*config.MapSize-2 {
+		t.Errorf("Wrong cell counts: %v", s.cellCounts)
+	}
+}
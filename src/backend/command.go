@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/log"
+	"golang-server/metrics"
+	"golang-server/types"
+	"time"
+)
+
+// commandNames are the command types in the audit log.
+var commandNames = map[int]string{
+	types.AutomaticCommand: "auto_goal",
+	types.ManualCommand:    "goal",
+	types.TeleopCommand:    "teleop",
+	types.StopCommand:      "stop",
+	types.PauseCommand:     "pause",
+	types.ResumeCommand:    "resume",
+}
+
+// handleCommand checks a command against the operator roles, the motion state, the geofences and the
+// costmap, sends it to the robots and records it in the audit log. It returns the new motion state.
+func (s *fullSlamState) handleCommand(
+	command types.Command,
+	motion int,
+	pendingInit map[int]struct{},
+	audit *auditor,
+	chPublish chan<- types.PublishMsg,
+	chPublishControl chan<- types.PublishMsg,
+	chB2gNotice chan<- string,
+) int {
+	record := log.AuditRecord{
+		Time:     time.Now(),
+		Source:   command.Source,
+		Operator: command.Operator,
+		Role:     audit.role(command.Operator),
+		Command:  commandNames[command.CommandType],
+		Robot:    command.Id,
+		Outcome:  log.AuditSent,
+	}
+	switch command.CommandType {
+	case types.AutomaticCommand, types.ManualCommand:
+		record.Requested = &[2]int{command.X, command.Y}
+	case types.TeleopCommand:
+		record.Velocity = &[2]int{command.Linear, command.Angular}
+	}
+	defer func() { audit.write(record) }()
+	drop := func(reason string) {
+		record.Outcome, record.Reason = log.AuditDropped, reason
+		metrics.CommandsDropped.Inc(reason)
+	}
+
+	if !allowed(record.Role, command.CommandType, motion) {
+		record.Outcome, record.Reason = log.AuditRejected, "not allowed for "+record.Role
+		metrics.CommandsDropped.Inc("unauthorized")
+		//teleop commands are repeated while keys are held, so only the first one is noticed
+		if command.CommandType != types.TeleopCommand || audit.teleop[command.Id].Outcome != log.AuditRejected {
+			notify(chB2gNotice, fmt.Sprintf("Command %s from %s rejected, not allowed for role %s.", record.Command, command.Operator, record.Role))
+		}
+		return motion
+	}
+	if newMotion, ok := motionFromCommand(command.CommandType); ok {
+		record.Robot = -1
+		//the motion command is sent to every robot, so waiting robots are checked again
+		s.waiting, s.blocked, s.traffic = map[int]int{}, map[int]bool{}, nil
+		if newMotion == types.MotionStopped {
+			s.goals = map[int]activeGoal{}
+		}
+		robots := s.knownRobots(pendingInit)
+		publishMotion(chPublishControl, newMotion, robots)
+		logMotion(newMotion, robots)
+		return newMotion
+	}
+	if motion != types.MotionRunning {
+		//teleop commands are repeated while keys are held, so only goals are logged
+		drop(motionNames[motion])
+		if command.CommandType != types.TeleopCommand {
+			logger.Warn("Command dropped, robots are "+motionNames[motion], "robot", command.Id, "x", command.X, "y", command.Y)
+		}
+		return motion
+	}
+	if command.CommandType == types.AutomaticCommand || command.CommandType == types.ManualCommand {
+		x, y, notice, ok := s.checkGoal(command.X, command.Y)
+		if notice != "" {
+			notify(chB2gNotice, notice)
+		}
+		if !ok {
+			drop("geofence")
+			return motion
+		}
+		if config.UseCostmap {
+			x, y, notice, ok = s.checkClearance(command.Id, x, y)
+			if notice != "" {
+				notify(chB2gNotice, notice)
+			}
+			if !ok {
+				drop("clearance")
+				return motion
+			}
+		}
+		command.X, command.Y = x, y
+		record.Map = &[2]int{x, y}
+	}
+	switch command.CommandType {
+	case types.AutomaticCommand:
+		id := s.findClosestRobot(command.X, command.Y)
+		record.Robot = id
+		if id == -1 {
+			//already logged in findClosestRobot()
+			drop("no_robot")
+			return motion
+		}
+		record.Body = s.sendGoal(chPublish, chPublishControl, id, command.X, command.Y)
+		s.recordCommand(command, id, record.Time)
+		logger.Info("Publishing automatic input", "robot", id, "x", command.X, "y", command.Y)
+	case types.ManualCommand:
+		if _, exist := s.id2index[command.Id]; !exist {
+			logger.Warn("Goal for uninitialized robot dropped", "robot", command.Id)
+			drop("unknown_robot")
+			return motion
+		}
+		record.Body = s.sendGoal(chPublish, chPublishControl, command.Id, command.X, command.Y)
+		s.recordCommand(command, command.Id, record.Time)
+		logger.Info("Publishing manual input", "robot", command.Id, "x", command.X, "y", command.Y)
+	case types.TeleopCommand:
+		//velocities are in the robot frame, so no conversion is needed. Logged by the gui when they change.
+		//the operator drives the robot, so it has no path to plan
+		delete(s.goals, command.Id)
+		s.releaseTrafficWait(chPublishControl, command.Id)
+		s.recordCommand(command, command.Id, record.Time)
+		chPublish <- types.PublishMsg{Kind: types.PublishVelocity, Id: command.Id, Values: [2]int{command.Linear, command.Angular}}
+	}
+	return motion
+}
+
+// sendGoal publishes a goal given in map coordinates, and returns it as published: in mm, in the init
+// frame of the robot, see frames.go.
+func (s *fullSlamState) sendGoal(chPublish, chPublishControl chan<- types.PublishMsg, id, x, y int) *[2]int {
+	goal := initPose(s.getRobot(id)).Inverse().Apply(geometry.Vec2{X: float64(x), Y: float64(y)})
+	xRobotBody, yRobotBody := goal.RoundMm()
+	s.releaseTrafficWait(chPublishControl, id)
+	chPublish <- types.PublishMsg{Kind: types.PublishTarget, Id: id, Values: [2]int{xRobotBody, yRobotBody}}
+	s.setGoal(id, x, y, time.Now())
+	return &[2]int{xRobotBody, yRobotBody}
+}
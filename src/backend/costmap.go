@@ -0,0 +1,245 @@
+package backend
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/export"
+	"golang-server/types"
+	"golang-server/utilities"
+	"math"
+)
+
+// The costmap tells how close a robot can get to every cell. It is made of layers:
+//
+//	static     obstacles of the map loaded from config.StaticMapFile
+//	obstacle   obstacles of the live map
+//	keep-out   cells inside the keep-out zones
+//	inflation  the cost of cells near the obstacles of the layers above, for the footprint of a robot
+//
+// The first three layers give the lethal cells. The distance from every cell to the closest lethal cell
+// is kept up to date, so the inflation only depends on the radius of the robot that asks.
+
+const (
+	costFree      uint8 = 0
+	costInscribed uint8 = 253 //the footprint of the robot touches an obstacle
+	costLethal    uint8 = 254 //an obstacle or keep-out cell
+	costUnknown   uint8 = 255 //no layer knows the cell
+)
+
+const costBucketsPerCm = 4 //resolution of the distance ordering in updateCostmap
+
+type costmap struct {
+	static   *mapGrid                                  //nil without config.StaticMapFile
+	keepOut  [config.MapSize][config.MapSize]bool      //inside a keep-out zone
+	distance [config.MapSize][config.MapSize]float32   //cm to the closest lethal cell, capped at maxInflation()
+	source   [config.MapSize][config.MapSize][2]uint16 //closest lethal cell
+	dirty    bool                                      //the lethal cells changed since the distances were computed
+	changed  bool                                      //the distances changed since the last gui update
+}
+
+func initCostmap() *costmap {
+	c := &costmap{dirty: true}
+	if config.StaticMapFile != "" {
+		static, err := export.LoadMapImage(config.StaticMapFile)
+		if err != nil {
+			logger.Error("Failed to load the static map, the costmap has no static layer", "file", config.StaticMapFile, "err", err)
+		} else {
+			c.static = static
+			logger.Info("Loaded static map", "file", config.StaticMapFile)
+		}
+	}
+	return c
+}
+
+// robotRadius is the footprint radius of a robot. An unknown robot (-1), e.g. for automatic goals, gets
+// the largest radius.
+func robotRadius(id int) float64 {
+	if radius, exist := config.RobotRadii[id]; exist {
+		return radius
+	}
+	if id != -1 {
+		return config.RobotRadius
+	}
+	radius := float64(config.RobotRadius)
+	for _, r := range config.RobotRadii {
+		radius = max(radius, r)
+	}
+	return radius
+}
+
+// maxInflation is the distance from an obstacle where the cost is zero for every robot.
+func maxInflation() float64 {
+	return robotRadius(-1) + config.InflationRadius
+}
+
+func (s *fullSlamState) lethal(x, y int) bool {
+	c := s.costmap
+	return s.areaMap[x][y] == mapObstacle || c.keepOut[x][y] || (c.static != nil && c.static[x][y] == mapObstacle)
+}
+
+func (s *fullSlamState) known(x, y int) bool {
+	return s.areaMap[x][y] != mapUnknown || (s.costmap.static != nil && s.costmap.static[x][y] != mapUnknown)
+}
+
+// setKeepOutLayer rasterizes the keep-out zones.
+func (s *fullSlamState) setKeepOutLayer() {
+	c := s.costmap
+	c.keepOut = [config.MapSize][config.MapSize]bool{}
+	for _, zone := range s.zones {
+		if zone.Kind != types.ZoneKeepOut {
+			continue
+		}
+		polygon := make([][2]float64, len(zone.Points))
+		for i, p := range zone.Points {
+			x, y := calculateMapIndex(p[0], p[1])
+			polygon[i] = [2]float64{float64(x), float64(y)}
+		}
+		utilities.FillPolygon(polygon, func(x, y int) {
+			if x >= 0 && x < config.MapSize && y >= 0 && y < config.MapSize {
+				c.keepOut[x][y] = true
+			}
+		})
+	}
+	c.dirty = true
+}
+
+// updateCostmap computes the distance to the closest lethal cell if the lethal cells have changed. The
+// distances grow outward from the lethal cells in order, each cell keeping the lethal cell it was
+// reached from, so the distances are euclidean up to small errors where two obstacles meet.
+func (s *fullSlamState) updateCostmap() {
+	c := s.costmap
+	if !c.dirty {
+		return
+	}
+	c.dirty, c.changed = false, true
+	limit := float32(maxInflation())
+	buckets := make([][][2]uint16, int(limit*costBucketsPerCm)+1)
+	for x := 0; x < config.MapSize; x++ {
+		for y := 0; y < config.MapSize; y++ {
+			c.distance[x][y] = math.MaxFloat32
+			if s.lethal(x, y) {
+				c.distance[x][y], c.source[x][y] = 0, [2]uint16{uint16(x), uint16(y)}
+				buckets[0] = append(buckets[0], [2]uint16{uint16(x), uint16(y)})
+			}
+		}
+	}
+	for b := range buckets {
+		for i := 0; i < len(buckets[b]); i++ {
+			x, y := int(buckets[b][i][0]), int(buckets[b][i][1])
+			if int(c.distance[x][y]*costBucketsPerCm) != b {
+				continue //reached again at a shorter distance
+			}
+			source := c.source[x][y]
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || nx >= config.MapSize || ny < 0 || ny >= config.MapSize {
+						continue
+					}
+					d := float32(math.Hypot(float64(nx-int(source[0])), float64(ny-int(source[1]))))
+					if d < c.distance[nx][ny] && d <= limit {
+						c.distance[nx][ny], c.source[nx][ny] = d, source
+						next := int(d * costBucketsPerCm)
+						buckets[next] = append(buckets[next], [2]uint16{uint16(nx), uint16(ny)})
+					}
+				}
+			}
+		}
+	}
+}
+
+// costAt returns the cost of a cell given by its map indices, for a robot with the given footprint
+// radius. The cost falls exponentially from the footprint to zero at config.InflationRadius past it.
+func (s *fullSlamState) costAt(x, y int, radius float64) uint8 {
+	if x < 0 || x >= config.MapSize || y < 0 || y >= config.MapSize {
+		return costLethal
+	}
+	d := float64(s.costmap.distance[x][y])
+	switch {
+	case d == 0:
+		return costLethal
+	case d <= radius:
+		return costInscribed
+	case d <= radius+config.InflationRadius:
+		return max(uint8(float64(costInscribed-1)*math.Exp(-config.CostScalingFactor*(d-radius))), 1)
+	case !s.known(x, y):
+		return costUnknown
+	}
+	return costFree
+}
+
+// blocked returns true if the footprint of a robot touches an obstacle at a cell with the cost. Unknown
+// cells are not blocked, so goals can be sent to explore them.
+func blocked(cost uint8) bool {
+	return cost == costInscribed || cost == costLethal
+}
+
+// cost returns the cost of a point in map coordinates for a robot, e.g. for a planner.
+func (s *fullSlamState) cost(id, x, y int) uint8 {
+	s.updateCostmap()
+	xIndex, yIndex := calculateMapIndex(x, y)
+	return s.costAt(xIndex, yIndex, robotRadius(id))
+}
+
+// pathCost returns the highest cost on the straight line between two points in map coordinates, for a
+// robot. Unknown cells do not count.
+func (s *fullSlamState) pathCost(id, x0, y0, x1, y1 int) uint8 {
+	s.updateCostmap()
+	radius := robotRadius(id)
+	x0Index, y0Index := calculateMapIndex(x0, y0)
+	x1Index, y1Index := calculateMapIndex(x1, y1)
+	highest := costFree
+	utilities.SupercoverLine(float64(x0Index), float64(y0Index), float64(x1Index), float64(y1Index), func(x, y int) {
+		if cost := s.costAt(x, y, radius); cost != costUnknown {
+			highest = max(highest, cost)
+		}
+	})
+	return highest
+}
+
+// checkClearance returns the goal to send instead of (x, y), given in map coordinates, so that the
+// footprint of the robot does not touch an obstacle. A goal that is too close is moved to the closest
+// point with clearance within config.CostmapGoalSearch, unless config.GeofenceClipGoals is false.
+func (s *fullSlamState) checkClearance(id, x, y int) (xGoal, yGoal int, notice string, ok bool) {
+	if !blocked(s.cost(id, x, y)) {
+		return x, y, "", true
+	}
+	if !config.GeofenceClipGoals {
+		return 0, 0, fmt.Sprintf("Goal (%d, %d) rejected: too close to an obstacle.", x, y), false
+	}
+	radius := robotRadius(id)
+	bestDistance := math.Inf(1)
+	for dx := -config.CostmapGoalSearch; dx <= config.CostmapGoalSearch; dx++ {
+		for dy := -config.CostmapGoalSearch; dy <= config.CostmapGoalSearch; dy++ {
+			d := math.Hypot(float64(dx), float64(dy))
+			if d >= bestDistance || d > config.CostmapGoalSearch {
+				continue
+			}
+			xIndex, yIndex := calculateMapIndex(x+dx, y+dy)
+			if !blocked(s.costAt(xIndex, yIndex, radius)) && s.goalViolation(float64(x+dx), float64(y+dy)) == "" {
+				bestDistance, xGoal, yGoal = d, x+dx, y+dy
+			}
+		}
+	}
+	if math.IsInf(bestDistance, 1) {
+		return 0, 0, fmt.Sprintf("Goal (%d, %d) rejected: too close to an obstacle, and no clear position was found nearby.", x, y), false
+	}
+	return xGoal, yGoal, fmt.Sprintf("Goal (%d, %d) moved to (%d, %d): too close to an obstacle.", x, y, xGoal, yGoal), true
+}
+
+// takeCostmap returns the costs for config.RobotRadius if they have changed since the last call, for
+// the gui. Indexed by x*config.MapSize+y in map indices.
+func (s *fullSlamState) takeCostmap() []uint8 {
+	s.updateCostmap()
+	if !s.costmap.changed {
+		return nil
+	}
+	s.costmap.changed = false
+	costs := make([]uint8, config.MapSize*config.MapSize)
+	for x := 0; x < config.MapSize; x++ {
+		for y := 0; y < config.MapSize; y++ {
+			costs[x*config.MapSize+y] = s.costAt(x, y, config.RobotRadius)
+		}
+	}
+	return costs
+}
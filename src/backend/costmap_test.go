@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/types"
+	"math"
+	"testing"
+)
+
+// wall puts an obstacle on the line x = 0 of the map, from y = -50 to 50.
+func wall(s *fullSlamState) {
+	for y := -50; y <= 50; y++ {
+		xIndex, yIndex := calculateMapIndex(0, y)
+		s.setMapValue(xIndex, yIndex, mapObstacle)
+	}
+}
+
+func TestCostmapInflation(t *testing.T) {
+	s := initFullSlamState()
+	wall(s)
+	if cost := s.cost(1, 0, 0); cost != costLethal {
+		t.Errorf("Obstacle has cost %d", cost)
+	}
+	if cost := s.cost(1, config.RobotRadius, 0); cost != costInscribed {
+		t.Errorf("Cell at the robot radius has cost %d", cost)
+	}
+	previous := costInscribed
+	for x := config.RobotRadius + 1; x <= config.RobotRadius+config.InflationRadius; x++ {
+		cost := s.cost(1, x, 0)
+		if cost == 0 || cost > previous {
+			t.Errorf("Cost %d at %d cm from the wall, %d closer", cost, x, previous)
+		}
+		previous = cost
+	}
+	if cost := s.cost(1, config.RobotRadius+config.InflationRadius+1, 0); cost != costUnknown {
+		t.Errorf("Unknown cell past the inflation has cost %d", cost)
+	}
+	//the distance is euclidean, past the end of the wall
+	xIndex, yIndex := calculateMapIndex(6, 58)
+	if d := s.costmap.distance[xIndex][yIndex]; math.Abs(float64(d)-10) > 1e-3 {
+		t.Errorf("Distance %f to the end of the wall, expected 10", d)
+	}
+
+	//a larger robot is inscribed further away
+	config.RobotRadii[2] = 20
+	defer delete(config.RobotRadii, 2)
+	if cost := s.cost(2, 15, 0); cost != costInscribed {
+		t.Errorf("Cell inside the footprint of a large robot has cost %d", cost)
+	}
+	if cost := s.cost(-1, 15, 0); cost != costInscribed {
+		t.Errorf("Cell inside the largest footprint has cost %d for an unknown robot", cost)
+	}
+
+	//removing the wall clears the costs
+	for y := -50; y <= 50; y++ {
+		xIndex, yIndex := calculateMapIndex(0, y)
+		s.setMapValue(xIndex, yIndex, mapOpen)
+	}
+	if cost := s.cost(1, 0, 0); cost != costFree {
+		t.Errorf("Open cell has cost %d", cost)
+	}
+}
+
+func TestCostmapKeepOut(t *testing.T) {
+	s := initFullSlamState()
+	s.zones = []types.Zone{{Name: "table", Kind: types.ZoneKeepOut, Points: [][2]int{{0, 0}, {20, 0}, {20, 20}, {0, 20}}}}
+	s.setKeepOutLayer()
+	if cost := s.cost(1, 10, 10); cost != costLethal {
+		t.Errorf("Cell in a keep-out zone has cost %d", cost)
+	}
+	if cost := s.cost(1, 25, 10); cost != costInscribed {
+		t.Errorf("Cell next to a keep-out zone has cost %d", cost)
+	}
+	if cost := s.pathCost(1, -50, 10, 50, 10); cost != costLethal {
+		t.Errorf("Path through a keep-out zone has cost %d", cost)
+	}
+	if cost := s.pathCost(1, -50, 60, 50, 60); cost != costFree {
+		t.Errorf("Path far from a keep-out zone has cost %d", cost)
+	}
+}
+
+func TestCheckClearance(t *testing.T) {
+	s := initFullSlamState()
+	wall(s)
+	if x, y, notice, ok := s.checkClearance(1, 100, 100); !ok || notice != "" {
+		t.Errorf("Goal in unknown space changed to (%d, %d), notice %q", x, y, notice)
+	}
+	if x, y, notice, ok := s.checkClearance(1, 40, 0); !ok || x != 40 || y != 0 || notice != "" {
+		t.Errorf("Clear goal changed to (%d, %d), notice %q", x, y, notice)
+	}
+	x, y, notice, ok := s.checkClearance(1, 5, 0)
+	if !ok || notice == "" || x <= config.RobotRadius || y != 0 {
+		t.Errorf("Goal next to the wall moved to (%d, %d), ok %v, notice %q", x, y, ok, notice)
+	}
+	if cost := s.cost(1, x, y); blocked(cost) {
+		t.Errorf("Goal moved to a cell with cost %d", cost)
+	}
+
+	//a goal surrounded by obstacles has nowhere to go
+	for x := -60; x <= 60; x++ {
+		for y := -60; y <= 60; y++ {
+			xIndex, yIndex := calculateMapIndex(x, y)
+			s.setMapValue(xIndex, yIndex, mapObstacle)
+		}
+	}
+	if _, _, notice, ok := s.checkClearance(1, 0, 0); ok || notice == "" {
+		t.Errorf("Goal inside an obstacle was not rejected")
+	}
+}
+
+func TestCostmapStaticLayer(t *testing.T) {
+	s := initFullSlamState()
+	static := &mapGrid{}
+	static[2][0] = mapObstacle
+	s.costmap.static = static
+	s.costmap.dirty = true
+	s.updateCostmap()
+	//the static layer is lethal where the live map is unknown
+	if cost := s.costAt(2, 0, config.RobotRadius); cost != costLethal {
+		t.Errorf("Static obstacle has cost %d", cost)
+	}
+	if cost := s.costAt(3, 0, config.RobotRadius); cost != costInscribed {
+		t.Errorf("Cell next to a static obstacle has cost %d", cost)
+	}
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"encoding/csv"
+	"fmt"
+	"golang-server/config"
+	"golang-server/log"
+	"golang-server/types"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exploration statistics. Cells are 1 cm², so 10000 cells make a square meter. A cell is discovered by
+// the robot whose sensor data first makes it open or an obstacle, and is only counted once, even if the
+// map is rebuilt. The regions of interest are zones of kind types.ZoneInterest.
+
+type coverageState struct {
+	started    time.Time
+	mapper     int                                  //robot whose sensor data is added to the map, -1 for none
+	seen       [config.MapSize][config.MapSize]bool //explored at some point
+	discovered map[int]int                          //robot id -> cells
+	distance   map[int]float64                      //robot id -> cm
+	lastPose   map[int][2]int                       //robot id -> x, y [cm] when the distance was last updated
+	roi        [][2]int                             //map indices of the cells inside the regions of interest
+}
+
+func initCoverageState() coverageState {
+	return coverageState{
+		started:    time.Now(),
+		mapper:     -1,
+		discovered: make(map[int]int),
+		distance:   make(map[int]float64),
+		lastPose:   make(map[int][2]int),
+	}
+}
+
+// countDiscovered is called by setMapValue before the cell changes.
+func (c *coverageState) countDiscovered(x, y int, value uint8) {
+	if value == mapUnknown || c.seen[x][y] {
+		return
+	}
+	c.seen[x][y] = true
+	if c.mapper != -1 {
+		c.discovered[c.mapper]++
+	}
+}
+
+// updateDistance adds the distance from the previous pose of the robot. Call it once the pose is corrected.
+func (s *fullSlamState) updateDistance(id int) {
+	robot := s.getRobot(id)
+	if last, exist := s.coverage.lastPose[id]; exist {
+		s.coverage.distance[id] += math.Hypot(float64(robot.X-last[0]), float64(robot.Y-last[1]))
+	}
+	s.coverage.lastPose[id] = [2]int{robot.X, robot.Y}
+}
+
+// setRegionsOfInterest finds the cells inside the zones of kind types.ZoneInterest.
+func (s *fullSlamState) setRegionsOfInterest() {
+	s.coverage.roi = nil
+	if !slices.ContainsFunc(s.zones, func(zone types.Zone) bool { return zone.Kind == types.ZoneInterest }) {
+		return
+	}
+	for xIndex := 0; xIndex < config.MapSize; xIndex++ {
+		for yIndex := 0; yIndex < config.MapSize; yIndex++ {
+			x, y := calculateMapCoordinates(xIndex, yIndex)
+			for _, zone := range s.zones {
+				if zone.Kind == types.ZoneInterest && insidePolygon(zone.Points, float64(x), float64(y)) {
+					s.coverage.roi = append(s.coverage.roi, [2]int{xIndex, yIndex})
+					break
+				}
+			}
+		}
+	}
+}
+
+func (s *fullSlamState) coverageStats(now time.Time) types.CoverageStats {
+	stats := types.CoverageStats{
+		Elapsed:       now.Sub(s.coverage.started),
+		ExploredM2:    float64(s.cellCounts[mapOpen]+s.cellCounts[mapObstacle]) / 10000,
+		ObstacleCells: s.cellCounts[mapObstacle],
+		RoiCoverage:   -1,
+	}
+	if len(s.coverage.roi) > 0 {
+		explored := 0
+		for _, cell := range s.coverage.roi {
+			if s.areaMap[cell[0]][cell[1]] != mapUnknown {
+				explored++
+			}
+		}
+		stats.RoiCoverage = float64(explored) / float64(len(s.coverage.roi))
+	}
+	for id := range s.id2index {
+		stats.Robots = append(stats.Robots, types.RobotCoverage{
+			Id:           id,
+			DistanceM:    s.coverage.distance[id] / 100,
+			DiscoveredM2: float64(s.coverage.discovered[id]) / 10000,
+		})
+	}
+	sort.Slice(stats.Robots, func(i, j int) bool { return stats.Robots[i].Id < stats.Robots[j].Id })
+	return stats
+}
+
+// coverageLogger writes the statistics to coverage.csv in the session directory, one row for the whole
+// map, with robot_id "all", and one row per robot.
+type coverageLogger struct {
+	csv     *csv.Writer
+	close   func() error
+	lastRow time.Time
+}
+
+func initCoverageLogger() (*coverageLogger, error) {
+	file, err := log.CreateSessionFile("coverage.csv")
+	if err != nil {
+		return nil, err
+	}
+	l := &coverageLogger{csv: csv.NewWriter(file), close: file.Close}
+	l.csv.Write([]string{"wall_time", "elapsed_s", "robot_id", "distance_m", "discovered_m2", "explored_m2", "obstacle_cells", "roi_coverage_pct"})
+	l.csv.Flush()
+	return l, l.csv.Error()
+}
+
+// write adds the rows if config.CoverageLogInterval has passed since the last ones.
+func (l *coverageLogger) write(now time.Time, stats types.CoverageStats) error {
+	if l == nil || now.Sub(l.lastRow) < config.CoverageLogInterval*time.Second {
+		return nil
+	}
+	l.lastRow = now
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	roi := ""
+	if stats.RoiCoverage >= 0 {
+		roi = format(stats.RoiCoverage * 100)
+	}
+	row := func(id string, distance, discovered float64) []string {
+		return []string{now.Format(time.RFC3339Nano), format(stats.Elapsed.Seconds()), id, format(distance), format(discovered),
+			format(stats.ExploredM2), strconv.Itoa(stats.ObstacleCells), roi}
+	}
+	distance, discovered := 0.0, 0.0
+	for _, robot := range stats.Robots {
+		distance += robot.DistanceM
+		discovered += robot.DiscoveredM2
+	}
+	l.csv.Write(row("all", distance, discovered))
+	for _, robot := range stats.Robots {
+		l.csv.Write(row(strconv.Itoa(robot.Id), robot.DistanceM, robot.DiscoveredM2))
+	}
+	l.csv.Flush()
+	return l.csv.Error()
+}
+
+func (l *coverageLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.csv.Flush()
+	return l.close()
+}
+
+// coverageSummary is written to summary.txt and the log when the server is closed.
+func coverageSummary(stats types.CoverageStats) string {
+	lines := []string{
+		fmt.Sprintf("Session length: %s", stats.Elapsed.Round(time.Second)),
+		fmt.Sprintf("Explored area: %.2f m²", stats.ExploredM2),
+		fmt.Sprintf("Obstacle cells: %d", stats.ObstacleCells),
+	}
+	if stats.RoiCoverage >= 0 {
+		lines = append(lines, fmt.Sprintf("Region of interest coverage: %.1f %%", stats.RoiCoverage*100))
+	} else {
+		lines = append(lines, "Region of interest coverage: no region of interest")
+	}
+	for _, robot := range stats.Robots {
+		lines = append(lines, fmt.Sprintf("NRF-%d: %.2f m travelled, %.2f m² discovered", robot.Id, robot.DistanceM, robot.DiscoveredM2))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// writeCoverageSummary writes the summary to summary.txt in the session directory.
+func writeCoverageSummary(stats types.CoverageStats) error {
+	summary := coverageSummary(stats)
+	logger.Info("Coverage summary", "summary", summary)
+	file, err := log.CreateSessionFile("summary.txt")
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(summary); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
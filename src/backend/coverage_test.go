@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"golang-server/types"
+	"math"
+	"testing"
+)
+
+func TestCoverageStats(t *testing.T) {
+	state := initFullSlamState()
+	state.id2index[1] = 0
+	state.multiRobot = append(state.multiRobot, *initRobotState(0, 0, 90))
+	state.zones = []types.Zone{{Name: "room", Kind: types.ZoneInterest, Points: [][2]int{{0, 0}, {10, 0}, {10, 10}, {0, 10}}}}
+	state.setRegionsOfInterest()
+
+	//the first pose only starts the distance, re-localizing is not travelled
+	state.updateDistance(1)
+	state.multiRobot[0].X, state.multiRobot[0].Y = 30, 40
+	state.updateDistance(1)
+	state.relocalizeRobot(1, 100, 100, 90)
+	state.updateDistance(1)
+
+	state.coverage.mapper = 1
+	for x := 0; x < 5; x++ {
+		xIndex, yIndex := calculateMapIndex(x, 5)
+		state.setMapValue(xIndex, yIndex, mapOpen)
+	}
+	state.coverage.mapper = -1
+	//already explored by robot 1, and cells explored without a robot are not counted
+	xIndex, yIndex := calculateMapIndex(0, 5)
+	state.setMapValue(xIndex, yIndex, mapObstacle)
+	xIndex, yIndex = calculateMapIndex(-50, -50)
+	state.setMapValue(xIndex, yIndex, mapOpen)
+
+	stats := state.coverageStats(state.coverage.started)
+	if stats.ExploredM2 != 0.0006 || stats.ObstacleCells != 1 {
+		t.Errorf("Explored %v m², %d obstacle cells, expected 0.0006 m², 1", stats.ExploredM2, stats.ObstacleCells)
+	}
+	if roiCells := len(state.coverage.roi); math.Abs(stats.RoiCoverage-5/float64(roiCells)) > 1e-9 {
+		t.Errorf("Region of interest coverage %v, expected 5 of %d cells", stats.RoiCoverage, roiCells)
+	}
+	if len(stats.Robots) != 1 || stats.Robots[0].DistanceM != 0.5 || stats.Robots[0].DiscoveredM2 != 0.0005 {
+		t.Errorf("Wrong robot statistics: %+v", stats.Robots)
+	}
+
+	state.zones = nil
+	state.setRegionsOfInterest()
+	if stats := state.coverageStats(state.coverage.started); stats.RoiCoverage != -1 {
+		t.Errorf("Coverage %v without a region of interest", stats.RoiCoverage)
+	}
+}
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/metrics"
+	"golang-server/types"
+)
+
+// The map is static unless something contradicts it. Two things let it follow a changing scene:
+//
+//   - change detection: a cell that flips between open and obstacle, e.g. a person walking into open
+//     space or a door that opens, is a change. Changes are highlighted in the gui for
+//     config.ChangeHighlightTime and the cell is marked as dynamic.
+//   - decay: cells that have not been observed for config.CellDecayAfter seconds move toward unknown,
+//     dynamic cells faster than the rest. An observation that contradicts a cell also removes part of
+//     its log-odds first, so the map changes after a few observations instead of many.
+//
+// Time is counted in decay ticks, config.CellDecayInterval seconds apart.
+
+type dynamicState struct {
+	tick     uint32
+	lastSeen [config.MapSize][config.MapSize]uint32 //tick the cell was last observed
+	known    [config.MapSize][config.MapSize]uint8  //last value other than unknown, 0 if never known
+	dynamic  [config.MapSize][config.MapSize]bool   //the cell has changed at least once
+	changes  map[[2]int]uint32                      //highlighted changes, map index -> tick of the change
+	detect   bool                                   //config.UseChangeDetection
+	paused   bool                                   //no changes are detected while the map is rebuilt
+	updates  []types.MapChange                      //new since last gui update
+}
+
+func initDynamicState() dynamicState {
+	return dynamicState{changes: make(map[[2]int]uint32), detect: config.UseChangeDetection}
+}
+
+// contradicts returns true if a log-odds change is the opposite of what the map has in the cell.
+func (s *fullSlamState) contradicts(x, y int, logOdds float32) bool {
+	return (logOdds < 0 && s.areaMap[x][y] == mapObstacle) || (logOdds > 0 && s.areaMap[x][y] == mapOpen)
+}
+
+// observeCell is called before an observation is added to a cell.
+func (s *fullSlamState) observeCell(x, y int, logOdds float32) {
+	s.dynamic.lastSeen[x][y] = s.dynamic.tick
+	if config.UseCellDecay && s.contradicts(x, y, logOdds) {
+		s.logOdds[x][y] *= config.ContradictionKeep
+	}
+}
+
+// detectChange is called when the value of a cell changes. A cell that was open and is now an obstacle,
+// or the other way around, has changed.
+func (s *fullSlamState) detectChange(x, y int, value uint8) {
+	d := &s.dynamic
+	if value == mapUnknown {
+		return
+	}
+	previous := d.known[x][y]
+	d.known[x][y] = value
+	if !d.detect || d.paused || previous == 0 || previous == value {
+		return
+	}
+	kind, name := types.ChangeAppeared, "appeared"
+	if value == mapOpen {
+		kind, name = types.ChangeVanished, "vanished"
+	}
+	d.dynamic[x][y] = true
+	d.changes[[2]int{x, y}] = d.tick
+	d.updates = append(d.updates, types.MapChange{X: x, Y: y, Kind: kind})
+	metrics.MapChanges.Inc(name)
+}
+
+// tickDynamic advances the decay tick, decays the map and clears highlighted changes that have expired.
+func (s *fullSlamState) tickDynamic() {
+	s.dynamic.tick++
+	if config.UseCellDecay {
+		s.decayCells()
+	}
+	s.expireChanges()
+}
+
+// decayCells moves the cells that have not been observed for config.CellDecayAfter toward unknown.
+func (s *fullSlamState) decayCells() {
+	d := &s.dynamic
+	after := uint32(config.CellDecayAfter / config.CellDecayInterval)
+	step := float32(config.CellDecayRate * config.CellDecayInterval)
+	dynamicStep := float32(config.DynamicCellDecayRate * config.CellDecayInterval)
+	for x := 0; x < config.MapSize; x++ {
+		for y := 0; y < config.MapSize; y++ {
+			if s.logOdds[x][y] == 0 || d.tick-d.lastSeen[x][y] < after {
+				continue
+			}
+			decay := step
+			if d.dynamic[x][y] {
+				decay = dynamicStep
+			}
+			if s.logOdds[x][y] > 0 {
+				s.logOdds[x][y] = max(s.logOdds[x][y]-decay, 0)
+			} else {
+				s.logOdds[x][y] = min(s.logOdds[x][y]+decay, 0)
+			}
+			s.classifyCell(x, y)
+		}
+	}
+}
+
+func (s *fullSlamState) expireChanges() {
+	d := &s.dynamic
+	expired := uint32(config.ChangeHighlightTime / config.CellDecayInterval)
+	for cell, tick := range d.changes {
+		if d.tick-tick >= expired {
+			delete(d.changes, cell)
+			d.updates = append(d.updates, types.MapChange{X: cell[0], Y: cell[1], Kind: types.ChangeCleared})
+		}
+	}
+}
+
+// takeChanges returns the changes since the last call, for the gui.
+func (d *dynamicState) takeChanges() []types.MapChange {
+	updates := d.updates
+	d.updates = nil
+	return updates
+}
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/types"
+	"slices"
+	"testing"
+)
+
+func TestChangeDetection(t *testing.T) {
+	s := initFullSlamState()
+	s.dynamic.detect = true
+	id := 2
+	s.id2index[id] = len(s.multiRobot)
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+	xIndex, yIndex := calculateMapIndex(20, 0)
+
+	//an obstacle seen for the first time is not a change
+	s.addLineToMap(id, 20, 0)
+	if changes := s.dynamic.takeChanges(); len(changes) != 0 {
+		t.Fatalf("A new obstacle was reported as a change: %v", changes)
+	}
+
+	//the obstacle moves away, e.g. a door that opens
+	for s.areaMap[xIndex][yIndex] != mapOpen {
+		s.addLineToMap(id, 40, 0)
+	}
+	expected := []types.MapChange{{X: xIndex, Y: yIndex, Kind: types.ChangeVanished}}
+	if changes := s.dynamic.takeChanges(); !slices.Equal(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+
+	//and comes back
+	for s.areaMap[xIndex][yIndex] != mapObstacle {
+		s.addLineToMap(id, 20, 0)
+	}
+	expected = []types.MapChange{{X: xIndex, Y: yIndex, Kind: types.ChangeAppeared}}
+	if changes := s.dynamic.takeChanges(); !slices.Equal(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+	if !s.dynamic.dynamic[xIndex][yIndex] {
+		t.Errorf("A cell that changed was not marked as dynamic")
+	}
+
+	//the highlight expires
+	for i := 0; i < config.ChangeHighlightTime/config.CellDecayInterval; i++ {
+		s.tickDynamic()
+	}
+	expected = []types.MapChange{{X: xIndex, Y: yIndex, Kind: types.ChangeCleared}}
+	if changes := s.dynamic.takeChanges(); !slices.Equal(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+}
+
+func TestCellDecay(t *testing.T) {
+	s := initFullSlamState()
+	id := 2
+	s.id2index[id] = len(s.multiRobot)
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+	static, _ := calculateMapIndex(20, 0)
+	_, moving := calculateMapIndex(0, 20)
+	for i := 0; i < 3; i++ {
+		s.addLineToMap(id, 20, 0)
+		s.addLineToMap(id, 0, 20)
+	}
+	s.dynamic.dynamic[config.MapCenterX][moving] = true
+
+	//nothing decays while the cells are observed
+	s.dynamic.tick += config.CellDecayAfter/config.CellDecayInterval - 1
+	s.decayCells()
+	if s.areaMap[static][config.MapCenterY] != mapObstacle || s.areaMap[config.MapCenterX][moving] != mapObstacle {
+		t.Fatalf("Cells observed recently decayed")
+	}
+
+	//a dynamic cell fades to unknown before a static cell
+	steps := 0
+	for s.areaMap[config.MapCenterX][moving] != mapUnknown {
+		s.dynamic.tick++
+		s.decayCells()
+		steps++
+	}
+	if s.areaMap[static][config.MapCenterY] != mapObstacle {
+		t.Errorf("A static cell faded before a dynamic cell")
+	}
+	if maxSteps := int(logOddsMax/(config.DynamicCellDecayRate*config.CellDecayInterval)) + 1; steps > maxSteps {
+		t.Errorf("A dynamic cell took %d steps to fade, expected at most %d", steps, maxSteps)
+	}
+	for s.logOdds[static][config.MapCenterY] > 0 {
+		s.dynamic.tick++
+		s.decayCells()
+	}
+	if s.areaMap[static][config.MapCenterY] != mapUnknown {
+		t.Errorf("A static cell without log-odds is not unknown")
+	}
+}
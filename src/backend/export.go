@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/export"
+	"golang-server/types"
+)
+
+// Exports of the live state, requested from the gui. The scene is copied in the backend loop and written
+// in a goroutine, so a large PNG does not hold up the map updates.
+
+// recordTrajectory adds the current position of a robot to its trajectory.
+func (s *fullSlamState) recordTrajectory(id int) {
+	robot := s.getRobot(id)
+	s.tracks[id] = export.AppendPosition(s.tracks[id], float64(robot.X), float64(robot.Y))
+}
+
+// exportScene copies the map, the trajectories, the poses and the active goals.
+func (s *fullSlamState) exportScene() *export.Scene {
+	grid := mapGrid(s.areaMap)
+	scene := &export.Scene{Map: &grid, Trajectories: map[int][][2]float64{}, Poses: map[int][3]float64{}, Goals: map[int][2]float64{}}
+	for id, trajectory := range s.tracks {
+		scene.Trajectories[id] = trajectory //the backend only appends, past the positions read by the export
+	}
+	for id, index := range s.id2index {
+		robot := s.multiRobot[index]
+		scene.Poses[id] = [3]float64{float64(robot.X), float64(robot.Y), float64(robot.Theta)}
+	}
+	for id, goal := range s.goals {
+		scene.Goals[id] = [2]float64{float64(goal.x), float64(goal.y)}
+	}
+	return scene
+}
+
+// handleExport writes the scene in the background and tells the operator when it is done.
+func (s *fullSlamState) handleExport(request types.ExportRequest, chB2gNotice chan<- string) {
+	layers, err := export.ParseLayers(request.Layers)
+	if err != nil {
+		request.Writer.Close()
+		notify(chB2gNotice, fmt.Sprintf("Export of %s failed: %v", request.Name, err))
+		return
+	}
+	scene := s.exportScene()
+	options := export.Options{Layers: layers, Crop: request.Crop, Scale: config.ExportPngScale}
+	go func() {
+		err := export.Write(request.Writer, request.Format, scene, options)
+		if closeErr := request.Writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			notify(chB2gNotice, fmt.Sprintf("Export of %s failed: %v", request.Name, err))
+			return
+		}
+		logger.Info("Exported", "file", request.Name, "format", request.Format)
+		select {
+		case chB2gNotice <- fmt.Sprintf("Exported %s.", request.Name):
+		default:
+		}
+	}()
+}
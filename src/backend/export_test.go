@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"golang-server/types"
+	"testing"
+	"time"
+)
+
+// closeBuffer signals when the backend has closed it.
+type closeBuffer struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (b *closeBuffer) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestScenarioExport(t *testing.T) {
+	sc := newScenario(t)
+
+	sc.at(0)
+	sc.adv(types.AdvMsg{Id: 3})
+	sc.initRobot(3, 0, 0, 0)
+	for i := 0; i <= 10; i++ {
+		sc.at(time.Duration(i) * 100 * time.Millisecond)
+		sc.adv(types.AdvMsg{Id: 3, X: i * 50, Ir1x: 0, Ir1y: 300, Ir2x: 0, Ir2y: -300, Ir3x: 1000, Ir4x: -1000, IrTowerAngle: 90, Valid: 1})
+	}
+	sc.command(types.Command{CommandType: types.ManualCommand, Id: 3, X: 100, Y: 0})
+
+	out := &closeBuffer{closed: make(chan struct{})}
+	sc.chExport <- types.ExportRequest{Format: "geojson", Layers: []string{"map", "trajectories", "poses", "goals"}, Writer: out, Name: "test.geojson"}
+	<-out.closed
+
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &collection); err != nil {
+		t.Fatalf("The export is not valid JSON: %v", err)
+	}
+	kinds := map[string]string{}
+	for _, feature := range collection.Features {
+		kinds[feature.Properties["kind"].(string)] = string(feature.Geometry.Coordinates)
+	}
+	//the robot drove 50 cm along the x axis, and the walls on its sides are mapped
+	if kinds["obstacle"] == "" {
+		t.Error("Expected obstacle polygons from the walls")
+	}
+	if kinds["trajectory"] == "" || kinds["pose"] != "[50,0]" || kinds["goal"] != "[100,0]" {
+		t.Errorf("Expected a trajectory, the pose at [50,0] and the goal at [100,0], got %v", kinds)
+	}
+}
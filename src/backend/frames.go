@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"golang-server/geometry"
+	"golang-server/types"
+)
+
+// Frames of a robot, from the map to its sensors. Each frame is given by its pose in the frame above:
+//
+//	map     cm, the origin is the center of the map, see calculateMapIndex
+//	init    where the robot was initialized, XInit, YInit and ThetaInit. The robot reports its pose in
+//	        this frame, in mm and degrees.
+//	body    the robot, x is forward. IR readings are given in mm in this frame.
+//	tower   the IR tower, turned IrTowerAngle-90 degrees from the body, so 90 is straight ahead.
+//	camera  mounted on the tower, y is forward and x to the right.
+
+// cameraInTower is the pose of the camera frame in the tower frame.
+var cameraInTower = geometry.NewPose(0, 0, -90)
+
+// initPose is the init frame in the map.
+func initPose(robot types.RobotState) geometry.Pose2D {
+	return geometry.NewPose(geometry.Cm(robot.XInit), geometry.Cm(robot.YInit), geometry.Deg(robot.ThetaInit))
+}
+
+// bodyPose is the body frame in the map.
+func bodyPose(robot types.RobotState) geometry.Pose2D {
+	return geometry.NewPose(geometry.Cm(robot.X), geometry.Cm(robot.Y), geometry.Deg(robot.Theta))
+}
+
+// odometryPose is the body frame in the init frame, as reported by the robot.
+func odometryPose(x, y, theta int) geometry.Pose2D {
+	return geometry.NewPose(geometry.Mm(x).Cm(), geometry.Mm(y).Cm(), geometry.Deg(theta))
+}
+
+// towerPose is the tower frame in the body frame.
+func towerPose(irTowerAngle int) geometry.Pose2D {
+	return geometry.NewPose(0, 0, geometry.Deg(irTowerAngle-90))
+}
+
+// cameraPose is the camera frame in the map.
+func cameraPose(robot types.RobotState) geometry.Pose2D {
+	return bodyPose(robot).Compose(towerPose(robot.IrTowerAngle)).Compose(cameraInTower)
+}
+
+// setBodyPose rounds the pose of the body frame in the map into the robot state.
+func setBodyPose(robot *types.RobotState, pose geometry.Pose2D) {
+	robot.X, robot.Y, robot.Theta = pose.Round()
+}
@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-server/config"
+	"golang-server/types"
+	"io/fs"
+	"math"
+	"os"
+)
+
+// Geofences are polygons drawn by the operator. Goals inside a keep-out zone, outside the map, or outside
+// all allowed zones (if there are any) are moved to the closest allowed position or rejected. A robot
+// entering a keep-out zone on its way gives a warning, or stops all robots if config.GeofenceAutoStop.
+
+// loadZones reads the zones saved in the file. A missing file gives no zones.
+func loadZones(path string) ([]types.Zone, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return types.ParseZones(data)
+}
+
+func saveZones(path string, zones []types.Zone) error {
+	data, err := json.MarshalIndent(zones, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+// setZones replaces the zones and saves them.
+func (s *fullSlamState) setZones(zones []types.Zone) {
+	s.zones = zones
+	s.zoneChanged = true
+	s.setKeepOutLayer()
+	if err := saveZones(config.GeofenceFile, zones); err != nil {
+		logger.Error("Failed to save geofences", "file", config.GeofenceFile, "err", err)
+	}
+	logger.Info("Geofences changed", "zones", len(zones))
+}
+
+// checkGoal returns the goal to send instead of (x, y). The notice explains why the goal was moved or
+// rejected, and is empty if the goal is allowed as it is.
+func (s *fullSlamState) checkGoal(x, y int) (xGoal, yGoal int, notice string, ok bool) {
+	reason := s.goalViolation(float64(x), float64(y))
+	if reason == "" {
+		return x, y, "", true
+	}
+	if !config.GeofenceClipGoals {
+		return 0, 0, fmt.Sprintf("Goal (%d, %d) rejected: %s.", x, y, reason), false
+	}
+	xClipped, yClipped, found := s.closestAllowed(float64(x), float64(y))
+	if !found {
+		return 0, 0, fmt.Sprintf("Goal (%d, %d) rejected: %s, and no allowed position was found nearby.", x, y, reason), false
+	}
+	return xClipped, yClipped, fmt.Sprintf("Goal (%d, %d) moved to (%d, %d): %s.", x, y, xClipped, yClipped, reason), true
+}
+
+// goalViolation returns why a goal is not allowed, or an empty string.
+func (s *fullSlamState) goalViolation(x, y float64) string {
+	xMin, yMin := calculateMapCoordinates(0, config.MapSize-1)
+	xMax, yMax := calculateMapCoordinates(config.MapSize-1, 0)
+	if x < float64(xMin) || x > float64(xMax) || y < float64(yMin) || y > float64(yMax) {
+		return "outside the map"
+	}
+	hasAllowed, inAllowed := false, false
+	for _, zone := range s.zones {
+		switch zone.Kind {
+		case types.ZoneKeepOut:
+			if insidePolygon(zone.Points, x, y) {
+				return "inside keep-out zone " + zone.Name
+			}
+		case types.ZoneAllowed:
+			hasAllowed = true
+			inAllowed = inAllowed || insidePolygon(zone.Points, x, y)
+		}
+	}
+	if hasAllowed && !inAllowed {
+		return "outside the allowed zones"
+	}
+	return ""
+}
+
+// closestAllowed moves the goal across the closest zone or map border, config.GeofenceMargin past it.
+// Every border is tried, from the closest, until an allowed position is found.
+func (s *fullSlamState) closestAllowed(x, y float64) (int, int, bool) {
+	xMin, yMin := calculateMapCoordinates(0, config.MapSize-1)
+	xMax, yMax := calculateMapCoordinates(config.MapSize-1, 0)
+	mapBorder := [][2]int{{xMin, yMin}, {xMax, yMin}, {xMax, yMax}, {xMin, yMax}}
+	candidates := [][2]float64{}
+	for _, polygon := range append([][][2]int{mapBorder}, zonePoints(s.zones)...) {
+		px, py := closestOnPolygon(polygon, x, y)
+		d := math.Hypot(px-x, py-y)
+		if d == 0 {
+			continue
+		}
+		//continue in the same direction, so the goal ends up on the other side of the border
+		dx, dy := (px-x)/d, (py-y)/d
+		candidates = append(candidates, [2]float64{px + dx*config.GeofenceMargin, py + dy*config.GeofenceMargin})
+	}
+	bestDistance := math.Inf(1)
+	var best [2]int
+	for _, c := range candidates {
+		xc, yc := math.Round(c[0]), math.Round(c[1])
+		if s.goalViolation(xc, yc) != "" {
+			continue
+		}
+		if d := math.Hypot(xc-x, yc-y); d < bestDistance {
+			bestDistance, best = d, [2]int{int(xc), int(yc)}
+		}
+	}
+	return best[0], best[1], !math.IsInf(bestDistance, 1)
+}
+
+// checkRobotZones returns the keep-out zone the robot has entered since the last call, if any.
+func (s *fullSlamState) checkRobotZones(id int) (string, bool) {
+	robot := s.getRobot(id)
+	for _, zone := range s.zones {
+		if zone.Kind == types.ZoneKeepOut && insidePolygon(zone.Points, float64(robot.X), float64(robot.Y)) {
+			if s.inKeepOut[id] == zone.Name {
+				return "", false
+			}
+			s.inKeepOut[id] = zone.Name
+			return zone.Name, true
+		}
+	}
+	delete(s.inKeepOut, id)
+	return "", false
+}
+
+func zonePoints(zones []types.Zone) [][][2]int {
+	polygons := make([][][2]int, 0, len(zones))
+	for _, zone := range zones {
+		polygons = append(polygons, zone.Points)
+	}
+	return polygons
+}
+
+// insidePolygon tests if (x, y) is inside the polygon by counting the edges crossed by a ray to the right.
+func insidePolygon(polygon [][2]int, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := float64(polygon[i][0]), float64(polygon[i][1])
+		xj, yj := float64(polygon[j][0]), float64(polygon[j][1])
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// closestOnPolygon returns the point on the border of the polygon closest to (x, y).
+func closestOnPolygon(polygon [][2]int, x, y float64) (float64, float64) {
+	bestX, bestY, bestDistance := x, y, math.Inf(1)
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		x0, y0 := float64(polygon[j][0]), float64(polygon[j][1])
+		x1, y1 := float64(polygon[i][0]), float64(polygon[i][1])
+		t := 0.0
+		if lengthSquared := (x1-x0)*(x1-x0) + (y1-y0)*(y1-y0); lengthSquared > 0 {
+			t = max(0, min(1, ((x-x0)*(x1-x0)+(y-y0)*(y1-y0))/lengthSquared))
+		}
+		px, py := x0+t*(x1-x0), y0+t*(y1-y0)
+		if d := math.Hypot(px-x, py-y); d < bestDistance {
+			bestX, bestY, bestDistance = px, py, d
+		}
+	}
+	return bestX, bestY
+}
+
+// notify logs the notice and shows it in the gui. Notices are dropped if the gui is behind.
+func notify(chB2gNotice chan<- string, notice string) {
+	logger.Warn(notice)
+	select {
+	case chB2gNotice <- notice:
+	default:
+	}
+}
@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"golang-server/types"
+	"testing"
+)
+
+func TestInsidePolygon(t *testing.T) {
+	square := [][2]int{{0, 0}, {10, 0}, {10, 10}, {0, 10}}
+	if !insidePolygon(square, 5, 5) {
+		t.Errorf("(5, 5) should be inside %v", square)
+	}
+	if insidePolygon(square, 15, 5) || insidePolygon(square, 5, -1) {
+		t.Errorf("Points outside %v reported inside", square)
+	}
+}
+
+func TestCheckGoal(t *testing.T) {
+	state := initFullSlamState()
+	state.zones = []types.Zone{{Name: "table", Kind: types.ZoneKeepOut, Points: [][2]int{{0, 0}, {20, 0}, {20, 20}, {0, 20}}}}
+
+	if x, y, notice, ok := state.checkGoal(50, 50); !ok || x != 50 || y != 50 || notice != "" {
+		t.Errorf("Allowed goal changed to (%d, %d), notice %q", x, y, notice)
+	}
+	//closest border is x=20, so the goal is moved to the right, past the margin
+	x, y, notice, ok := state.checkGoal(18, 10)
+	if !ok || notice == "" || x <= 20 || y != 10 {
+		t.Errorf("Goal in keep-out zone moved to (%d, %d), ok %v, notice %q", x, y, ok, notice)
+	}
+	if x, _, _, ok := state.checkGoal(1000, 0); !ok || x > 199 {
+		t.Errorf("Goal outside the map clipped to x=%d, ok %v", x, ok)
+	}
+
+	state.zones = append(state.zones, types.Zone{Name: "room", Kind: types.ZoneAllowed, Points: [][2]int{{-100, -100}, {-50, -100}, {-50, -50}, {-100, -50}}})
+	x, y, _, ok = state.checkGoal(-40, -75)
+	if !ok || !insidePolygon(state.zones[1].Points, float64(x), float64(y)) {
+		t.Errorf("Goal outside the allowed zone moved to (%d, %d), ok %v", x, y, ok)
+	}
+}
+
+func TestCheckRobotZones(t *testing.T) {
+	state := initFullSlamState()
+	state.zones = []types.Zone{{Name: "table", Kind: types.ZoneKeepOut, Points: [][2]int{{0, 0}, {20, 0}, {20, 20}, {0, 20}}}}
+	state.id2index[1] = 0
+	state.multiRobot = append(state.multiRobot, *initRobotState(-10, 10, 0))
+
+	if _, entered := state.checkRobotZones(1); entered {
+		t.Errorf("Robot outside the zone reported as entering it")
+	}
+	state.multiRobot[0].X = 10
+	if zone, entered := state.checkRobotZones(1); !entered || zone != "table" {
+		t.Errorf("Robot entering the zone not reported, got %q %v", zone, entered)
+	}
+	if _, entered := state.checkRobotZones(1); entered {
+		t.Errorf("Robot staying in the zone reported again")
+	}
+}
@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"golang-server/export"
+	"golang-server/log"
+	"os"
+	"strings"
+)
+
+// The map is saved and loaded as a png image, see export.WriteMapImage.
+
+type mapGrid = export.Grid
+
+// saveSessionMap writes the map to map.png in the session directory. It is written to a temporary file
+// first, so map.png is always a whole map even if the server is killed while saving.
+func (s *fullSlamState) saveSessionMap() error {
+	file, err := log.CreateSessionFile("map.png.tmp")
+	if err != nil {
+		return err
+	}
+	err = export.WriteMapImage(file, &s.areaMap)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), strings.TrimSuffix(file.Name(), ".tmp"))
+}
@@ -0,0 +1,303 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// With map merging, robots waiting for initialization build a local map in their own frame, where the
+// robot starts at [0, 0, 90]. The local map is matched against the global map, and when the match is
+// good enough the alignment is proposed to the operator. Accepting it initializes the robot like a
+// manual initialization, and the local map is merged into the global map.
+
+const (
+	mergeCoarseCell     = 8  //cm, cell size of the coarse search
+	mergeCandidates     = 3  //coarse rotations that are refined
+	mergeRejectDistance = 10 //cm, proposals this close to a rejected one are not repeated
+	mergeRejectAngle    = 10 //degrees
+)
+
+type localMap struct {
+	state       *fullSlamState
+	lastAttempt time.Time
+	proposal    *types.MergeProposal //latest proposal sent to the gui
+	rejected    []types.MergeProposal
+}
+
+// updateLocalMap adds the message to the local map of a robot that is not initialized.
+func (s *fullSlamState) updateLocalMap(msg types.AdvMsg) {
+	local, exist := s.localMaps[msg.Id]
+	if !exist {
+		local = &localMap{state: initFullSlamState()}
+		local.state.graph = nil
+		local.state.id2index[msg.Id] = 0
+		local.state.multiRobot = append(local.state.multiRobot, *initRobotState(0, 0, 90))
+		s.localMaps[msg.Id] = local
+	}
+	local.state.updateRobotPose(msg)
+	local.state.addIrSensorData(msg.Id, msg.Ir1x, msg.Ir1y)
+	local.state.addIrSensorData(msg.Id, msg.Ir2x, msg.Ir2y)
+	local.state.addIrSensorData(msg.Id, msg.Ir3x, msg.Ir3y)
+	local.state.addIrSensorData(msg.Id, msg.Ir4x, msg.Ir4y)
+	local.clearGuiUpdates()
+}
+
+func (l *localMap) addCameraFrame(cam types.CameraMsg) {
+	l.state.addCameraFrame(cam.Id, cam)
+	l.clearGuiUpdates()
+}
+
+// clearGuiUpdates drops the changes of the local map, since it is never drawn.
+func (l *localMap) clearGuiUpdates() {
+	l.state.newOpen = nil
+	l.state.newObstacle = nil
+	l.state.newUnknown = nil
+}
+
+// mergeState runs the matching of the local maps in a worker goroutine, see startMerges, so the backend
+// keeps handling messages and commands.
+type mergeState struct {
+	running bool
+	results chan []types.MergeProposal
+}
+
+// mergeJob is a copy of a local map, for the worker.
+type mergeJob struct {
+	id       int
+	areaMap  [config.MapSize][config.MapSize]uint8
+	rejected []types.MergeProposal
+}
+
+// startMerges copies the local maps that have not been tried for config.MapMergeInterval, and matches
+// them against a copy of the global map in a worker goroutine, which sends the proposals on
+// s.merges.results. Nothing is tried while a worker is running, or before a robot has been initialized,
+// since the first robot defines the global frame and is placed by the operator.
+func (s *fullSlamState) startMerges(now time.Time) {
+	if s.merges.running || len(s.multiRobot) == 0 {
+		return
+	}
+	jobs := []*mergeJob{}
+	for id, local := range s.localMaps {
+		if now.Sub(local.lastAttempt) < config.MapMergeInterval*time.Second {
+			continue
+		}
+		local.lastAttempt = now
+		jobs = append(jobs, &mergeJob{id: id, areaMap: local.state.areaMap, rejected: slices.Clone(local.rejected)})
+	}
+	if len(jobs) == 0 {
+		return
+	}
+	s.merges.running = true
+	global := s.areaMap
+	go func() {
+		proposals := []types.MergeProposal{}
+		for _, job := range jobs {
+			if proposal, ok := matchLocalMap(job, &global); ok {
+				proposals = append(proposals, proposal)
+			}
+		}
+		s.merges.results <- proposals
+	}()
+}
+
+// applyMerges keeps the proposals of the robots that are still waiting for initialization, and returns
+// them to be sent to the gui.
+func (s *fullSlamState) applyMerges(proposals []types.MergeProposal) []types.MergeProposal {
+	s.merges.running = false
+	kept := []types.MergeProposal{}
+	for _, proposal := range proposals {
+		local, exist := s.localMaps[proposal.Id]
+		if !exist || local.isRejected(proposal.X, proposal.Y, proposal.Theta-90) {
+			continue
+		}
+		local.proposal = &proposal
+		kept = append(kept, proposal)
+		logger.Info("Proposing map alignment", "robot", proposal.Id, "x", proposal.X, "y", proposal.Y, "theta", proposal.Theta,
+			"confidence", proposal.Confidence)
+	}
+	return kept
+}
+
+func (s *fullSlamState) rejectMerge(id int) {
+	local, exist := s.localMaps[id]
+	if !exist || local.proposal == nil {
+		return
+	}
+	local.rejected = append(local.rejected, *local.proposal)
+	local.proposal = nil
+	logger.Info("Map alignment rejected by the operator", "robot", id)
+}
+
+type mergeCandidate struct {
+	theta, x, y int //rotation and translation from the local to the global map
+	score       int
+}
+
+// matchLocalMap finds the rotation and translation that best aligns the obstacles of the local map with
+// the global map. The maps are first compared on a grid of mergeCoarseCell cells, where a local cell scores
+// 2 on a global obstacle and 1 next to one, so every coarse rotation and translation is scored with one
+// lookup per local cell. The best rotations are then refined at 1 cm and 1 degree.
+func matchLocalMap(job *mergeJob, global *[config.MapSize][config.MapSize]uint8) (types.MergeProposal, bool) {
+	points := obstacleCoordinates(&job.areaMap)
+	globalPoints := obstacleCoordinates(global)
+	if len(points) < config.MapMergeMinCells || len(globalPoints) < config.MapMergeMinCells {
+		return types.MergeProposal{}, false
+	}
+	const coarseSize = config.MapSize/mergeCoarseCell + 1
+	coarse := func(v float64) int { return int(math.Floor(v/mergeCoarseCell)) + coarseSize/2 }
+	var near [coarseSize][coarseSize]int //2 for a cell with a global obstacle, 1 next to one
+	for _, p := range globalPoints {
+		x, y := coarse(p.X), coarse(p.Y)
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				if x+dx >= 0 && y+dy >= 0 && x+dx < coarseSize && y+dy < coarseSize {
+					near[x+dx][y+dy] = max(near[x+dx][y+dy], 1)
+				}
+			}
+		}
+		near[x][y] = 2
+	}
+
+	candidates := []mergeCandidate{}
+	for theta := 0; theta < 360; theta += config.MapMergeAngleStep {
+		//the local obstacles on the coarse grid, relative to the map center
+		unique := map[[2]int]struct{}{}
+		for _, p := range points {
+			rotated := p.Rotate(geometry.Deg(theta).Rad())
+			unique[[2]int{coarse(rotated.X) - coarseSize/2, coarse(rotated.Y) - coarseSize/2}] = struct{}{}
+		}
+		cells := make([][2]int, 0, len(unique))
+		for cell := range unique {
+			cells = append(cells, cell)
+		}
+		best := mergeCandidate{theta: theta}
+		for tx := 0; tx < coarseSize; tx++ {
+			for ty := 0; ty < coarseSize; ty++ {
+				score := 0
+				for _, cell := range cells {
+					x, y := cell[0]+tx, cell[1]+ty
+					if x >= 0 && y >= 0 && x < coarseSize && y < coarseSize {
+						score += near[x][y]
+					}
+				}
+				if score > best.score {
+					best.score = score
+					best.x, best.y = (tx-coarseSize/2)*mergeCoarseCell, (ty-coarseSize/2)*mergeCoarseCell
+				}
+			}
+		}
+		candidates = append(candidates, best)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	best := mergeCandidate{score: math.MinInt}
+	for _, candidate := range candidates[:min(mergeCandidates, len(candidates))] {
+		for dTheta := -config.MapMergeAngleStep / 2; dTheta <= config.MapMergeAngleStep/2; dTheta++ {
+			theta := candidate.theta + dTheta
+			rotated := make([]geometry.Vec2, len(points))
+			for i, p := range points {
+				rotated[i] = p.Rotate(geometry.Deg(theta).Rad())
+			}
+			for dx := -mergeCoarseCell; dx <= mergeCoarseCell; dx++ {
+				for dy := -mergeCoarseCell; dy <= mergeCoarseCell; dy++ {
+					x, y := candidate.x+dx, candidate.y+dy
+					if isRejected(job.rejected, x, y, theta) {
+						continue
+					}
+					score := 0
+					for _, p := range rotated {
+						xCell, yCell := p.Round()
+						score += scoreMergeCell(global, xCell+x, yCell+y)
+					}
+					if score > best.score {
+						best = mergeCandidate{theta, x, y, score}
+					}
+				}
+			}
+		}
+	}
+
+	confidence := float64(best.score) / float64(len(points))
+	if confidence < config.MapMergeConfidence {
+		return types.MergeProposal{}, false
+	}
+	//the local map was built with the robot initialized at [0, 0, 90]
+	return types.MergeProposal{Id: job.id, X: best.x, Y: best.y, Theta: geometry.Deg(90 + best.theta).Normalize360().Round(), Confidence: confidence}, true
+}
+
+// scoreMergeCell gives 1 point for an obstacle at or next to an obstacle in the global map, and -1 point
+// for an obstacle where the global map is open.
+func scoreMergeCell(global *[config.MapSize][config.MapSize]uint8, x, y int) int {
+	xIndex, yIndex := calculateMapIndex(x, y)
+	if xIndex < 1 || yIndex < 1 || xIndex >= config.MapSize-1 || yIndex >= config.MapSize-1 {
+		return 0
+	}
+	if global[xIndex][yIndex] == mapObstacle ||
+		global[xIndex+1][yIndex] == mapObstacle || global[xIndex-1][yIndex] == mapObstacle ||
+		global[xIndex][yIndex+1] == mapObstacle || global[xIndex][yIndex-1] == mapObstacle {
+		return 1
+	}
+	if global[xIndex][yIndex] == mapOpen {
+		return -1
+	}
+	return 0
+}
+
+func (l *localMap) isRejected(x, y, theta int) bool {
+	return isRejected(l.rejected, x, y, theta)
+}
+
+// isRejected returns true if the alignment is close to one of the rejected proposals.
+func isRejected(rejected []types.MergeProposal, x, y, theta int) bool {
+	for _, r := range rejected {
+		dTheta := geometry.Deg(90 + theta - r.Theta).Normalize()
+		if math.Hypot(float64(x-r.X), float64(y-r.Y)) < mergeRejectDistance && math.Abs(float64(dTheta)) < mergeRejectAngle {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeLocalMap adds the local map of a robot to the global map, using the initial pose the robot was
+// given. Every global cell is looked up in the local map, so rotating the map does not leave holes. The
+// log-odds of the two maps are added, like two independent measurements of the same cell.
+func (s *fullSlamState) mergeLocalMap(id, x, y, theta int) {
+	local := s.localMaps[id]
+	delete(s.localMaps, id)
+	//the local map was built with the robot initialized at [0, 0, 90]
+	globalToLocal := geometry.NewPose(geometry.Cm(x), geometry.Cm(y), geometry.Deg(theta-90)).Transform().Inverse()
+	updates := cellUpdates{}
+	for xIndex := 0; xIndex < config.MapSize; xIndex++ {
+		for yIndex := 0; yIndex < config.MapSize; yIndex++ {
+			xGlobal, yGlobal := calculateMapCoordinates(xIndex, yIndex)
+			xLocalIndex, yLocalIndex := calculateMapIndex(globalToLocal.Apply(geometry.Vec2{X: float64(xGlobal), Y: float64(yGlobal)}).Round())
+			if xLocalIndex < 0 || yLocalIndex < 0 || xLocalIndex >= config.MapSize || yLocalIndex >= config.MapSize {
+				continue
+			}
+			if logOdds := local.state.logOdds[xLocalIndex][yLocalIndex]; logOdds != 0 {
+				updates[[2]int{xIndex, yIndex}] = logOdds
+			}
+		}
+	}
+	s.applyUnrecorded(updates)
+	logger.Info("Merged local map into the global map", "robot", id)
+}
+
+// obstacleCoordinates returns the map coordinates of all obstacle cells.
+func obstacleCoordinates(areaMap *[config.MapSize][config.MapSize]uint8) []geometry.Vec2 {
+	points := []geometry.Vec2{}
+	for xIndex := 0; xIndex < config.MapSize; xIndex++ {
+		for yIndex := 0; yIndex < config.MapSize; yIndex++ {
+			if areaMap[xIndex][yIndex] == mapObstacle {
+				x, y := calculateMapCoordinates(xIndex, yIndex)
+				points = append(points, geometry.Vec2{X: float64(x), Y: float64(y)})
+			}
+		}
+	}
+	return points
+}
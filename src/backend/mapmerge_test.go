@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"golang-server/geometry"
+	"golang-server/types"
+	"golang-server/utilities"
+	"math"
+	"testing"
+	"time"
+)
+
+// roomMaps returns an asymmetric room seen by the global map, and by a robot that started at [40, -20, 120].
+func roomMaps() (global *fullSlamState, local *localMap) {
+	walls := [][4]int{{-60, -40, 60, -40}, {60, -40, 60, 50}, {60, 50, -20, 50}, {-60, -40, -60, 10}, {0, 0, 20, 0}}
+	global = initFullSlamState()
+	local = &localMap{state: initFullSlamState()}
+	for _, wall := range walls {
+		for _, p := range utilities.BresenhamAlgorithm(wall[0], wall[1], wall[2], wall[3]) {
+			xIndex, yIndex := calculateMapIndex(p[0], p[1])
+			global.areaMap[xIndex][yIndex] = mapObstacle
+
+			//inverse of the initial pose, since the local map has the robot at [0, 0, 90]
+			x, y := geometry.Vec2{X: float64(p[0] - 40), Y: float64(p[1] + 20)}.Rotate(geometry.Deg(-30).Rad()).Round()
+			xIndex, yIndex = calculateMapIndex(x, y)
+			local.state.areaMap[xIndex][yIndex] = mapObstacle
+		}
+	}
+	return global, local
+}
+
+func TestMatchLocalMap(t *testing.T) {
+	global, local := roomMaps()
+	proposal, ok := matchLocalMap(&mergeJob{id: 3, areaMap: local.state.areaMap}, &global.areaMap)
+	if !ok {
+		t.Fatalf("Function matchLocalMap did not find an alignment.")
+	}
+	if math.Abs(float64(proposal.X-40)) > 2 || math.Abs(float64(proposal.Y+20)) > 2 || proposal.Theta != 120 {
+		t.Errorf("Function matchLocalMap found the wrong alignment. Expected: [40, -20, 120]. Got: [%d, %d, %d]", proposal.X, proposal.Y, proposal.Theta)
+	}
+}
+
+func TestStartMerges(t *testing.T) {
+	global, local := roomMaps()
+	global.localMaps[3] = local
+
+	//the first robot is placed by the operator
+	global.startMerges(time.Now())
+	if global.merges.running {
+		t.Fatal("Expected no matching before a robot is initialized")
+	}
+
+	global.multiRobot = append(global.multiRobot, *initRobotState(0, 0, 90))
+	global.startMerges(time.Now())
+	if !global.merges.running {
+		t.Fatal("Expected the local map to be matched")
+	}
+	proposals := global.applyMerges(<-global.merges.results)
+	if len(proposals) != 1 || local.proposal == nil || proposals[0].Theta != 120 {
+		t.Fatalf("Expected one proposal at 120 degrees, got %v", proposals)
+	}
+
+	//a proposal rejected while the worker ran is dropped
+	global.rejectMerge(3)
+	if proposals := global.applyMerges([]types.MergeProposal{proposals[0]}); len(proposals) != 0 {
+		t.Errorf("Expected the rejected proposal to be dropped, got %v", proposals)
+	}
+}
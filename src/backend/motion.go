@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"golang-server/types"
+	"sort"
+)
+
+// Emergency stop, pause and resume apply to all robots. While the robots are stopped or paused, goals
+// and teleop commands from the gui are not published. The publisher sends these messages before any
+// queued goal, retained, so a robot that reconnects receives the latest one.
+
+var motionKinds = map[int]uint8{
+	types.MotionRunning: types.PublishResume,
+	types.MotionPaused:  types.PublishPause,
+	types.MotionStopped: types.PublishStop,
+}
+
+var motionNames = map[int]string{
+	types.MotionRunning: "running",
+	types.MotionPaused:  "paused",
+	types.MotionStopped: "stopped",
+}
+
+// motionFromCommand returns the motion state set by a stop, pause or resume command.
+func motionFromCommand(commandType int) (int, bool) {
+	switch commandType {
+	case types.StopCommand:
+		return types.MotionStopped, true
+	case types.PauseCommand:
+		return types.MotionPaused, true
+	case types.ResumeCommand:
+		return types.MotionRunning, true
+	}
+	return 0, false
+}
+
+// publishMotion sends the motion state to the robots.
+func publishMotion(chPublishControl chan<- types.PublishMsg, motion int, robots []int) {
+	for _, id := range robots {
+		chPublishControl <- types.PublishMsg{Kind: motionKinds[motion], Id: id}
+	}
+}
+
+// knownRobots returns the ids of all initialized robots and robots waiting for initialization.
+func (s *fullSlamState) knownRobots(pendingInit map[int]struct{}) []int {
+	robots := make([]int, 0, len(s.id2index)+len(pendingInit))
+	for id := range s.id2index {
+		robots = append(robots, id)
+	}
+	for id := range pendingInit {
+		robots = append(robots, id)
+	}
+	sort.Ints(robots)
+	return robots
+}
+
+func logMotion(motion int, robots []int) {
+	logger.Warn("Robots "+motionNames[motion], "robots", robots)
+}
@@ -0,0 +1,766 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"maps"
+	"math"
+	"slices"
+)
+
+// The pose graph stores keyframes for every robot together with the IR and camera data that was
+// observed close to them. Consecutive keyframes of a robot are connected by odometry edges from the
+// onboard EKF, and loop closures are added when a keyframe scan matches an older keyframe. The graph
+// is optimized with Gauss-Newton on SE(2), and the map is rebuilt from the optimized keyframes. Both run
+// in a worker goroutine, see startOptimization, so the backend keeps handling messages and commands.
+// Map updates that are not stored in a keyframe, like merged local maps and observations made before the
+// first keyframe of a robot, are kept in a base layer that the map is rebuilt on.
+
+const (
+	priorInformation = 1e6 //the first keyframe of every robot is kept where the robot was initialized
+	minVariancePos   = 1.0 //cm^2, lower limit of the odometry variance
+	minVarianceTheta = (math.Pi / 180) * (math.Pi / 180)
+)
+
+// loop closures are scan matched at 1 cm and 1 degree resolution.
+var loopClosureInformation = [3]float64{1.0 / 4, 1.0 / 4, 1 / (4 * minVarianceTheta)}
+
+// graphRay, graphSegment and graphWedge are IR and camera observations, given in the frame of their
+// keyframe. A wedge is the free space of a camera frame where nothing was seen, its arc is computed
+// from the camera pose when it is replayed.
+type graphRay struct {
+	from, to    geometry.Vec2
+	obstruction bool
+}
+
+type graphSegment struct {
+	robot, p1, p2 geometry.Vec2
+}
+
+type graphWedge struct {
+	origin geometry.Vec2
+	camera geometry.Pose2D
+}
+
+type keyframe struct {
+	robotId  int
+	number   int             //keyframe number for this robot
+	odometry geometry.Pose2D //pose reported by the robot
+	prior    geometry.Pose2D //estimate when the keyframe was created
+	estimate geometry.Pose2D
+	variance [3]float64 //x, y [cm^2], theta [rad^2] from the EKF
+	rays     observations[graphRay]
+	segments observations[graphSegment]
+	wedges   observations[graphWedge]
+}
+
+// observations keeps at most config.KeyframeObservations items. When it is full every second item is
+// dropped, and from then on only every stride-th observation is kept, so the kept observations stay
+// spread over the keyframe.
+type observations[T any] struct {
+	items  []T
+	seen   int //all observations, also those that were not kept
+	stride int //0 until the first thinning, the same as 1
+}
+
+func (o *observations[T]) add(item T) {
+	stride := max(o.stride, 1)
+	if o.seen%stride == 0 {
+		o.items = append(o.items, item)
+		if len(o.items) > config.KeyframeObservations {
+			kept := make([]T, 0, config.KeyframeObservations)
+			for i := 0; i < len(o.items); i += 2 {
+				kept = append(kept, o.items[i])
+			}
+			o.items, o.stride = kept, 2*stride
+		}
+	}
+	o.seen++
+}
+
+type graphEdge struct {
+	from, to    int             //index in poseGraph.keyframes
+	measurement geometry.Pose2D //pose of "to" relative to "from"
+	information [3]float64
+	loopClosure bool
+}
+
+type poseGraph struct {
+	keyframes    []*keyframe
+	edges        []graphEdge
+	anchors      []int                   //keyframes held at their prior, the first of every robot and after every re-localization
+	relocalized  map[int]bool            //robot id -> the next keyframe starts a new chain
+	last         map[int]int             //robot id -> index of the latest keyframe
+	lastOdometry map[int]geometry.Pose2D //robot id -> latest pose reported by the robot
+	transforms   map[int]geometry.Pose2D //robot id -> correction from odometry to the optimized frame
+	changed      bool                    //the gui has not received the latest graph
+	optimizing   bool                    //a worker is running, see startOptimization
+	pending      bool                    //a loop closure was added while the worker was running
+	results      chan graphResult
+	base         *[config.MapSize][config.MapSize]float32 //log-odds of the map updates without a keyframe
+}
+
+func newPoseGraph() *poseGraph {
+	return &poseGraph{
+		relocalized:  make(map[int]bool),
+		last:         make(map[int]int),
+		lastOdometry: make(map[int]geometry.Pose2D),
+		transforms:   make(map[int]geometry.Pose2D),
+		results:      make(chan graphResult, 1),
+		base:         new([config.MapSize][config.MapSize]float32),
+	}
+}
+
+// addLogOdds adds the log-odds changes to a grid, within the log-odds limits.
+func addLogOdds(grid *[config.MapSize][config.MapSize]float32, updates cellUpdates) {
+	for cell, logOdds := range updates {
+		v := &grid[cell[0]][cell[1]]
+		*v = min(max(*v+logOdds, logOddsMin), logOddsMax)
+	}
+}
+
+// applyUnrecorded applies map updates that are not stored in a keyframe, e.g. a merged local map. With
+// the pose graph they are also added to its base layer, so they are kept when the map is rebuilt.
+func (s *fullSlamState) applyUnrecorded(updates cellUpdates) {
+	if s.graph != nil {
+		addLogOdds(s.graph.base, updates)
+	}
+	s.applyUpdates(updates)
+}
+
+// applyGraphCorrection moves the robot from the odometry frame to the optimized frame.
+func (s *fullSlamState) applyGraphCorrection(id int) {
+	transform, exist := s.graph.transforms[id]
+	if !exist {
+		return
+	}
+	s.setRobotPose(id, transform.Compose(bodyPose(s.getRobot(id))))
+}
+
+func (s *fullSlamState) setRobotPose(id int, pose geometry.Pose2D) {
+	setBodyPose(&s.multiRobot[s.id2index[id]], pose)
+}
+
+// updatePoseGraph adds a keyframe if the robot has moved far enough. If the new keyframe closes a loop
+// the graph is optimized and the map is rebuilt in the background.
+func (s *fullSlamState) updatePoseGraph(id int, odometry types.RobotState, msg types.AdvMsg) {
+	variance := [3]float64{
+		float64(msg.CovarianceMatrixNumber1) / 100, //mm^2 to cm^2
+		float64(msg.CovarianceMatrixNumber7) / 100,
+		float64(msg.CovarianceMatrixNumber13) * (math.Pi / 180) * (math.Pi / 180), //degrees^2 to radians^2
+	}
+	if s.graph.update(id, bodyPose(odometry), bodyPose(s.getRobot(id)), variance) {
+		s.graph.startOptimization()
+	}
+}
+
+// graphSnapshot is the graph as it was when an optimization started. Only closed keyframes, which get no
+// more observations, are replayed by the worker; the open keyframe of every robot is nil in replay.
+type graphSnapshot struct {
+	estimates []geometry.Pose2D
+	priors    []geometry.Pose2D
+	edges     []graphEdge
+	anchors   []int
+	last      map[int]int
+	replay    []*keyframe
+	base      *[config.MapSize][config.MapSize]float32 //a copy, the worker adds the replays to it
+}
+
+// graphResult is the optimized snapshot, and the log-odds of the base layer and the closed keyframes at
+// their new poses.
+type graphResult struct {
+	snapshot  graphSnapshot
+	estimates []geometry.Pose2D
+	logOdds   *[config.MapSize][config.MapSize]float32
+	ok        bool
+}
+
+func (g *poseGraph) snapshot() graphSnapshot {
+	snapshot := graphSnapshot{
+		edges:   slices.Clone(g.edges),
+		anchors: slices.Clone(g.anchors),
+		last:    maps.Clone(g.last),
+		replay:  slices.Clone(g.keyframes),
+		base:    new([config.MapSize][config.MapSize]float32),
+	}
+	*snapshot.base = *g.base
+	for _, kf := range g.keyframes {
+		snapshot.estimates = append(snapshot.estimates, kf.estimate)
+		snapshot.priors = append(snapshot.priors, kf.prior)
+	}
+	for _, index := range g.last {
+		snapshot.replay[index] = nil
+	}
+	return snapshot
+}
+
+// startOptimization optimizes the graph and replays the closed keyframes in a worker goroutine, which
+// sends the result on g.results. If a worker is already running, it is started again when it is done.
+func (g *poseGraph) startOptimization() {
+	if g.optimizing {
+		g.pending = true
+		return
+	}
+	g.optimizing = true
+	snapshot := g.snapshot()
+	go func() {
+		result := graphResult{snapshot: snapshot}
+		result.estimates, result.ok = snapshot.optimize()
+		if result.ok {
+			result.logOdds = snapshot.base
+			for i, kf := range snapshot.replay {
+				if kf == nil {
+					continue
+				}
+				kf.replay(result.estimates[i], func(updates cellUpdates) {
+					addLogOdds(result.logOdds, updates)
+				})
+			}
+		}
+		g.results <- result
+	}()
+}
+
+// applyGraphResult moves the keyframes and robots to the optimized poses, and replaces the map with the
+// rebuilt one. The open keyframes and the keyframes made while the worker ran are replayed here.
+func (s *fullSlamState) applyGraphResult(result graphResult) {
+	g := s.graph
+	g.optimizing = false
+	if !result.ok {
+		logger.Error("Pose graph optimization failed, the system is not positive definite.")
+	} else {
+		g.setEstimates(result.snapshot, result.estimates)
+		//the optimized poses replace any scan matching corrections
+		s.corrections = make(map[int]geometry.Pose2D)
+		for robotId := range g.last {
+			if _, exist := s.id2index[robotId]; exist {
+				s.setRobotPose(robotId, g.transforms[robotId].Compose(g.lastOdometry[robotId]))
+			}
+		}
+
+		//the poses moved, not the scene
+		s.dynamic.paused = true
+		for x := 0; x < config.MapSize; x++ {
+			for y := 0; y < config.MapSize; y++ {
+				s.logOdds[x][y] = result.logOdds[x][y]
+				s.classifyCell(x, y)
+			}
+		}
+		for i, kf := range g.keyframes {
+			if i >= len(result.snapshot.replay) || result.snapshot.replay[i] == nil {
+				kf.replay(kf.estimate, s.applyUpdates)
+			}
+		}
+		s.dynamic.paused = false
+		logger.Info("Map rebuilt from pose graph", "keyframes", len(g.keyframes))
+	}
+	if g.pending {
+		g.pending = false
+		g.startOptimization()
+	}
+}
+
+// replay adds the observations of the keyframe to the map, with the keyframe at estimate.
+func (kf *keyframe) replay(estimate geometry.Pose2D, apply func(cellUpdates)) {
+	irModel, cameraModel := irModelFor(kf.robotId), cameraModelFor(kf.robotId)
+	toMap := estimate.Transform()
+	for _, ray := range kf.rays.items {
+		updates := cellUpdates{}
+		updates.addRay(irModel, clampedMapPoint(toMap.Apply(ray.from)), clampedMapPoint(toMap.Apply(ray.to)), ray.obstruction)
+		apply(updates)
+	}
+	for _, segment := range kf.segments.items {
+		updates := cellUpdates{}
+		updates.addSegment(cameraModel, clampedMapPoint(toMap.Apply(segment.robot)), clampedMapPoint(toMap.Apply(segment.p1)), clampedMapPoint(toMap.Apply(segment.p2)))
+		apply(updates)
+	}
+	for _, wedge := range kf.wedges.items {
+		arc := cameraArc(estimate.Compose(wedge.camera), config.CameraClearRangeCm)
+		for i, p := range arc {
+			arc[i] = clampedMapPoint(p)
+		}
+		updates := cellUpdates{}
+		updates.addWedge(cameraModel, clampedMapPoint(toMap.Apply(wedge.origin)), arc)
+		apply(updates)
+	}
+}
+
+// update returns true if a new loop closure was added.
+func (g *poseGraph) update(id int, odometry, estimate geometry.Pose2D, variance [3]float64) bool {
+	g.lastOdometry[id] = odometry
+	lastIndex, exist := g.last[id]
+	if !exist || g.relocalized[id] {
+		delete(g.relocalized, id)
+		g.addKeyframe(id, odometry, estimate, variance)
+		g.anchors = append(g.anchors, g.last[id])
+		return false
+	}
+	last := g.keyframes[lastIndex]
+	moved := math.Hypot(odometry.X-last.odometry.X, odometry.Y-last.odometry.Y)
+	turned := math.Abs(float64(geometry.Rad(odometry.Theta - last.odometry.Theta).Normalize().Deg()))
+	if moved < config.KeyframeDistance && turned < config.KeyframeAngle {
+		return false
+	}
+
+	g.addKeyframe(id, odometry, estimate, variance)
+	//the EKF covariance grows with the distance travelled, the difference is used for the odometry edge
+	information := [3]float64{}
+	for i, minVariance := range [3]float64{minVariancePos, minVariancePos, minVarianceTheta} {
+		information[i] = 1 / max(math.Abs(variance[i]-last.variance[i]), minVariance)
+	}
+	g.edges = append(g.edges, graphEdge{
+		from:        lastIndex,
+		to:          g.last[id],
+		measurement: last.odometry.Between(odometry),
+		information: information,
+	})
+	g.changed = true
+
+	//the previous keyframe is complete now that the robot has left it
+	return g.detectLoopClosure(lastIndex)
+}
+
+func (g *poseGraph) addKeyframe(id int, odometry, estimate geometry.Pose2D, variance [3]float64) {
+	number := 0
+	if lastIndex, exist := g.last[id]; exist {
+		number = g.keyframes[lastIndex].number + 1
+	}
+	g.keyframes = append(g.keyframes, &keyframe{
+		robotId:  id,
+		number:   number,
+		odometry: odometry,
+		prior:    estimate,
+		estimate: estimate,
+		variance: variance,
+	})
+	g.last[id] = len(g.keyframes) - 1
+	g.changed = true
+}
+
+// relocalize starts a new chain of keyframes for a robot that was moved by the operator. The odometry
+// jumps at the new pose, so the chain is not connected to the old one by an odometry edge.
+func (g *poseGraph) relocalize(id int) {
+	g.relocalized[id] = true
+	delete(g.transforms, id)
+}
+
+// recordRay stores an IR observation given in map coordinates in the latest keyframe of the robot. It
+// returns false if the robot has no keyframe yet.
+func (g *poseGraph) recordRay(id int, from, to geometry.Vec2, obstruction bool) bool {
+	kf := g.currentKeyframe(id)
+	if kf == nil {
+		return false
+	}
+	toKeyframe := kf.estimate.Inverse().Transform()
+	kf.rays.add(graphRay{toKeyframe.Apply(from), toKeyframe.Apply(to), obstruction})
+	return true
+}
+
+// recordWedge stores free space seen by the camera, given by the robot position and the camera pose in
+// map coordinates, in the latest keyframe of the robot.
+func (g *poseGraph) recordWedge(id int, origin geometry.Vec2, camera geometry.Pose2D) {
+	kf := g.currentKeyframe(id)
+	if kf == nil {
+		return
+	}
+	toKeyframe := kf.estimate.Inverse()
+	kf.wedges.add(graphWedge{toKeyframe.Transform().Apply(origin), toKeyframe.Compose(camera)})
+}
+
+// recordSegment stores a camera observation given in map coordinates in the latest keyframe of the robot.
+func (g *poseGraph) recordSegment(id int, robot, p1, p2 geometry.Vec2) {
+	kf := g.currentKeyframe(id)
+	if kf == nil {
+		return
+	}
+	toKeyframe := kf.estimate.Inverse().Transform()
+	kf.segments.add(graphSegment{toKeyframe.Apply(robot), toKeyframe.Apply(p1), toKeyframe.Apply(p2)})
+}
+
+func (g *poseGraph) currentKeyframe(id int) *keyframe {
+	index, exist := g.last[id]
+	if !exist {
+		return nil
+	}
+	return g.keyframes[index]
+}
+
+// hitPoints returns the obstacle cells observed from the keyframe, in the frame of the keyframe.
+func (kf *keyframe) hitPoints() [][2]float64 {
+	cells := make(map[[2]int]struct{})
+	for _, ray := range kf.rays.items {
+		if ray.obstruction {
+			x, y := ray.to.Round()
+			cells[[2]int{x, y}] = struct{}{}
+		}
+	}
+	for _, segment := range kf.segments.items {
+		direction := segment.p2.Sub(segment.p1)
+		length := float64(direction.Norm())
+		for step := 0.0; step <= length; step++ {
+			t := step / max(length, 1)
+			x, y := segment.p1.Add(direction.Scale(t)).Round()
+			cells[[2]int{x, y}] = struct{}{}
+		}
+	}
+	points := make([][2]float64, 0, len(cells))
+	for cell := range cells {
+		points = append(points, [2]float64{float64(cell[0]), float64(cell[1])})
+	}
+	return points
+}
+
+// detectLoopClosure matches keyframe k against older keyframes close to it, and adds an edge to the
+// best match.
+func (g *poseGraph) detectLoopClosure(k int) bool {
+	current := g.keyframes[k]
+	points := current.hitPoints()
+	if len(points) < config.ScanMatchMinPoints {
+		return false
+	}
+
+	bestIndex, bestScore := -1, 0
+	var bestMeasurement geometry.Pose2D
+	for m := 0; m < k; m++ {
+		candidate := g.keyframes[m]
+		if candidate.robotId == current.robotId && current.number-candidate.number < config.LoopClosureMinAge {
+			continue
+		}
+		if math.Hypot(candidate.estimate.X-current.estimate.X, candidate.estimate.Y-current.estimate.Y) > config.LoopClosureRadius {
+			continue
+		}
+		measurement, score := matchKeyframes(candidate, current, points)
+		if score > bestScore {
+			bestIndex, bestScore, bestMeasurement = m, score, measurement
+		}
+	}
+	//at least 70% of the maximum score is needed to trust a loop closure
+	if bestIndex == -1 || bestScore < 2*len(points)*7/10 {
+		return false
+	}
+
+	g.edges = append(g.edges, graphEdge{
+		from:        bestIndex,
+		to:          k,
+		measurement: bestMeasurement,
+		information: loopClosureInformation,
+		loopClosure: true,
+	})
+	g.changed = true
+	logger.Info("Loop closure", "robot", current.robotId, "keyframe", current.number,
+		"otherRobot", g.keyframes[bestIndex].robotId, "otherKeyframe", g.keyframes[bestIndex].number, "score", bestScore, "maxScore", 2*len(points))
+	return true
+}
+
+// matchKeyframes finds the pose of current relative to candidate by a correlative search around the
+// current estimates. The score is 2 per point on an obstacle of the candidate, and 1 per point next to one.
+func matchKeyframes(candidate, current *keyframe, points [][2]float64) (geometry.Pose2D, int) {
+	obstacles := make(map[[2]int]struct{})
+	for _, p := range candidate.hitPoints() {
+		obstacles[[2]int{int(p[0]), int(p[1])}] = struct{}{}
+	}
+	if len(obstacles) < config.ScanMatchMinPoints {
+		return geometry.Pose2D{}, 0
+	}
+	scoreCell := func(x, y int) int {
+		if _, hit := obstacles[[2]int{x, y}]; hit {
+			return 2
+		}
+		for _, n := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			if _, hit := obstacles[[2]int{x + n[0], y + n[1]}]; hit {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	guess := candidate.estimate.Between(current.estimate)
+	best, bestScore := guess, -1
+	for dTheta := -config.LoopClosureSearchAngle; dTheta <= config.LoopClosureSearchAngle; dTheta++ {
+		theta := geometry.Rad(guess.Theta) + geometry.Deg(dTheta).Rad()
+		rotated := make([]geometry.Vec2, len(points))
+		for i, p := range points {
+			rotated[i] = geometry.Vec2{X: p[0], Y: p[1]}.Rotate(theta)
+		}
+		for dx := -config.LoopClosureSearchRadius; dx <= config.LoopClosureSearchRadius; dx++ {
+			for dy := -config.LoopClosureSearchRadius; dy <= config.LoopClosureSearchRadius; dy++ {
+				x, y := guess.X+float64(dx), guess.Y+float64(dy)
+				score := 0
+				for _, p := range rotated {
+					score += scoreCell(p.Add(geometry.Vec2{X: x, Y: y}).Round())
+				}
+				if score > bestScore {
+					best, bestScore = geometry.Pose2D{X: x, Y: y, Theta: float64(theta.Normalize())}, score
+				}
+			}
+		}
+	}
+	return best, bestScore
+}
+
+// optimize runs Gauss-Newton on the keyframe poses of the snapshot and returns the optimized poses.
+func (snapshot graphSnapshot) optimize() ([]geometry.Pose2D, bool) {
+	estimates := slices.Clone(snapshot.estimates)
+	for iteration := 0; iteration < config.PoseGraphIterations; iteration++ {
+		H := newBlockMatrix(len(estimates))
+		b := make([]float64, 3*len(estimates))
+
+		for _, i := range snapshot.anchors {
+			e := [3]float64{estimates[i].X - snapshot.priors[i].X, estimates[i].Y - snapshot.priors[i].Y, float64(geometry.Rad(estimates[i].Theta - snapshot.priors[i].Theta).Normalize())}
+			H.add(i, i, [3][3]float64{{priorInformation, 0, 0}, {0, priorInformation, 0}, {0, 0, priorInformation}})
+			for k := 0; k < 3; k++ {
+				b[3*i+k] += priorInformation * e[k]
+			}
+		}
+
+		for _, edge := range snapshot.edges {
+			e, A, B := edgeError(estimates[edge.from], estimates[edge.to], edge.measurement)
+			blocks := [2]struct {
+				index int
+				J     [3][3]float64
+			}{{edge.from, A}, {edge.to, B}}
+			for _, bi := range blocks {
+				for _, bj := range blocks {
+					var block [3][3]float64
+					for r := 0; r < 3; r++ {
+						for c := 0; c < 3; c++ {
+							for k := 0; k < 3; k++ {
+								block[r][c] += bi.J[k][r] * edge.information[k] * bj.J[k][c]
+							}
+						}
+					}
+					H.add(bi.index, bj.index, block)
+				}
+				for r := 0; r < 3; r++ {
+					sum := 0.0
+					for k := 0; k < 3; k++ {
+						sum += bi.J[k][r] * edge.information[k] * e[k]
+					}
+					b[3*bi.index+r] += sum
+				}
+			}
+		}
+
+		for i := range b {
+			b[i] = -b[i]
+		}
+		dx, ok := H.solve(b)
+		if !ok {
+			return nil, false
+		}
+		largest := 0.0
+		for i := range estimates {
+			estimates[i].X += dx[3*i]
+			estimates[i].Y += dx[3*i+1]
+			estimates[i].Theta = float64(geometry.Rad(estimates[i].Theta + dx[3*i+2]).Normalize())
+			largest = max(largest, math.Abs(dx[3*i]), math.Abs(dx[3*i+1]))
+		}
+		if largest < 0.01 {
+			break
+		}
+	}
+	return estimates, true
+}
+
+// setEstimates moves the keyframes of the snapshot to the optimized poses. Keyframes made since the
+// snapshot move with the latest keyframe of their robot in the snapshot, unless they start a new chain.
+func (g *poseGraph) setEstimates(snapshot graphSnapshot, estimates []geometry.Pose2D) {
+	moved := make(map[int]geometry.Pose2D)
+	for id, index := range snapshot.last {
+		moved[id] = estimates[index].Compose(g.keyframes[index].estimate.Inverse())
+	}
+	for i, estimate := range estimates {
+		g.keyframes[i].estimate = estimate
+	}
+	newChain := make(map[int]bool)
+	for i := len(estimates); i < len(g.keyframes); i++ {
+		kf := g.keyframes[i]
+		newChain[kf.robotId] = newChain[kf.robotId] || slices.Contains(g.anchors, i)
+		if !newChain[kf.robotId] {
+			kf.estimate = moved[kf.robotId].Compose(kf.estimate)
+		}
+	}
+
+	for id, index := range g.last {
+		kf := g.keyframes[index]
+		g.transforms[id] = kf.estimate.Compose(kf.odometry.Inverse())
+	}
+	g.changed = true
+}
+
+// edgeError returns the error of an edge and the Jacobians with respect to the two poses.
+func edgeError(xi, xj, z geometry.Pose2D) ([3]float64, [3][3]float64, [3][3]float64) {
+	ci, si := math.Cos(xi.Theta), math.Sin(xi.Theta)
+	dx, dy := xj.X-xi.X, xj.Y-xi.Y
+	//xj in the frame of xi, and its derivative with respect to theta_i
+	lx, ly := ci*dx+si*dy, -si*dx+ci*dy
+	dlx, dly := -si*dx+ci*dy, -ci*dx-si*dy
+
+	cz, sz := math.Cos(z.Theta), math.Sin(z.Theta)
+	e := [3]float64{
+		cz*(lx-z.X) + sz*(ly-z.Y),
+		-sz*(lx-z.X) + cz*(ly-z.Y),
+		float64(geometry.Rad(xj.Theta - xi.Theta - z.Theta).Normalize()),
+	}
+
+	//rotation by -(theta_i + theta_z)
+	ca, sa := math.Cos(xi.Theta+z.Theta), math.Sin(xi.Theta+z.Theta)
+	A := [3][3]float64{
+		{-ca, -sa, cz*dlx + sz*dly},
+		{sa, -ca, -sz*dlx + cz*dly},
+		{0, 0, -1},
+	}
+	B := [3][3]float64{
+		{ca, sa, 0},
+		{-sa, ca, 0},
+		{0, 0, 1},
+	}
+	return e, A, B
+}
+
+// blockMatrix is a sparse symmetric matrix of 3x3 blocks, one block row and column per keyframe. A
+// keyframe only has blocks for the keyframes it shares an edge with.
+type blockMatrix []map[int]*[3][3]float64
+
+func newBlockMatrix(n int) blockMatrix {
+	m := make(blockMatrix, n)
+	for i := range m {
+		m[i] = make(map[int]*[3][3]float64)
+	}
+	return m
+}
+
+func (m blockMatrix) add(i, j int, block [3][3]float64) {
+	sum, exist := m[i][j]
+	if !exist {
+		sum = &[3][3]float64{}
+		m[i][j] = sum
+	}
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			sum[r][c] += block[r][c]
+		}
+	}
+}
+
+func (m blockMatrix) mul(x []float64) []float64 {
+	y := make([]float64, len(x))
+	for i, row := range m {
+		for j, block := range row {
+			for r := 0; r < 3; r++ {
+				for c := 0; c < 3; c++ {
+					y[3*i+r] += block[r][c] * x[3*j+c]
+				}
+			}
+		}
+	}
+	return y
+}
+
+// solve solves m x = b with conjugate gradients, preconditioned with the inverse of the diagonal blocks.
+// It fails if m is not positive definite.
+func (m blockMatrix) solve(b []float64) ([]float64, bool) {
+	preconditioner := make([][3][3]float64, len(m))
+	for i, row := range m {
+		diagonal, exist := row[i]
+		if !exist {
+			return nil, false
+		}
+		var ok bool
+		if preconditioner[i], ok = invert3(*diagonal); !ok {
+			return nil, false
+		}
+	}
+	precondition := func(r []float64) []float64 {
+		z := make([]float64, len(r))
+		for i, inverse := range preconditioner {
+			for row := 0; row < 3; row++ {
+				for c := 0; c < 3; c++ {
+					z[3*i+row] += inverse[row][c] * r[3*i+c]
+				}
+			}
+		}
+		return z
+	}
+	dot := func(a, b []float64) float64 {
+		sum := 0.0
+		for i := range a {
+			sum += a[i] * b[i]
+		}
+		return sum
+	}
+
+	x := make([]float64, len(b))
+	r := slices.Clone(b)
+	z := precondition(r)
+	p := slices.Clone(z)
+	rz := dot(r, z)
+	tolerance := 1e-20 * dot(b, b)
+	for iteration := 0; iteration < 2*len(b) && dot(r, r) > tolerance; iteration++ {
+		Ap := m.mul(p)
+		curvature := dot(p, Ap)
+		if curvature <= 0 {
+			return nil, false
+		}
+		alpha := rz / curvature
+		for i := range x {
+			x[i] += alpha * p[i]
+			r[i] -= alpha * Ap[i]
+		}
+		z = precondition(r)
+		rzNext := dot(r, z)
+		for i := range p {
+			p[i] = z[i] + rzNext/rz*p[i]
+		}
+		rz = rzNext
+	}
+	return x, true
+}
+
+// invert3 inverts a 3x3 matrix, if it is not singular.
+func invert3(a [3][3]float64) ([3][3]float64, bool) {
+	var inverse [3][3]float64
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			//cofactor of a[c][r], for the transpose
+			r1, r2 := (c+1)%3, (c+2)%3
+			c1, c2 := (r+1)%3, (r+2)%3
+			inverse[r][c] = a[r1][c1]*a[r2][c2] - a[r1][c2]*a[r2][c1]
+		}
+	}
+	determinant := a[0][0]*inverse[0][0] + a[0][1]*inverse[1][0] + a[0][2]*inverse[2][0]
+	if determinant == 0 {
+		return inverse, false
+	}
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			inverse[r][c] /= determinant
+		}
+	}
+	return inverse, true
+}
+
+// takeView returns the graph for the GUI, or nil if it has not changed since the last call.
+func (g *poseGraph) takeView() *types.PoseGraphView {
+	if g == nil || !g.changed {
+		return nil
+	}
+	g.changed = false
+	view := &types.PoseGraphView{}
+	for _, kf := range g.keyframes {
+		x, y := kf.estimate.Position().Round()
+		view.Nodes = append(view.Nodes, [2]int{x, y})
+	}
+	for _, edge := range g.edges {
+		x1, y1 := g.keyframes[edge.from].estimate.Position().Round()
+		x2, y2 := g.keyframes[edge.to].estimate.Position().Round()
+		line := [4]int{x1, y1, x2, y2}
+		if edge.loopClosure {
+			view.LoopClosures = append(view.LoopClosures, line)
+		} else {
+			view.Edges = append(view.Edges, line)
+		}
+	}
+	return view
+}
@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"math"
+	"testing"
+)
+
+func TestPoseGraphOptimize(t *testing.T) {
+	//a robot drives a 100 cm square and returns to the start. The odometry has drifted, but the loop
+	//closure between the first and the last keyframe pulls the poses back into place.
+	truth := []geometry.Pose2D{{X: 0, Y: 0, Theta: 0}, {X: 100, Y: 0, Theta: math.Pi / 2}, {X: 100, Y: 100, Theta: math.Pi}, {X: 0, Y: 100, Theta: -math.Pi / 2}, {X: 0, Y: 0, Theta: 0}}
+	drift := []geometry.Pose2D{{X: 0, Y: 0, Theta: 0}, {X: 104, Y: 3, Theta: math.Pi/2 + 0.05}, {X: 110, Y: 108, Theta: math.Pi + 0.1}, {X: 8, Y: 115, Theta: -math.Pi/2 + 0.15}, {X: 12, Y: 14, Theta: 0.2}}
+
+	g := newPoseGraph()
+	for i := range truth {
+		g.keyframes = append(g.keyframes, &keyframe{robotId: 1, number: i, prior: drift[i], estimate: drift[i]})
+		if i > 0 {
+			g.edges = append(g.edges, graphEdge{
+				from:        i - 1,
+				to:          i,
+				measurement: truth[i-1].Between(truth[i]),
+				information: [3]float64{1, 1, 100},
+			})
+		}
+	}
+	g.edges = append(g.edges, graphEdge{from: 0, to: 4, measurement: geometry.Pose2D{}, information: loopClosureInformation, loopClosure: true})
+	g.anchors, g.last[1] = []int{0}, 4
+
+	g.startOptimization()
+	result := <-g.results
+	if !result.ok {
+		t.Fatal("The optimization failed")
+	}
+	g.setEstimates(result.snapshot, result.estimates)
+
+	for i, kf := range g.keyframes {
+		if math.Hypot(kf.estimate.X-truth[i].X, kf.estimate.Y-truth[i].Y) > 1 || math.Abs(float64(geometry.Rad(kf.estimate.Theta-truth[i].Theta).Normalize())) > 0.01 {
+			t.Errorf("Function optimize did not find the correct pose of keyframe %d. Expected: %v. Got: %v", i, truth[i], kf.estimate)
+		}
+	}
+}
+
+func TestEdgeErrorJacobians(t *testing.T) {
+	xi, xj, z := geometry.Pose2D{X: 10, Y: -5, Theta: 0.3}, geometry.Pose2D{X: 40, Y: 20, Theta: 1.2}, geometry.Pose2D{X: 25, Y: 10, Theta: 0.7}
+	_, A, B := edgeError(xi, xj, z)
+
+	//compare with numerical derivatives
+	const h = 1e-6
+	for c := 0; c < 3; c++ {
+		for k, pose := range []*geometry.Pose2D{&xi, &xj} {
+			original := *pose
+			delta := [3]float64{}
+			delta[c] = h
+			*pose = geometry.Pose2D{X: original.X + delta[0], Y: original.Y + delta[1], Theta: original.Theta + delta[2]}
+			ePlus, _, _ := edgeError(xi, xj, z)
+			*pose = geometry.Pose2D{X: original.X - delta[0], Y: original.Y - delta[1], Theta: original.Theta - delta[2]}
+			eMinus, _, _ := edgeError(xi, xj, z)
+			*pose = original
+
+			J := A
+			if k == 1 {
+				J = B
+			}
+			for r := 0; r < 3; r++ {
+				numerical := (ePlus[r] - eMinus[r]) / (2 * h)
+				if math.Abs(numerical-J[r][c]) > 1e-4 {
+					t.Errorf("Jacobian %d of edgeError is wrong at [%d][%d]. Expected: %f. Got: %f", k, r, c, numerical, J[r][c])
+				}
+			}
+		}
+	}
+}
+
+func TestBlockMatrixSolve(t *testing.T) {
+	//a chain of 20 keyframes with a loop closure, like the normal equations of a pose graph
+	const n = 20
+	H := newBlockMatrix(n)
+	H.add(0, 0, [3][3]float64{{priorInformation, 0, 0}, {0, priorInformation, 0}, {0, 0, priorInformation}})
+	link := func(i, j int, w float64) {
+		H.add(i, i, [3][3]float64{{w, 0, 0.5}, {0, w, 0}, {0.5, 0, w}})
+		H.add(j, j, [3][3]float64{{w, 0, 0.5}, {0, w, 0}, {0.5, 0, w}})
+		H.add(i, j, [3][3]float64{{-w, 0, -0.5}, {0, -w, 0}, {-0.5, 0, -w}})
+		H.add(j, i, [3][3]float64{{-w, 0, -0.5}, {0, -w, 0}, {-0.5, 0, -w}})
+	}
+	for i := 1; i < n; i++ {
+		link(i-1, i, 1)
+	}
+	link(0, n-1, 4)
+	b := make([]float64, 3*n)
+	for i := range b {
+		b[i] = math.Sin(float64(i))
+	}
+
+	x, ok := H.solve(b)
+	if !ok {
+		t.Fatal("The system was not solved")
+	}
+	for i, v := range H.mul(x) {
+		if math.Abs(v-b[i]) > 1e-6 {
+			t.Fatalf("Row %d of H x is %f, expected %f", i, v, b[i])
+		}
+	}
+
+	//a keyframe without any edge or prior makes the system singular
+	if _, ok := newBlockMatrix(2).solve(make([]float64, 6)); ok {
+		t.Error("Expected a singular system to fail")
+	}
+}
+
+func TestKeyframeObservationsThinned(t *testing.T) {
+	kf := &keyframe{}
+	total := 5 * config.KeyframeObservations
+	for i := 0; i < total; i++ {
+		kf.rays.add(graphRay{to: geometry.Vec2{X: float64(i)}})
+	}
+	items := kf.rays.items
+	if len(items) > config.KeyframeObservations || len(items) < config.KeyframeObservations/4 {
+		t.Fatalf("Expected at most %d and at least %d rays, got %d", config.KeyframeObservations, config.KeyframeObservations/4, len(items))
+	}
+	//the kept rays are evenly spread over all the rays
+	step := items[1].to.X - items[0].to.X
+	if items[0].to.X != 0 || items[len(items)-1].to.X < float64(total)-step {
+		t.Errorf("Expected rays from 0 to about %d, got %v to %v", total, items[0].to.X, items[len(items)-1].to.X)
+	}
+}
+
+func TestPoseGraphWorker(t *testing.T) {
+	//the square of TestPoseGraphOptimize, with an obstacle seen from the first and the last keyframe
+	truth := []geometry.Pose2D{{X: 0, Y: 0, Theta: 0}, {X: 100, Y: 0, Theta: math.Pi / 2}, {X: 100, Y: 100, Theta: math.Pi}, {X: 0, Y: 100, Theta: -math.Pi / 2}, {X: 0, Y: 0, Theta: 0}}
+	drift := []geometry.Pose2D{{X: 0, Y: 0, Theta: 0}, {X: 104, Y: 3, Theta: math.Pi/2 + 0.05}, {X: 110, Y: 108, Theta: math.Pi + 0.1}, {X: 8, Y: 115, Theta: -math.Pi/2 + 0.15}, {X: 12, Y: 14, Theta: 0.2}}
+	s := initFullSlamState()
+	s.graph = newPoseGraph()
+	g := s.graph
+	for i := range truth {
+		g.keyframes = append(g.keyframes, &keyframe{robotId: 1, number: i, odometry: drift[i], prior: drift[i], estimate: drift[i]})
+		if i > 0 {
+			g.edges = append(g.edges, graphEdge{from: i - 1, to: i, measurement: truth[i-1].Between(truth[i]), information: [3]float64{1, 1, 100}})
+		}
+	}
+	g.edges = append(g.edges, graphEdge{from: 0, to: 4, measurement: geometry.Pose2D{}, information: loopClosureInformation, loopClosure: true})
+	g.anchors, g.last[1] = []int{0}, 4
+	for i := 0; i < 5; i++ {
+		g.keyframes[0].rays.add(graphRay{to: geometry.Vec2{X: 30}, obstruction: true})
+		g.keyframes[4].rays.add(graphRay{to: geometry.Vec2{Y: -25}, obstruction: true})
+	}
+
+	g.startOptimization()
+	//the robot moves on while the worker runs
+	g.keyframes = append(g.keyframes, &keyframe{robotId: 1, number: 5, estimate: drift[4].Compose(geometry.Pose2D{X: 20})})
+	g.last[1] = 5
+	s.applyGraphResult(<-g.results)
+
+	if moved := g.keyframes[4].estimate; math.Hypot(moved.X, moved.Y) > 1 {
+		t.Errorf("Expected the last keyframe of the square at the origin, got %v", moved)
+	}
+	if relative := g.keyframes[4].estimate.Between(g.keyframes[5].estimate); math.Abs(relative.X-20) > 0.01 || math.Abs(relative.Y) > 0.01 {
+		t.Errorf("Expected the new keyframe to keep its pose relative to the last one, got %v", relative)
+	}
+	//the closed keyframe is replayed by the worker, and the open one by the backend
+	for _, cell := range [][2]int{{30, 0}, {0, -25}} {
+		if x, y := calculateMapIndex(cell[0], cell[1]); s.areaMap[x][y] != mapObstacle {
+			t.Errorf("Expected an obstacle at %v", cell)
+		}
+	}
+	if g.optimizing {
+		t.Error("Expected the worker to be done")
+	}
+}
+
+func TestWedgeReplay(t *testing.T) {
+	s := initFullSlamState()
+	s.graph = newPoseGraph()
+	s.id2index[1] = 0
+	s.multiRobot = append(s.multiRobot, *initRobotState(20, 10, 30))
+	s.graph.keyframes = []*keyframe{{robotId: 1, estimate: geometry.NewPose(15, 5, 20)}}
+	s.graph.last[1] = 0
+
+	s.addCameraFrame(1, types.CameraMsg{Id: 1, IrTowerAngle: 60})
+	kf := s.graph.keyframes[0]
+	if len(kf.wedges.items) != 1 {
+		t.Fatalf("Expected one wedge in the keyframe, got %d", len(kf.wedges.items))
+	}
+	replayed := cellUpdates{}
+	kf.replay(kf.estimate, func(updates cellUpdates) {
+		for cell, logOdds := range updates {
+			replayed[cell] = logOdds
+		}
+	})
+	cleared := 0
+	for x := range s.logOdds {
+		for y := range s.logOdds[x] {
+			if s.logOdds[x][y] != 0 {
+				cleared++
+				if replayed[[2]int{x, y}] != s.logOdds[x][y] {
+					t.Fatalf("Expected the replayed wedge to clear cell (%d, %d) like the frame", x, y)
+				}
+			}
+		}
+	}
+	if cleared == 0 || cleared != len(replayed) {
+		t.Errorf("Expected the replay to clear the %d cells of the frame, got %d", cleared, len(replayed))
+	}
+}
+
+func TestPoseGraphKeepsBase(t *testing.T) {
+	s := initFullSlamState()
+	s.graph = newPoseGraph()
+	s.id2index[1] = 0
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+
+	//an IR reading before the first keyframe of the robot, and a merged local map
+	s.addLineToMap(1, 0, 30)
+	local := &localMap{state: initFullSlamState()}
+	xIndex, yIndex := calculateMapIndex(-40, 10)
+	local.state.logOdds[xIndex][yIndex] = logOddsMax
+	s.localMaps[2] = local
+	s.mergeLocalMap(2, 0, 0, 90)
+
+	s.graph.update(1, geometry.NewPose(0, 0, 90), geometry.NewPose(0, 0, 90), [3]float64{})
+	s.graph.startOptimization()
+	s.applyGraphResult(<-s.graph.results)
+
+	for _, cell := range [][2]int{{0, 30}, {-40, 10}} {
+		if x, y := calculateMapIndex(cell[0], cell[1]); s.areaMap[x][y] != mapObstacle {
+			t.Errorf("Expected the obstacle at %v to be kept when the map is rebuilt", cell)
+		}
+	}
+	if x, y := calculateMapIndex(0, 15); s.areaMap[x][y] != mapOpen {
+		t.Error("Expected the cells in front of the IR obstacle to stay open")
+	}
+}
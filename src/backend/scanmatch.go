@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"golang-server/utilities"
+	"math"
+)
+
+// Scan matching corrects the drift of the robot poses before new sensor data is added to the map.
+// Messages are collected in a short window per robot. When the window is full, the obstacle points
+// in the window are aligned against the current occupancy grid with a correlative search over
+// small translations and rotations of the whole window about the latest position of the robot. The
+// best correction is applied to the whole window, and composed with the earlier corrections.
+
+// poseCorrection is a translation and a rotation about a pivot, found by matchScan.
+type poseCorrection struct {
+	dx, dy, dTheta int //cm, cm, degrees
+}
+
+// transform returns the correction as a rigid transform in the map frame, rotating about the pivot.
+func (c poseCorrection) transform(pivot geometry.Vec2) geometry.Pose2D {
+	rotation := geometry.NewPose(0, 0, geometry.Deg(c.dTheta))
+	toPivot := geometry.Pose2D{X: -pivot.X, Y: -pivot.Y}
+	back := geometry.Pose2D{X: pivot.X + float64(c.dx), Y: pivot.Y + float64(c.dy)}
+	return back.Compose(rotation).Compose(toPivot)
+}
+
+// scanEntry is one message in a scan window, stored together with the robot pose it was received at.
+type scanEntry struct {
+	pose   types.RobotState
+	ir     [4][2]int        //mm, body frame
+	camera *types.CameraMsg //nil for IR messages
+}
+
+// scanPoint is an obstacle point given relative to the pivot the window is rotated about.
+type scanPoint struct {
+	originX, originY int //cm, map coordinates
+	offset           geometry.Vec2
+}
+
+// applyPoseCorrection moves the pose of the robot, as reported by its EKF, by the accumulated
+// correction, a transform in the map frame.
+func (s *fullSlamState) applyPoseCorrection(id int) {
+	robot := &s.multiRobot[s.id2index[id]]
+	setBodyPose(robot, s.corrections[id].Compose(bodyPose(*robot)))
+}
+
+func (s *fullSlamState) addToScanWindow(id int, entry scanEntry) {
+	entry.pose = s.getRobot(id)
+	s.scanWindows[id] = append(s.scanWindows[id], entry)
+	if len(s.scanWindows[id]) >= config.ScanMatchWindow {
+		s.flushScanWindow(id)
+	}
+}
+
+// flushScanWindow matches the collected window against the map, corrects the pose of the robot and
+// adds the corrected sensor data to the map.
+func (s *fullSlamState) flushScanWindow(id int) {
+	window := s.scanWindows[id]
+	s.scanWindows[id] = nil
+	if len(window) == 0 {
+		return
+	}
+	index := s.id2index[id]
+	latest := s.multiRobot[index]
+	pivot := bodyPose(latest).Position()
+
+	delta := poseCorrection{}
+	points := scanWindowPoints(id, window, pivot)
+	if len(points) >= config.ScanMatchMinPoints {
+		var scoreBefore, scoreAfter int
+		delta, scoreBefore, scoreAfter = s.matchScan(points)
+		raw := s.rawPoses[id]
+		rawX, rawY, rawTheta := initPose(latest).Compose(odometryPose(raw[0], raw[1], raw[2])).Round()
+		correctedX, correctedY, correctedTheta := delta.transform(pivot).Compose(bodyPose(latest)).Round()
+		logger.Debug("Scan match",
+			"robot", id, "raw", [3]int{rawX, rawY, rawTheta},
+			"corrected", [3]int{correctedX, correctedY, correctedTheta},
+			"scoreBefore", scoreBefore, "scoreAfter", scoreAfter, "points", len(points),
+		)
+	}
+	correction := delta.transform(pivot)
+
+	for _, entry := range window {
+		robot := entry.pose
+		setBodyPose(&robot, correction.Compose(bodyPose(robot)))
+		s.multiRobot[index] = robot
+		if entry.camera != nil {
+			s.addCameraFrame(id, *entry.camera)
+		} else {
+			for _, ir := range entry.ir {
+				s.addIrSensorData(id, ir[0], ir[1])
+			}
+		}
+	}
+
+	setBodyPose(&latest, correction.Compose(bodyPose(latest)))
+	s.multiRobot[index] = latest
+	s.corrections[id] = correction.Compose(s.corrections[id])
+}
+
+// scanWindowPoints collects the obstacle points of a window, relative to the pivot. IR readings at max
+// distance are not obstacles and are left out.
+func scanWindowPoints(id int, window []scanEntry, pivot geometry.Vec2) []scanPoint {
+	camera := cameraModelFor(id)
+	points := make([]scanPoint, 0)
+	addPoint := func(x, y int) {
+		points = append(points, scanPoint{int(pivot.X), int(pivot.Y), geometry.Vec2{X: float64(x), Y: float64(y)}.Sub(pivot)})
+	}
+	for _, entry := range window {
+		robot := entry.pose
+		if entry.camera != nil {
+			robot.IrTowerAngle = entry.camera.IrTowerAngle
+			for _, segment := range entry.camera.Segments {
+				p1, p2, ok := camera.segmentEndpoints(robot, segment.StartMM, segment.WidthMM, segment.DistanceMM)
+				if ok {
+					utilities.SupercoverLine(p1.X, p1.Y, p2.X, p2.Y, func(x, y int) {
+						addPoint(x, y)
+					})
+				}
+			}
+			continue
+		}
+		for _, ir := range entry.ir {
+			x, y := transformIrSensorData(robot, ir[0], ir[1])
+			if math.Hypot(float64(x-robot.X), float64(y-robot.Y)) < config.IrSensorMaxDistance {
+				addPoint(x, y)
+			}
+		}
+	}
+	return points
+}
+
+// matchScan does a brute force correlative search for the correction that best aligns the points with
+// the obstacles in the map. Ties are resolved in favour of the smallest correction, so the pose is
+// left unchanged when the map gives no information.
+func (s *fullSlamState) matchScan(points []scanPoint) (poseCorrection, int, int) {
+	scoreBefore := s.scoreScan(points, poseCorrection{})
+	best, bestScore, bestCost := poseCorrection{}, scoreBefore, 0
+	for dTheta := -config.ScanMatchSearchAngle; dTheta <= config.ScanMatchSearchAngle; dTheta++ {
+		rotated := make([][2]int, len(points))
+		for i, p := range points {
+			rotated[i][0], rotated[i][1] = p.offset.Rotate(geometry.Deg(dTheta).Rad()).Round()
+		}
+		for dx := -config.ScanMatchSearchRadius; dx <= config.ScanMatchSearchRadius; dx++ {
+			for dy := -config.ScanMatchSearchRadius; dy <= config.ScanMatchSearchRadius; dy++ {
+				score := 0
+				for i, p := range points {
+					x := p.originX + dx + rotated[i][0]
+					y := p.originY + dy + rotated[i][1]
+					score += s.scoreCell(x, y)
+				}
+				cost := dx*dx + dy*dy + dTheta*dTheta
+				if score > bestScore || (score == bestScore && cost < bestCost) {
+					best, bestScore, bestCost = poseCorrection{dx, dy, dTheta}, score, cost
+				}
+			}
+		}
+	}
+	// A correction is only trusted when it reaches at least half of the maximum score.
+	if bestScore < len(points) {
+		return poseCorrection{}, scoreBefore, scoreBefore
+	}
+	return best, scoreBefore, bestScore
+}
+
+func (s *fullSlamState) scoreScan(points []scanPoint, c poseCorrection) int {
+	score := 0
+	for _, p := range points {
+		dx, dy := p.offset.Rotate(geometry.Deg(c.dTheta).Rad()).Round()
+		score += s.scoreCell(p.originX+c.dx+dx, p.originY+c.dy+dy)
+	}
+	return score
+}
+
+// scoreCell gives 2 points for hitting an obstacle, 1 point for hitting next to one, and 0 otherwise.
+func (s *fullSlamState) scoreCell(x, y int) int {
+	xIndex, yIndex := calculateMapIndex(x, y)
+	if xIndex < 1 || yIndex < 1 || xIndex >= config.MapSize-1 || yIndex >= config.MapSize-1 {
+		return 0
+	}
+	if s.areaMap[xIndex][yIndex] == mapObstacle {
+		return 2
+	}
+	if s.areaMap[xIndex+1][yIndex] == mapObstacle || s.areaMap[xIndex-1][yIndex] == mapObstacle ||
+		s.areaMap[xIndex][yIndex+1] == mapObstacle || s.areaMap[xIndex][yIndex-1] == mapObstacle {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"golang-server/geometry"
+	"golang-server/types"
+	"testing"
+)
+
+func TestMatchScan(t *testing.T) {
+	s := initFullSlamState()
+	//L-shaped corner, so both x and y are constrained
+	for i := -30; i <= 30; i++ {
+		x, y := calculateMapIndex(i, 30)
+		s.areaMap[x][y] = mapObstacle
+		x, y = calculateMapIndex(30, i)
+		s.areaMap[x][y] = mapObstacle
+	}
+
+	//the robot is really at (0, 0), but believes it is at (3, -2)
+	points := []scanPoint{}
+	for i := -20; i <= 20; i += 4 {
+		points = append(points, scanPoint{3, -2, geometry.Vec2{X: float64(i), Y: 30}})
+		points = append(points, scanPoint{3, -2, geometry.Vec2{X: 30, Y: float64(i)}})
+	}
+
+	correction, before, after := s.matchScan(points)
+	if correction != (poseCorrection{-3, 2, 0}) {
+		t.Errorf("Function matchScan found the wrong correction. Expected: %v. Got: %v", poseCorrection{-3, 2, 0}, correction)
+	}
+	if after <= before {
+		t.Errorf("Function matchScan did not improve the score. Before: %d. After: %d", before, after)
+	}
+
+	//without any obstacles in the map the pose must not be changed
+	empty := initFullSlamState()
+	if correction, _, _ := empty.matchScan(points); correction != (poseCorrection{}) {
+		t.Errorf("Function matchScan changed the pose without any map information. Got: %v", correction)
+	}
+}
+
+func TestPoseCorrectionComposes(t *testing.T) {
+	s := initFullSlamState()
+	s.id2index[1] = 0
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 0))
+
+	//a rotation about the pivot leaves the pivot in place
+	correction := poseCorrection{dx: 2, dTheta: 90}.transform(geometry.Vec2{X: 10})
+	if x, y, theta := correction.Compose(geometry.NewPose(10, 0, 0)).Round(); x != 12 || y != 0 || theta != 90 {
+		t.Errorf("Expected the pivot to move to (12, 0, 90), got (%d, %d, %d)", x, y, theta)
+	}
+
+	//later motion along the odometry x axis is turned by the correction too
+	s.corrections[1] = poseCorrection{dTheta: 90}.transform(geometry.Vec2{})
+	s.updateRobotPose(types.AdvMsg{Id: 1, X: 1000})
+	s.applyPoseCorrection(1)
+	if robot := s.getRobot(1); robot.X != 0 || robot.Y != 100 || robot.Theta != 90 {
+		t.Errorf("Expected the corrected pose (0, 100, 90), got (%d, %d, %d)", robot.X, robot.Y, robot.Theta)
+	}
+}
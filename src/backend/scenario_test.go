@@ -0,0 +1,361 @@
+package backend
+
+import (
+	"bytes"
+	"flag"
+	"golang-server/communication"
+	"golang-server/config"
+	"golang-server/types"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// scenario runs ThreadBackend without a broker, gui or robots. Robot messages and camera frames are
+// encoded and sent through an in-memory transport to the same subscribers as in the server, at scripted
+// times on a virtual clock. All input channels are unbuffered, so the backend handles the events in the
+// scripted order, and every gui tick works as a barrier: its update is only sent when all earlier events
+// are handled. The map is rebuilt from the gui updates, like the gui does.
+type scenario struct {
+	t      *testing.T
+	now    time.Duration
+	ticks  map[time.Duration]chan time.Time //by period, see newTicker
+	next   map[time.Duration]time.Duration  //virtual time of the next tick of each period
+	robots *communication.MemoryTransport
+
+	chPublish        chan types.PublishMsg
+	chPublishControl chan types.PublishMsg
+	chReceive        chan types.AdvMsg
+	chCamera         chan types.CameraMsg
+	chPendingInit    chan int
+	chUpdate         chan types.UpdateGui
+	chRobotInit      chan [4]int
+	chCommand        chan types.Command
+	chMergeProposal  chan types.MergeProposal
+	chMergeReject    chan int
+	chZones          chan []types.Zone
+	chNotice         chan string
+	chExport         chan types.ExportRequest
+	chShutdown       chan chan struct{}
+
+	areaMap   [config.MapSize][config.MapSize]uint8
+	update    types.UpdateGui //latest
+	published []types.PublishMsg
+	control   []types.PublishMsg
+	pending   []int
+	notices   []string
+}
+
+var (
+	guiPeriod     = time.Second / config.GuiFrameRate
+	trafficPeriod = config.TrafficCheckInterval * time.Millisecond
+	decayPeriod   = config.CellDecayInterval * time.Second
+)
+
+func newScenario(t *testing.T) *scenario {
+	sc := &scenario{
+		t:                t,
+		ticks:            map[time.Duration]chan time.Time{guiPeriod: make(chan time.Time)},
+		next:             map[time.Duration]time.Duration{guiPeriod: guiPeriod},
+		chPublish:        make(chan types.PublishMsg, 1024),
+		chPublishControl: make(chan types.PublishMsg, 1024),
+		chReceive:        make(chan types.AdvMsg),
+		chCamera:         make(chan types.CameraMsg),
+		chPendingInit:    make(chan int, 1024),
+		chUpdate:         make(chan types.UpdateGui),
+		chRobotInit:      make(chan [4]int),
+		chCommand:        make(chan types.Command),
+		chMergeProposal:  make(chan types.MergeProposal, 1024),
+		chMergeReject:    make(chan int),
+		chZones:          make(chan []types.Zone),
+		chNotice:         make(chan string, 1024),
+		chExport:         make(chan types.ExportRequest),
+		chShutdown:       make(chan chan struct{}),
+	}
+	sc.robots = communication.NewMemoryTransport()
+	communication.Subscribe(sc.robots, sc.chReceive)
+	communication.SubscribeCamera(sc.robots, sc.chCamera)
+	if config.UseTrafficManagement {
+		sc.ticks[trafficPeriod] = make(chan time.Time)
+		sc.next[trafficPeriod] = trafficPeriod
+	}
+	if config.UseCellDecay || config.UseChangeDetection {
+		sc.ticks[decayPeriod] = make(chan time.Time)
+		sc.next[decayPeriod] = decayPeriod
+	}
+	for x := range sc.areaMap {
+		for y := range sc.areaMap[x] {
+			sc.areaMap[x][y] = mapUnknown
+		}
+	}
+
+	realTicker := newTicker
+	newTicker = func(d time.Duration) <-chan time.Time {
+		ch, exist := sc.ticks[d]
+		if !exist {
+			panic("scenario has no ticker with period " + d.String())
+		}
+		return ch
+	}
+	go ThreadBackend(sc.chPublish, sc.chPublishControl, sc.chReceive, sc.chCamera, sc.chPendingInit, sc.chUpdate,
+		sc.chRobotInit, sc.chCommand, sc.chMergeProposal, sc.chMergeReject, sc.chZones, sc.chNotice, sc.chExport,
+		sc.chShutdown)
+	t.Cleanup(func() {
+		done := make(chan struct{})
+		sc.chShutdown <- done
+		<-done
+		newTicker = realTicker
+	})
+	return sc
+}
+
+// at advances the virtual clock, and ticks every ticker that is due on the way.
+func (sc *scenario) at(now time.Duration) {
+	for {
+		period := time.Duration(-1)
+		for p, next := range sc.next {
+			if next <= now && (period == -1 || next < sc.next[period] || next == sc.next[period] && p < period) {
+				period = p
+			}
+		}
+		if period == -1 {
+			break
+		}
+		sc.now = sc.next[period]
+		sc.next[period] += period
+		sc.tick(period)
+	}
+	sc.now = now
+}
+
+func (sc *scenario) tick(period time.Duration) {
+	sc.ticks[period] <- time.Unix(0, 0).Add(sc.now)
+	if period == guiPeriod {
+		sc.receiveUpdate()
+	}
+}
+
+// sync ticks the gui without advancing the clock, so everything sent so far is handled and collected.
+func (sc *scenario) sync() {
+	sc.tick(guiPeriod)
+}
+
+func (sc *scenario) receiveUpdate() {
+	sc.update = <-sc.chUpdate
+	//same order as redrawMap() in the gui
+	for _, cell := range sc.update.NewUnknown {
+		sc.areaMap[cell[0]][cell[1]] = mapUnknown
+	}
+	for _, cell := range sc.update.NewOpen {
+		sc.areaMap[cell[0]][cell[1]] = mapOpen
+	}
+	for _, cell := range sc.update.NewObstacle {
+		sc.areaMap[cell[0]][cell[1]] = mapObstacle
+	}
+	for {
+		select {
+		case msg := <-sc.chPublish:
+			sc.published = append(sc.published, msg)
+		case msg := <-sc.chPublishControl:
+			sc.control = append(sc.control, msg)
+		case id := <-sc.chPendingInit:
+			sc.pending = append(sc.pending, id)
+		case notice := <-sc.chNotice:
+			sc.notices = append(sc.notices, notice)
+		default:
+			return
+		}
+	}
+}
+
+// adv and camera return when the backend has received the message. Invalid messages are dropped by the
+// subscribers, like in the server.
+func (sc *scenario) adv(msg types.AdvMsg) {
+	sc.robots.Send(communication.StreamAdv, msg.Id, communication.EncodeAdvMsg(msg))
+}
+
+func (sc *scenario) camera(cam types.CameraMsg) {
+	sc.robots.Send(communication.StreamCamera, cam.Id, communication.EncodeCameraMsg(cam))
+}
+
+func (sc *scenario) initRobot(id, x, y, theta int) {
+	sc.chRobotInit <- [4]int{id, x, y, theta}
+}
+
+func (sc *scenario) command(command types.Command) {
+	sc.chCommand <- command
+}
+
+// robot returns the latest pose of the robot sent to the gui.
+func (sc *scenario) robot(id int) types.RobotState {
+	index, exist := sc.update.Id2index[id]
+	if !exist {
+		sc.t.Fatalf("robot %d is not initialized", id)
+	}
+	return sc.update.MultiRobot[index]
+}
+
+func (sc *scenario) cell(x, y int) uint8 {
+	xIndex, yIndex := calculateMapIndex(x, y)
+	return sc.areaMap[xIndex][yIndex]
+}
+
+// checkGolden compares the map with testdata/<name>.png, or rewrites it with -update.
+func (sc *scenario) checkGolden(name string) {
+	palette := color.Palette{color.Black, color.White, color.Gray{0x80}}
+	img := image.NewPaletted(image.Rect(0, 0, config.MapSize, config.MapSize), palette)
+	for x := range sc.areaMap {
+		for y := range sc.areaMap[x] {
+			switch sc.areaMap[x][y] {
+			case mapObstacle:
+				img.SetColorIndex(x, y, 0)
+			case mapOpen:
+				img.SetColorIndex(x, y, 1)
+			default:
+				img.SetColorIndex(x, y, 2)
+			}
+		}
+	}
+	var actual bytes.Buffer
+	if err := png.Encode(&actual, img); err != nil {
+		sc.t.Fatal(err)
+	}
+	path := filepath.Join("testdata", name+".png")
+	if *updateGolden {
+		if err := os.WriteFile(path, actual.Bytes(), 0666); err != nil {
+			sc.t.Fatal(err)
+		}
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		sc.t.Fatalf("%v, run go test ./backend -update to create it", err)
+	}
+	defer file.Close()
+	golden, err := png.Decode(file)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	differences := 0
+	for x := 0; x < config.MapSize; x++ {
+		for y := 0; y < config.MapSize; y++ {
+			r1, g1, b1, _ := golden.At(x, y).RGBA()
+			r2, g2, b2, _ := img.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				differences++
+			}
+		}
+	}
+	if differences > 0 {
+		actualPath := filepath.Join("testdata", name+"_actual.png")
+		os.WriteFile(actualPath, actual.Bytes(), 0666)
+		sc.t.Errorf("%d cells differ from %s, the map is written to %s", differences, path, actualPath)
+	}
+}
+
+// TestScenarioCorridor drives one robot down a corridor 60 cm wide with a wall ahead, seen by the camera.
+func TestScenarioCorridor(t *testing.T) {
+	sc := newScenario(t)
+
+	sc.at(0)
+	sc.adv(types.AdvMsg{Id: 5})
+	sc.sync()
+	if !reflect.DeepEqual(sc.pending, []int{5}) {
+		t.Fatalf("robots pending initialization: %v, expected [5]", sc.pending)
+	}
+	sc.initRobot(5, 0, 0, 90)
+
+	//the robot drives 1 m along its x axis, which is the map y axis, at 0.5 m/s
+	for i := 0; i <= 20; i++ {
+		sc.at(time.Duration(i) * 100 * time.Millisecond)
+		sc.adv(types.AdvMsg{
+			Id:   5,
+			X:    i * 50,
+			Ir1x: 0, Ir1y: 300, //left wall
+			Ir2x: 0, Ir2y: -300, //right wall
+			Ir3x: 1000, Ir3y: 0, //nothing ahead within range
+			Ir4x: -1000, Ir4y: 0, //nothing behind within range
+			IrTowerAngle: 90,
+			Valid:        1,
+		})
+	}
+	sc.at(2100 * time.Millisecond)
+	sc.camera(types.CameraMsg{Id: 5, FrameId: 1, IrTowerAngle: 90, Segments: []types.CameraSegment{{StartMM: -200, WidthMM: 400, DistanceMM: 500}}})
+	sc.sync()
+
+	if robot := sc.robot(5); robot.X != 0 || robot.Y != 100 || robot.Theta != 90 {
+		t.Errorf("Robot at (%d, %d, %d), expected (0, 100, 90)", robot.X, robot.Y, robot.Theta)
+	}
+	if sc.cell(-30, 50) != mapObstacle || sc.cell(30, 50) != mapObstacle || sc.cell(0, 50) != mapOpen {
+		t.Errorf("Walls at x=-30 and x=30 with open space between expected, got %d, %d, %d", sc.cell(-30, 50), sc.cell(30, 50), sc.cell(0, 50))
+	}
+	if len(sc.published) != 0 || len(sc.control) != 0 {
+		t.Errorf("Nothing should be published, got %v and %v", sc.published, sc.control)
+	}
+	sc.checkGolden("corridor")
+}
+
+// TestScenarioGoals sends crossing goals to two robots, then stops and resumes them.
+func TestScenarioGoals(t *testing.T) {
+	sc := newScenario(t)
+
+	sc.at(0)
+	sc.adv(types.AdvMsg{Id: 1})
+	sc.adv(types.AdvMsg{Id: 2})
+	sc.initRobot(1, 0, 0, 0)
+	sc.initRobot(2, 100, 0, 0)
+
+	//goals are sent in the robot frame, in mm
+	sc.at(1 * time.Second)
+	sc.command(types.Command{CommandType: types.ManualCommand, Id: 1, X: 100, Y: 60})
+	sc.at(1200 * time.Millisecond)
+	sc.command(types.Command{CommandType: types.ManualCommand, Id: 2, X: 0, Y: 60})
+	sc.sync()
+	expected := []types.PublishMsg{
+		{Kind: types.PublishTarget, Id: 1, Values: [2]int{1000, 600}},
+		{Kind: types.PublishTarget, Id: 2, Values: [2]int{-1000, 600}},
+	}
+	if !reflect.DeepEqual(sc.published, expected) {
+		t.Errorf("Published %v, expected %v", sc.published, expected)
+	}
+
+	//the paths cross, so the robot with the newest goal waits at the next traffic check
+	if config.UseTrafficManagement {
+		sc.at(1500 * time.Millisecond)
+		sc.sync()
+		if expected := []types.PublishMsg{{Kind: types.PublishPause, Id: 2}}; !reflect.DeepEqual(sc.control, expected) {
+			t.Errorf("Control published %v, expected %v", sc.control, expected)
+		}
+		if len(sc.update.Traffic) != 1 || sc.update.Traffic[0].Id != 2 || sc.update.Traffic[0].Other != 1 || !sc.update.Traffic[0].Waiting {
+			t.Errorf("Traffic %+v, expected robot 2 waiting for robot 1", sc.update.Traffic)
+		}
+	}
+
+	//goals are dropped while the robots are stopped
+	sc.control = nil
+	sc.at(2 * time.Second)
+	sc.command(types.Command{CommandType: types.StopCommand})
+	sc.command(types.Command{CommandType: types.ManualCommand, Id: 1, X: 0, Y: 0})
+	sc.command(types.Command{CommandType: types.ResumeCommand})
+	sc.sync()
+	if len(sc.published) != 2 {
+		t.Errorf("Goal published while the robots were stopped: %v", sc.published[2:])
+	}
+	expected = []types.PublishMsg{
+		{Kind: types.PublishStop, Id: 1}, {Kind: types.PublishStop, Id: 2},
+		{Kind: types.PublishResume, Id: 1}, {Kind: types.PublishResume, Id: 2},
+	}
+	if !reflect.DeepEqual(sc.control, expected) {
+		t.Errorf("Control published %v, expected %v", sc.control, expected)
+	}
+	if sc.update.Motion != types.MotionRunning {
+		t.Errorf("Motion %d after resume", sc.update.Motion)
+	}
+}
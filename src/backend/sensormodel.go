@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"golang-server/utilities"
+	"math"
+)
+
+// Every cell of the map holds the log-odds of being occupied. A sensor model decides how much a
+// measurement changes the log-odds, so a single noisy measurement no longer overwrites what earlier
+// measurements have seen. The cell value in areaMap follows from the log-odds and the thresholds below.
+
+const (
+	logOddsMax               float32 = 3.5
+	logOddsMin               float32 = -3.5
+	logOddsOccupiedThreshold float32 = 0.35
+	logOddsFreeThreshold     float32 = -0.35
+	logOddsHit               float32 = 1.2  //log-odds of a measured obstacle without noise
+	logOddsFree              float32 = -0.9 //log-odds of a free cell without noise
+)
+
+type sensorModel interface {
+	// hitLogOdds is added to the cell where an obstacle is measured at range r (cm).
+	hitLogOdds(r float64) float32
+	// freeLogOdds is added to a cell at range r (cm) in front of the measurement.
+	freeLogOdds(r float64) float32
+	// noise is the standard deviation (cm) of a range measurement at range r (cm). Cells within one
+	// standard deviation in front of an obstacle are not marked as free.
+	noise(r float64) float64
+}
+
+// beamModel is a range-only sensor like the IR sensors. The confidence of a measurement falls with the
+// noise, down to half of the noise free confidence.
+type beamModel struct {
+	noiseBase, noisePerCm float64
+}
+
+func (m beamModel) noise(r float64) float64 {
+	return m.noiseBase + m.noisePerCm*r
+}
+
+func (m beamModel) confidence(r float64) float32 {
+	return float32(max(m.noiseBase/m.noise(r), 0.5))
+}
+
+func (m beamModel) hitLogOdds(r float64) float32 {
+	return logOddsHit * m.confidence(r)
+}
+
+func (m beamModel) freeLogOdds(r float64) float32 {
+	return logOddsFree * m.confidence(r)
+}
+
+// cameraModel is the Nicla Vision camera on the IR tower. It reports segments at a distance in front
+// of the camera, which must be within its field of view and range.
+type cameraModel struct {
+	beamModel
+	mount config.SensorMount
+}
+
+func irModelFor(id int) beamModel {
+	if noise, exist := config.IrNoise[id]; exist {
+		return beamModel{noise.BaseCm, noise.PerCm}
+	}
+	return beamModel{config.IrNoiseBaseCm, config.IrNoisePerCm}
+}
+
+func cameraModelFor(id int) cameraModel {
+	mount, exist := config.CameraMounts[id]
+	if !exist {
+		mount = config.DefaultCameraMount
+	}
+	return cameraModel{beamModel{config.CameraNoiseBaseCm, config.CameraNoisePerCm}, mount}
+}
+
+// segmentEndpoints returns the end points of a camera segment in map coordinates. The segment is
+// clipped to the field of view, and ok is false if nothing of it is visible.
+func (m cameraModel) segmentEndpoints(robot types.RobotState, startMM, widthMM, distanceMM int) (p1, p2 geometry.Vec2, ok bool) {
+	// The camera measures along its tilted axis, only the horizontal part is used.
+	planarDist := geometry.Mm(float64(distanceMM) * math.Cos(float64(geometry.Deg(m.mount.TiltDeg).Rad())))
+	// Adjust distance for camera mounting offset
+	y := float64((planarDist + geometry.Mm(m.mount.OffsetMM)).Cm())
+	if y <= 0 || y > config.CameraMaxRangeCm {
+		return p1, p2, false
+	}
+
+	// camera upside down
+	x1, x2 := -float64(geometry.Mm(startMM+widthMM).Cm()), -float64(geometry.Mm(startMM).Cm())
+	limit := y * math.Tan(float64(geometry.Deg(config.CameraFovDeg/2).Rad()))
+	if min(x1, x2) > limit || max(x1, x2) < -limit {
+		return p1, p2, false
+	}
+	x1 = min(max(x1, -limit), limit)
+	x2 = min(max(x2, -limit), limit)
+
+	camera := cameraPose(robot).Transform()
+	return camera.Apply(geometry.Vec2{X: x1, Y: y}), camera.Apply(geometry.Vec2{X: x2, Y: y}), true
+}
+
+// fieldOfView returns points in map coordinates along the arc at rangeCm from the camera, spaced
+// about 1 cm apart.
+func (m cameraModel) fieldOfView(robot types.RobotState, rangeCm float64) []geometry.Vec2 {
+	return cameraArc(cameraPose(robot), rangeCm)
+}
+
+// cameraArc is fieldOfView for a camera at the pose in map coordinates.
+func cameraArc(camera geometry.Pose2D, rangeCm float64) []geometry.Vec2 {
+	fov := float64(geometry.Deg(config.CameraFovDeg).Rad())
+	steps := int(math.Ceil(rangeCm * fov))
+	points := make([]geometry.Vec2, 0, steps+1)
+	toMap := camera.Transform()
+	for i := 0; i <= steps; i++ {
+		angle := -fov/2 + fov*float64(i)/float64(steps)
+		points = append(points, toMap.Apply(geometry.Vec2{X: rangeCm * math.Sin(angle), Y: rangeCm * math.Cos(angle)}))
+	}
+	return points
+}
+
+// cellUpdates collects the log-odds changes of one measurement, so a cell that is crossed by several
+// rays of the same measurement is only updated once. Obstacles win over free space.
+type cellUpdates map[[2]int]float32
+
+func (u cellUpdates) add(x, y int, logOdds float32) {
+	if current, exist := u[[2]int{x, y}]; exist && (current > 0 || logOdds < 0) {
+		return
+	}
+	u[[2]int{x, y}] = logOdds
+}
+
+// cellDistance is the distance from p to the center of cell (x, y).
+func cellDistance(p geometry.Vec2, x, y int) float64 {
+	return math.Hypot(float64(x)-p.X, float64(y)-p.Y)
+}
+
+// addRay adds the free cells crossed by the ray from `from` to `to`, given as map indices, and the
+// obstacle at the end if obstruction is set.
+func (u cellUpdates) addRay(model sensorModel, from, to geometry.Vec2, obstruction bool) {
+	r := float64(to.Sub(from).Norm())
+	freeRange := r
+	if obstruction {
+		freeRange = r - model.noise(r)
+	}
+	var last [2]int
+	utilities.SupercoverLine(from.X, from.Y, to.X, to.Y, func(x, y int) {
+		last = [2]int{x, y}
+		if d := cellDistance(from, x, y); !obstruction || d < freeRange {
+			u.add(x, y, model.freeLogOdds(d))
+		}
+	})
+	if obstruction {
+		u.add(last[0], last[1], model.hitLogOdds(r))
+	}
+}
+
+// addWedge adds the cells between the camera at origin and the arc as free, e.g. the field of view of
+// a frame where nothing was seen. The points are given as map indices.
+func (u cellUpdates) addWedge(model sensorModel, origin geometry.Vec2, arc []geometry.Vec2) {
+	free := func(x, y int) {
+		u.add(x, y, model.freeLogOdds(cellDistance(origin, x, y)))
+	}
+	u.sweep(origin, arc, free)
+}
+
+// addSegment adds the cells of the segment from p1 to p2 as obstacles, and the cells between the robot
+// and the segment as free. The points are given as map indices. The free cells are swept as one
+// triangle rather than a ray to every cell of the segment, which visits the cells close to the robot
+// once instead of once per ray.
+func (u cellUpdates) addSegment(model sensorModel, robot, p1, p2 geometry.Vec2) {
+	utilities.SupercoverLine(p1.X, p1.Y, p2.X, p2.Y, func(x, y int) {
+		u.add(x, y, model.hitLogOdds(cellDistance(robot, x, y)))
+	})
+
+	//a cell is free if it is closer than the noise to where the ray through it hits the segment
+	edge := p2.Sub(p1)
+	toSegment := cross(p1.Sub(robot), edge)
+	free := func(x, y int) {
+		ray := geometry.Vec2{X: float64(x), Y: float64(y)}.Sub(robot)
+		denominator := cross(ray, edge)
+		if denominator == 0 {
+			return
+		}
+		d := float64(ray.Norm())
+		r := d * toSegment / denominator
+		if r > 0 && d < r-model.noise(r) {
+			u.add(x, y, model.freeLogOdds(d))
+		}
+	}
+	u.sweep(robot, []geometry.Vec2{p1, p2}, free)
+}
+
+// sweep visits the cells of the polygon from origin along the arc and back, including the cells its
+// edges only partly cover. Cells may be visited more than once.
+func (u cellUpdates) sweep(origin geometry.Vec2, arc []geometry.Vec2, visit func(x, y int)) {
+	polygon := make([][2]float64, 0, len(arc)+1)
+	polygon = append(polygon, [2]float64{origin.X, origin.Y})
+	for _, p := range arc {
+		polygon = append(polygon, [2]float64{p.X, p.Y})
+	}
+	utilities.FillPolygon(polygon, visit)
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		utilities.SupercoverLine(a[0], a[1], b[0], b[1], visit)
+	}
+}
+
+func cross(v, w geometry.Vec2) float64 {
+	return v.X*w.Y - v.Y*w.X
+}
+
+// applyUpdates adds the log-odds changes to the map and updates the cell values that change.
+func (s *fullSlamState) applyUpdates(updates cellUpdates) {
+	for cell, logOdds := range updates {
+		x, y := cell[0], cell[1]
+		s.observeCell(x, y, logOdds)
+		s.logOdds[x][y] = min(max(s.logOdds[x][y]+logOdds, logOddsMin), logOddsMax)
+		s.classifyCell(x, y)
+	}
+}
+
+// classifyCell sets the cell value from the log-odds of the cell.
+func (s *fullSlamState) classifyCell(x, y int) {
+	value := mapUnknown
+	if s.logOdds[x][y] > logOddsOccupiedThreshold {
+		value = mapObstacle
+	} else if s.logOdds[x][y] < logOddsFreeThreshold {
+		value = mapOpen
+	}
+	if value != s.areaMap[x][y] {
+		s.setMapValue(x, y, value)
+	}
+}
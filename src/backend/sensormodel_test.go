@@ -0,0 +1,266 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"golang-server/utilities"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestSensorModelConflict(t *testing.T) {
+	s := initFullSlamState()
+	id := 2
+	s.id2index[id] = len(s.multiRobot)
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+
+	//an obstacle measured once, and then a single beam passing through it
+	s.addLineToMap(id, 20, 0)
+	xIndex, yIndex := calculateMapIndex(20, 0)
+	if s.areaMap[xIndex][yIndex] != mapObstacle {
+		t.Fatalf("A single measurement did not add an obstacle to the map.")
+	}
+	s.addLineToMap(id, 40, 0)
+	if s.areaMap[xIndex][yIndex] == mapOpen {
+		t.Errorf("A single conflicting measurement removed an obstacle, it should only make it uncertain.")
+	}
+	s.addLineToMap(id, 40, 0)
+	s.addLineToMap(id, 40, 0)
+	if s.areaMap[xIndex][yIndex] != mapOpen {
+		t.Errorf("Repeated conflicting measurements did not clear the obstacle.")
+	}
+}
+
+func TestCameraModelFieldOfView(t *testing.T) {
+	model := cameraModel{beamModel{1, 0}, config.SensorMount{}}
+	robot := types.RobotState{X: 0, Y: 0, Theta: 90, IrTowerAngle: 90}
+
+	//a segment far outside the field of view is not visible
+	if _, _, ok := model.segmentEndpoints(robot, 2000, 100, 500); ok {
+		t.Errorf("A segment outside the field of view was accepted.")
+	}
+
+	//a segment wider than the field of view is clipped
+	p1, p2, ok := model.segmentEndpoints(robot, -2000, 4000, 500)
+	if !ok || p1.X < -50 || p2.X > 50 {
+		t.Errorf("A wide segment was not clipped to the field of view. Got x1: %v, x2: %v", p1.X, p2.X)
+	}
+}
+
+func TestIrModelPerRobot(t *testing.T) {
+	config.IrNoise[5] = config.SensorNoise{BaseCm: 1, PerCm: 0.03}
+	defer delete(config.IrNoise, 5)
+	if noise := irModelFor(5).noise(100); noise != 4 {
+		t.Errorf("Expected the noise of robot 5 at 100 cm to be 4 cm, got %v", noise)
+	}
+	if noise := irModelFor(6).noise(100); noise != config.IrNoiseBaseCm+100*config.IrNoisePerCm {
+		t.Errorf("Expected the default noise for robot 6, got %v", noise)
+	}
+}
+
+func TestCameraFrameNothingSeen(t *testing.T) {
+	s := initFullSlamState()
+	id := 2
+	s.id2index[id] = len(s.multiRobot)
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+
+	//an obstacle in front of the robot, seen by the camera with the tower turned forward
+	obstacle := types.CameraMsg{Id: id, IrTowerAngle: 90, Segments: []types.CameraSegment{{StartMM: -50, WidthMM: 100, DistanceMM: 370}}}
+	s.addCameraFrame(id, obstacle)
+	xIndex, yIndex := calculateMapIndex(0, 40)
+	if s.areaMap[xIndex][yIndex] != mapObstacle {
+		t.Fatalf("The camera segment did not add an obstacle to the map.")
+	}
+
+	//repeated frames where nothing is seen clear the field of view
+	for i := 0; i < 3; i++ {
+		s.addCameraFrame(id, types.CameraMsg{Id: id, IrTowerAngle: 90})
+	}
+	if s.areaMap[xIndex][yIndex] != mapOpen {
+		t.Errorf("Empty camera frames did not clear the field of view.")
+	}
+
+	//the tower angle of the frame is used, so a frame looking sideways does not touch the cell
+	s = initFullSlamState()
+	s.id2index[id] = 0
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+	s.addCameraFrame(id, types.CameraMsg{Id: id, IrTowerAngle: 0})
+	if s.logOdds[xIndex][yIndex] != 0 {
+		t.Errorf("A frame looking sideways changed a cell in front of the robot.")
+	}
+}
+
+func TestSupercoverLine(t *testing.T) {
+	collect := func(x0, y0, x1, y1 float64) [][2]int {
+		cells := make([][2]int, 0)
+		utilities.SupercoverLine(x0, y0, x1, y1, func(x, y int) { cells = append(cells, [2]int{x, y}) })
+		return cells
+	}
+	//through the corner at (1.5, 0.5) both cells next to it are visited
+	expected := [][2]int{{0, 0}, {1, 0}, {2, 0}, {1, 1}, {2, 1}, {3, 1}}
+	if got := collect(0, 0, 3, 1); !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	//end points between cell centers, Bresenham on the rounded points would skip (1, 1) or (1, 0)
+	expected = [][2]int{{0, 0}, {1, 0}, {1, 1}, {2, 1}}
+	if got := collect(0.4, 0.4, 2.4, 0.6); !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	expected = [][2]int{{2, 1}, {1, 1}, {1, 0}, {0, 0}}
+	if got := collect(2.4, 0.6, 0.4, 0.4); !slices.Equal(got, expected) {
+		t.Errorf("Expected %v backwards, got %v", expected, got)
+	}
+	if got := collect(-3.2, 5.1, -3.2, 5.1); !slices.Equal(got, [][2]int{{-3, 5}}) {
+		t.Errorf("Expected a single cell, got %v", got)
+	}
+}
+
+func TestFillPolygon(t *testing.T) {
+	cells := make(map[[2]int]int)
+	square := [][2]float64{{0.5, 0.5}, {3.5, 0.5}, {3.5, 3.5}, {0.5, 3.5}}
+	utilities.FillPolygon(square, func(x, y int) { cells[[2]int{x, y}]++ })
+	if len(cells) != 9 {
+		t.Errorf("Expected the 9 cells inside the square, got %v", cells)
+	}
+	for cell, count := range cells {
+		if cell[0] < 1 || cell[0] > 3 || cell[1] < 1 || cell[1] > 3 || count != 1 {
+			t.Errorf("Cell %v visited %d times", cell, count)
+		}
+	}
+}
+
+// addSegmentByRays is how camera segments were added before the triangle sweep, a ray from the robot
+// to every cell of the segment.
+func addSegmentByRays(u cellUpdates, model sensorModel, robot, p1, p2 geometry.Vec2) {
+	utilities.SupercoverLine(p1.X, p1.Y, p2.X, p2.Y, func(x, y int) {
+		u.addRay(model, robot, geometry.Vec2{X: float64(x), Y: float64(y)}, true)
+	})
+}
+
+func TestAddSegmentSweep(t *testing.T) {
+	model := cameraModelFor(1)
+	robot, p1, p2 := geometry.Vec2{X: 100.3, Y: 100.6}, geometry.Vec2{X: 60.2, Y: 160.7}, geometry.Vec2{X: 135.5, Y: 170.1}
+	swept, rays := cellUpdates{}, cellUpdates{}
+	swept.addSegment(model, robot, p1, p2)
+	addSegmentByRays(rays, model, robot, p1, p2)
+
+	//the same obstacles, and the free cells of the rays inside the triangle are also free in the sweep,
+	//apart from the cells at the edge of the noise in front of the segment. The rays end at the cell
+	//centers of the segment, so they also clear cells up to half a cell outside the triangle.
+	inside := make(map[[2]int]bool)
+	utilities.FillPolygon([][2]float64{{robot.X, robot.Y}, {p1.X, p1.Y}, {p2.X, p2.Y}}, func(x, y int) {
+		inside[[2]int{x, y}] = true
+	})
+	for cell, logOdds := range rays {
+		got, exist := swept[cell]
+		if logOdds > 0 && (!exist || got <= 0) {
+			t.Errorf("Obstacle at %v missing from the sweep", cell)
+		}
+		if logOdds < 0 && inside[cell] && !exist && cellDistance(robot, cell[0], cell[1]) < 55-model.noise(70) {
+			t.Errorf("Free cell at %v missing from the sweep", cell)
+		}
+	}
+	for cell, logOdds := range swept {
+		if logOdds > 0 && rays[cell] <= 0 {
+			t.Errorf("Obstacle at %v is not on the segment", cell)
+		}
+	}
+}
+
+// addRayBresenham is how rays were cast before the supercover traversal, a Bresenham line between the
+// cell centers of the end points, given as map indices.
+func addRayBresenham(u cellUpdates, model sensorModel, x0, y0, x1, y1 int, obstruction bool) {
+	r := math.Hypot(float64(x1-x0), float64(y1-y0))
+	freeRange := r
+	if obstruction {
+		freeRange = r - model.noise(r)
+	}
+	for _, p := range utilities.BresenhamAlgorithm(x0, y0, x1, y1) {
+		if d := math.Hypot(float64(p[0]-x0), float64(p[1]-y0)); !obstruction || d < freeRange {
+			u.add(p[0], p[1], model.freeLogOdds(d))
+		}
+	}
+	if obstruction {
+		u.add(x1, y1, model.hitLogOdds(r))
+	}
+}
+
+// How the benchmarks cast a camera frame into the map.
+const (
+	castSweep     = iota //addCameraFrame
+	castRays             //a supercover ray to every cell of the segment, see addSegmentByRays
+	castBresenham        //a Bresenham ray to every cell of the segment, see addRayBresenham
+)
+
+// A camera frame with two segments, and an empty frame that clears the field of view, at 30 frames/s
+// per robot. robots/core is the number of robots one core keeps up with.
+func benchmarkCameraFrame(b *testing.B, cam types.CameraMsg, cast int) {
+	s := initFullSlamState()
+	s.id2index[cam.Id] = 0
+	s.multiRobot = append(s.multiRobot, *initRobotState(13, -7, 90))
+	robot := s.getRobot(cam.Id)
+	robot.IrTowerAngle = cam.IrTowerAngle
+	model := cameraModelFor(cam.Id)
+	origin := clampedMapPoint(bodyPose(robot).Position())
+	x0, y0 := origin.Round()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if cast == castSweep {
+			s.addCameraFrame(cam.Id, cam)
+			continue
+		}
+		updates := cellUpdates{}
+		if len(cam.Segments) == 0 {
+			for _, end := range model.fieldOfView(robot, config.CameraClearRangeCm) {
+				if cast == castRays {
+					updates.addRay(model, origin, clampedMapPoint(end), false)
+				} else {
+					x1, y1 := clampedMapPoint(end).Round()
+					addRayBresenham(updates, model, x0, y0, x1, y1, false)
+				}
+			}
+		}
+		for _, segment := range cam.Segments {
+			p1, p2, _ := model.segmentEndpoints(robot, segment.StartMM, segment.WidthMM, segment.DistanceMM)
+			if cast == castRays {
+				addSegmentByRays(updates, model, origin, clampedMapPoint(p1), clampedMapPoint(p2))
+				continue
+			}
+			x1, y1 := clampedMapPoint(p1).Round()
+			x2, y2 := clampedMapPoint(p2).Round()
+			for _, p := range utilities.BresenhamAlgorithm(x1, y1, x2, y2) {
+				addRayBresenham(updates, model, x0, y0, p[0], p[1], true)
+			}
+		}
+		s.applyUpdates(updates)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds()/30, "robots/core")
+}
+
+var benchmarkSegments = []types.CameraSegment{{StartMM: -400, WidthMM: 300, DistanceMM: 900}, {StartMM: 50, WidthMM: 350, DistanceMM: 1400}}
+
+func BenchmarkCameraSegmentSweep(b *testing.B) {
+	benchmarkCameraFrame(b, types.CameraMsg{Id: 1, IrTowerAngle: 90, Segments: benchmarkSegments}, castSweep)
+}
+
+func BenchmarkCameraSegmentRays(b *testing.B) {
+	benchmarkCameraFrame(b, types.CameraMsg{Id: 1, IrTowerAngle: 90, Segments: benchmarkSegments}, castRays)
+}
+
+func BenchmarkCameraSegmentBresenham(b *testing.B) {
+	benchmarkCameraFrame(b, types.CameraMsg{Id: 1, IrTowerAngle: 90, Segments: benchmarkSegments}, castBresenham)
+}
+
+func BenchmarkCameraClearSweep(b *testing.B) {
+	benchmarkCameraFrame(b, types.CameraMsg{Id: 1, IrTowerAngle: 90}, castSweep)
+}
+
+func BenchmarkCameraClearRays(b *testing.B) {
+	benchmarkCameraFrame(b, types.CameraMsg{Id: 1, IrTowerAngle: 90}, castRays)
+}
+
+func BenchmarkCameraClearBresenham(b *testing.B) {
+	benchmarkCameraFrame(b, types.CameraMsg{Id: 1, IrTowerAngle: 90}, castBresenham)
+}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/types"
+	"math"
+	"slices"
+	"time"
+)
+
+// The robot panels of the gui show the latest message of each robot, how often it sends, and what it is
+// doing. The plots are kept by the gui, since it only needs the values it was sent.
+
+type robotTelemetry struct {
+	latest   types.AdvMsg
+	received []time.Time //within config.TelemetryRateWindow of the latest message
+	teleop   time.Time   //latest velocity command
+	source   string      //of the latest goal, e.g. types.SourceMission
+}
+
+// recordTelemetry is called for every message from an initialized robot.
+func (s *fullSlamState) recordTelemetry(msg types.AdvMsg, now time.Time) {
+	t := s.robotTelemetry(msg.Id)
+	t.latest = msg
+	t.received = append(t.received, now)
+	start := slices.IndexFunc(t.received, func(received time.Time) bool {
+		return now.Sub(received) < config.TelemetryRateWindow*time.Second
+	})
+	t.received = t.received[start:]
+}
+
+// recordCommand is called for every command sent to a robot.
+func (s *fullSlamState) recordCommand(command types.Command, id int, now time.Time) {
+	t := s.robotTelemetry(id)
+	if command.CommandType == types.TeleopCommand {
+		t.teleop = now
+	} else {
+		t.source = command.Source
+	}
+}
+
+func (s *fullSlamState) robotTelemetry(id int) *robotTelemetry {
+	t, exist := s.telemetry[id]
+	if !exist {
+		t = &robotTelemetry{}
+		s.telemetry[id] = t
+	}
+	return t
+}
+
+// telemetryView returns the telemetry of the initialized robots, in the order of s.multiRobot.
+func (s *fullSlamState) telemetryView(motion int, now time.Time) []types.RobotTelemetry {
+	view := make([]types.RobotTelemetry, len(s.multiRobot))
+	for id, index := range s.id2index {
+		robot := s.multiRobot[index]
+		view[index] = types.RobotTelemetry{Id: id, X: robot.X, Y: robot.Y, Theta: robot.Theta, Status: s.robotStatus(id, motion, now)}
+		t, exist := s.telemetry[id]
+		if !exist || len(t.received) == 0 {
+			continue
+		}
+		msg := t.latest
+		covariance := msg.Covariance()
+		for i := range view[index].Variance {
+			view[index].Variance[i] = covariance[i*6]
+		}
+		view[index].AccelX, view[index].AccelY, view[index].GyroZ = msg.AccelX, msg.AccelY, msg.GyroZ
+		view[index].IrTowerAngle, view[index].Valid = msg.IrTowerAngle, msg.Valid
+		view[index].LastSeen = t.received[len(t.received)-1]
+		//a robot that stopped sending has no recent messages
+		recent := slices.IndexFunc(t.received, func(received time.Time) bool {
+			return now.Sub(received) < config.TelemetryRateWindow*time.Second
+		})
+		if recent != -1 {
+			view[index].Rate = float64(len(t.received)-recent) / config.TelemetryRateWindow
+		}
+	}
+	return view
+}
+
+// robotStatus tells what the robot is doing: stopped with the others, waiting for traffic, driven by the
+// operator, or driving to a goal.
+func (s *fullSlamState) robotStatus(id, motion int, now time.Time) string {
+	if motion != types.MotionRunning {
+		return motionNames[motion]
+	}
+	if other, waiting := s.waiting[id]; waiting {
+		return fmt.Sprintf("waiting for NRF-%d", other)
+	}
+	//teleop commands are repeated at config.TeleopRate while the operator drives
+	t := s.telemetry[id]
+	if t != nil && now.Sub(t.teleop) < time.Second {
+		return "teleop"
+	}
+	goal, hasGoal := s.goals[id]
+	if !hasGoal {
+		return "idle"
+	}
+	status := fmt.Sprintf("driving to (%d, %d)", goal.x, goal.y)
+	robot := s.getRobot(id)
+	if math.Hypot(float64(goal.x-robot.X), float64(goal.y-robot.Y)) <= config.TrafficGoalReached {
+		status = fmt.Sprintf("at goal (%d, %d)", goal.x, goal.y)
+	}
+	if t != nil && t.source == types.SourceMission {
+		status += ", mission"
+	}
+	return status
+}
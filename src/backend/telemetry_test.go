@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"golang-server/config"
+	"golang-server/types"
+	"testing"
+	"time"
+)
+
+func TestTelemetryView(t *testing.T) {
+	s := initFullSlamState()
+	id := 3
+	s.id2index[id] = len(s.multiRobot)
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+	start := time.Now()
+	msg := types.AdvMsg{Id: id, AccelX: 0.5, GyroZ: -3, Valid: 1, IrTowerAngle: 45}
+	msg.SetCovariance([25]float32{0: 1, 6: 2, 12: 3, 18: 4, 24: 5, 1: 9})
+	//10 messages per second
+	for i := 0; i < 30; i++ {
+		s.recordTelemetry(msg, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	now := start.Add(2950 * time.Millisecond)
+
+	view := s.telemetryView(types.MotionRunning, now)
+	if len(view) != 1 {
+		t.Fatalf("Expected one robot, got %d", len(view))
+	}
+	robot := view[0]
+	if robot.Id != id || robot.Variance != [5]float32{1, 2, 3, 4, 5} || robot.AccelX != 0.5 || robot.GyroZ != -3 || robot.Valid != 1 || robot.IrTowerAngle != 45 {
+		t.Errorf("Telemetry does not match the latest message: %+v", robot)
+	}
+	if robot.Rate != 10 {
+		t.Errorf("Expected 10 messages per second, got %f", robot.Rate)
+	}
+	if robot.Status != "idle" {
+		t.Errorf("Expected an idle robot, got %q", robot.Status)
+	}
+
+	//the robot stops sending
+	if rate := s.telemetryView(types.MotionRunning, now.Add(config.TelemetryRateWindow*time.Second)); rate[0].Rate != 0 {
+		t.Errorf("Expected no messages per second from a silent robot, got %f", rate[0].Rate)
+	}
+}
+
+func TestRobotStatus(t *testing.T) {
+	s := initFullSlamState()
+	id := 3
+	s.id2index[id] = len(s.multiRobot)
+	s.multiRobot = append(s.multiRobot, *initRobotState(0, 0, 90))
+	now := time.Now()
+
+	s.recordCommand(types.Command{CommandType: types.ManualCommand, Source: types.SourceMission}, id, now)
+	s.setGoal(id, 50, 20, now)
+	if status := s.robotStatus(id, types.MotionRunning, now); status != "driving to (50, 20), mission" {
+		t.Errorf("Unexpected status %q", status)
+	}
+	if status := s.robotStatus(id, types.MotionPaused, now); status != "paused" {
+		t.Errorf("Unexpected status %q while paused", status)
+	}
+	s.waiting[id] = 4
+	if status := s.robotStatus(id, types.MotionRunning, now); status != "waiting for NRF-4" {
+		t.Errorf("Unexpected status %q while waiting", status)
+	}
+	delete(s.waiting, id)
+	s.recordCommand(types.Command{CommandType: types.TeleopCommand}, id, now)
+	if status := s.robotStatus(id, types.MotionRunning, now.Add(100*time.Millisecond)); status != "teleop" {
+		t.Errorf("Unexpected status %q while driven", status)
+	}
+}
@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/types"
+	"math"
+	"sort"
+	"time"
+)
+
+// Traffic management. Robots drive straight to their goals, so the path of each robot is the line from
+// its pose to its goal, reached at config.TrafficSpeed. Paths are reserved cell by cell in order of goal
+// age: a robot whose path meets the reservation of a robot with an older goal is paused until the next
+// check where its path is free. Robots without a goal, or waiting, reserve their cell for all time.
+// Waiting does not help against those, so such conflicts are only reported.
+
+type activeGoal struct {
+	x, y int       //cm, map coordinates
+	sent time.Time //older goals have priority
+}
+
+type reservation struct {
+	id       int
+	from, to float64 //s from now
+}
+
+// setGoal records the goal published to a robot.
+func (s *fullSlamState) setGoal(id, x, y int, now time.Time) {
+	s.goals[id] = activeGoal{x, y, now}
+}
+
+// releaseTrafficWait resumes a waiting robot, e.g. because the operator sent it a new goal or is driving
+// it. It is paused again by the next check if the conflict is still there.
+func (s *fullSlamState) releaseTrafficWait(chPublishControl chan<- types.PublishMsg, id int) {
+	if _, waiting := s.waiting[id]; waiting {
+		delete(s.waiting, id)
+		publishTrafficWait(chPublishControl, id, false)
+	}
+}
+
+// publishTrafficWait pauses or resumes a robot for traffic. Unlike the emergency stop, it is not retained,
+// so a robot is not left paused by a server that has exited.
+func publishTrafficWait(chPublishControl chan<- types.PublishMsg, id int, wait bool) {
+	kind := uint8(types.PublishResume)
+	if wait {
+		kind = types.PublishPause
+	}
+	chPublishControl <- types.PublishMsg{Kind: kind, Id: id, Traffic: true}
+}
+
+// planTraffic predicts conflicts between the robots. Robots that have reached their goals lose them.
+func (s *fullSlamState) planTraffic() []types.TrafficConflict {
+	moving := []int{}
+	for id, goal := range s.goals {
+		robot := s.getRobot(id)
+		if math.Hypot(float64(goal.x-robot.X), float64(goal.y-robot.Y)) <= config.TrafficGoalReached {
+			delete(s.goals, id)
+			continue
+		}
+		moving = append(moving, id)
+	}
+	sort.Slice(moving, func(i, j int) bool {
+		a, b := s.goals[moving[i]], s.goals[moving[j]]
+		if !a.sent.Equal(b.sent) {
+			return a.sent.Before(b.sent)
+		}
+		return moving[i] < moving[j]
+	})
+
+	reservations := map[[2]int][]reservation{}
+	reserveStatic := func(id int) {
+		robot := s.getRobot(id)
+		cell := trafficCell(float64(robot.X), float64(robot.Y))
+		reservations[cell] = append(reservations[cell], reservation{id, 0, math.Inf(1)})
+	}
+	for id := range s.id2index {
+		if _, hasGoal := s.goals[id]; !hasGoal {
+			reserveStatic(id)
+		}
+	}
+
+	conflicts := []types.TrafficConflict{}
+	for _, id := range moving {
+		robot, goal := s.getRobot(id), s.goals[id]
+		path := trafficPath(float64(robot.X), float64(robot.Y), float64(goal.x), float64(goal.y))
+		if conflict, found := findConflict(reservations, id, path); found {
+			conflicts = append(conflicts, conflict)
+			if conflict.Waiting {
+				reserveStatic(id)
+				continue
+			}
+		}
+		for _, step := range path {
+			cell := trafficCell(step[0], step[1])
+			reservations[cell] = append(reservations[cell], reservation{id, step[2] - config.TrafficTimeMargin, step[2] + config.TrafficTimeMargin})
+		}
+	}
+	return conflicts
+}
+
+// applyTraffic pauses the robots that have to wait, and resumes the robots that no longer have to.
+func (s *fullSlamState) applyTraffic(chPublishControl chan<- types.PublishMsg, chB2gNotice chan<- string, conflicts []types.TrafficConflict) {
+	waits := map[int]int{}
+	for _, conflict := range conflicts {
+		if conflict.Waiting {
+			waits[conflict.Id] = conflict.Other
+		}
+	}
+	for id := range s.waiting {
+		if _, stillWaiting := waits[id]; !stillWaiting {
+			publishTrafficWait(chPublishControl, id, false)
+			logger.Info("Traffic wait over, robot resumed", "robot", id)
+		}
+	}
+	for _, conflict := range conflicts {
+		if _, alreadyWaiting := s.waiting[conflict.Id]; conflict.Waiting && !alreadyWaiting {
+			publishTrafficWait(chPublishControl, conflict.Id, true)
+			notify(chB2gNotice, fmt.Sprintf("Robot %d waits for robot %d near (%d, %d).", conflict.Id, conflict.Other, conflict.X, conflict.Y))
+		} else if !conflict.Waiting && !s.blocked[conflict.Id] {
+			notify(chB2gNotice, fmt.Sprintf("Robot %d is blocked by robot %d near (%d, %d).", conflict.Id, conflict.Other, conflict.X, conflict.Y))
+		}
+	}
+	s.waiting = waits
+	s.blocked = map[int]bool{}
+	for _, conflict := range conflicts {
+		if !conflict.Waiting {
+			s.blocked[conflict.Id] = true
+		}
+	}
+}
+
+// findConflict returns the first step of the path that meets a reservation of another robot in the same
+// or a neighbouring cell.
+func findConflict(reservations map[[2]int][]reservation, id int, path [][3]float64) (types.TrafficConflict, bool) {
+	for _, step := range path {
+		cell := trafficCell(step[0], step[1])
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, r := range reservations[[2]int{cell[0] + dx, cell[1] + dy}] {
+					if r.id == id || step[2] < r.from || step[2] > r.to {
+						continue
+					}
+					return types.TrafficConflict{
+						Id:      id,
+						Other:   r.id,
+						X:       int(math.Round(step[0])),
+						Y:       int(math.Round(step[1])),
+						Waiting: !math.IsInf(r.to, 1),
+					}, true
+				}
+			}
+		}
+	}
+	return types.TrafficConflict{}, false
+}
+
+// trafficPath samples the straight path twice per cell, as x, y [cm] and the time it is reached [s].
+func trafficPath(x0, y0, x1, y1 float64) [][3]float64 {
+	distance := math.Hypot(x1-x0, y1-y0)
+	steps := int(math.Ceil(distance / (config.TrafficCellSize / 2.0)))
+	path := make([][3]float64, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		f := 1.0
+		if steps > 0 {
+			f = float64(i) / float64(steps)
+		}
+		t := f * distance / config.TrafficSpeed
+		if t > config.TrafficHorizon {
+			break
+		}
+		path = append(path, [3]float64{x0 + f*(x1-x0), y0 + f*(y1-y0), t})
+	}
+	return path
+}
+
+func trafficCell(x, y float64) [2]int {
+	return [2]int{int(math.Floor(x / config.TrafficCellSize)), int(math.Floor(y / config.TrafficCellSize))}
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"golang-server/types"
+	"testing"
+	"time"
+)
+
+func TestPlanTrafficCrossingPaths(t *testing.T) {
+	state := initFullSlamState()
+	for i, pose := range [][2]int{{-100, 0}, {0, -100}} {
+		state.id2index[i+1] = i
+		state.multiRobot = append(state.multiRobot, *initRobotState(pose[0], pose[1], 0))
+	}
+	now := time.Now()
+	//both robots reach (0, 0) after about 7 s, robot 1 got its goal first
+	state.setGoal(1, 100, 0, now)
+	state.setGoal(2, 0, 100, now.Add(time.Second))
+
+	conflicts := state.planTraffic()
+	if len(conflicts) != 1 || conflicts[0].Id != 2 || conflicts[0].Other != 1 || !conflicts[0].Waiting {
+		t.Fatalf("Expected robot 2 to wait for robot 1, got %+v", conflicts)
+	}
+
+	chPublishControl := make(chan types.PublishMsg, 4)
+	chB2gNotice := make(chan string, 4)
+	state.applyTraffic(chPublishControl, chB2gNotice, conflicts)
+	if msg := <-chPublishControl; msg.Kind != types.PublishPause || msg.Id != 2 || !msg.Traffic {
+		t.Errorf("Expected a traffic pause for robot 2, got %+v", msg)
+	}
+
+	//robot 1 has passed the crossing
+	state.multiRobot[0].X = 60
+	conflicts = state.planTraffic()
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts after robot 1 passed, got %+v", conflicts)
+	}
+	state.applyTraffic(chPublishControl, chB2gNotice, conflicts)
+	if msg := <-chPublishControl; msg.Kind != types.PublishResume || msg.Id != 2 || !msg.Traffic {
+		t.Errorf("Expected a traffic resume for robot 2, got %+v", msg)
+	}
+}
+
+func TestPlanTrafficGoalReached(t *testing.T) {
+	state := initFullSlamState()
+	state.id2index[1] = 0
+	state.multiRobot = append(state.multiRobot, *initRobotState(0, 0, 0))
+	state.setGoal(1, 5, 0, time.Now())
+	state.planTraffic()
+	if _, exist := state.goals[1]; exist {
+		t.Errorf("Goal within TrafficGoalReached was not removed")
+	}
+}
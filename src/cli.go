@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"golang-server/config"
+	"golang-server/export"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runExport exports a saved session without starting the server:
+//
+//	go run . export [flags] <session directory, map.png or trajectory log>
+//
+// It returns the exit code.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "map.svg", "output file, the format is taken from the extension unless -format is given")
+	format := flags.String("format", "", "output format: "+strings.Join(export.Formats, ", "))
+	layers := flags.String("layers", strings.Join(export.LayerNames(), ","), "comma separated layers to draw")
+	crop := flags.Bool("crop", false, "crop to the explored part of the map")
+	scale := flags.Int("scale", config.ExportPngScale, "px per cm")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: go run . export [flags] <session directory, map.png or trajectory log>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*output)), ".")
+	}
+	selected, err := export.ParseLayers(strings.Split(*layers, ","))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	scene, err := export.LoadSession(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load the session:", err)
+		return 1
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = export.Write(file, *format, scene, export.Options{Layers: selected, Crop: *crop, Scale: max(*scale, 1)})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to export:", err)
+		os.Remove(*output)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"golang-server/types"
+)
+
+// Payloads between the server and the robots, the same with every transport. Robot payloads are decoded
+// by the subscribers, and encoded by simulated robots and tests.
+
+// advWire is the layout of v2/robot/NRF_x/adv, little-endian without padding.
+type advWire struct {
+	Id             uint8
+	X, Y           int16 //mm, robot frame
+	Theta          int16 //degrees
+	AccelX, AccelY float32
+	GyroZ          float32
+	Ir             [4][2]int16 //mm, robot body frame, x and y of each IR sensor
+	Covariance     [25]float32
+	Valid          uint8
+	IrTowerAngle   uint8 //degrees
+}
+
+const advMsgSize = 137 //binary.Size(advWire{})
+
+const (
+	cameraHeaderSize  = 9 //id, frame id, timestamp, tower angle, number of segments
+	cameraSegmentSize = 6 //start, width, distance
+)
+
+// EncodeCommand packs a command: uint8 kind, then two int16 values, little-endian.
+func EncodeCommand(msg types.PublishMsg) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, msg.Kind) //because the robot code expects a byte here
+	binary.Write(buf, binary.LittleEndian, int16(msg.Values[0]))
+	binary.Write(buf, binary.LittleEndian, int16(msg.Values[1]))
+	return buf.Bytes()
+}
+
+// DecodeAdvMsg unpacks an adv message. The payload must be advMsgSize bytes.
+func DecodeAdvMsg(payload []byte) (types.AdvMsg, error) {
+	if len(payload) != advMsgSize {
+		return types.AdvMsg{}, fmt.Errorf("payload is %d bytes, expected %d", len(payload), advMsgSize)
+	}
+	var m advWire
+	if err := binary.Read(bytes.NewReader(payload), binary.LittleEndian, &m); err != nil {
+		return types.AdvMsg{}, err
+	}
+	msg := types.AdvMsg{
+		Id:           int(m.Id),
+		X:            int(m.X),
+		Y:            int(m.Y),
+		Theta:        int(m.Theta),
+		Ir1x:         int(m.Ir[0][0]),
+		Ir1y:         int(m.Ir[0][1]),
+		Ir2x:         int(m.Ir[1][0]),
+		Ir2y:         int(m.Ir[1][1]),
+		Ir3x:         int(m.Ir[2][0]),
+		Ir3y:         int(m.Ir[2][1]),
+		Ir4x:         int(m.Ir[3][0]),
+		Ir4y:         int(m.Ir[3][1]),
+		IrTowerAngle: int(m.IrTowerAngle),
+		Valid:        m.Valid,
+		AccelX:       m.AccelX,
+		AccelY:       m.AccelY,
+		GyroZ:        m.GyroZ,
+	}
+	msg.SetCovariance(m.Covariance)
+	return msg, nil
+}
+
+// EncodeAdvMsg packs an adv message like a robot does. Values out of range of the wire types wrap.
+func EncodeAdvMsg(msg types.AdvMsg) []byte {
+	m := advWire{
+		Id:           uint8(msg.Id),
+		X:            int16(msg.X),
+		Y:            int16(msg.Y),
+		Theta:        int16(msg.Theta),
+		AccelX:       msg.AccelX,
+		AccelY:       msg.AccelY,
+		GyroZ:        msg.GyroZ,
+		Ir:           [4][2]int16{{int16(msg.Ir1x), int16(msg.Ir1y)}, {int16(msg.Ir2x), int16(msg.Ir2y)}, {int16(msg.Ir3x), int16(msg.Ir3y)}, {int16(msg.Ir4x), int16(msg.Ir4y)}},
+		Covariance:   msg.Covariance(),
+		Valid:        msg.Valid,
+		IrTowerAngle: uint8(msg.IrTowerAngle),
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, m)
+	return buf.Bytes()
+}
+
+// DecodeCameraMsg unpacks a camera frame. The payload is little-endian:
+//
+//	uint8 id, uint16 frame id, uint32 timestamp (ms), uint8 tower angle (degrees), uint8 N,
+//	N x (int16 start, int16 width, int16 distance) in mm.
+//
+// N = 0 means that nothing was detected inside the field of view.
+func DecodeCameraMsg(payload []byte) (types.CameraMsg, error) {
+	if len(payload) < cameraHeaderSize {
+		return types.CameraMsg{}, fmt.Errorf("payload is %d bytes, header needs %d", len(payload), cameraHeaderSize)
+	}
+	var header cameraHeader
+	reader := bytes.NewReader(payload)
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return types.CameraMsg{}, err
+	}
+	if len(payload) != cameraHeaderSize+cameraSegmentSize*int(header.N) {
+		return types.CameraMsg{}, fmt.Errorf("payload is %d bytes, %d segments need %d", len(payload), header.N, cameraHeaderSize+cameraSegmentSize*int(header.N))
+	}
+	if header.TowerAngle > 180 {
+		return types.CameraMsg{}, fmt.Errorf("tower angle out of range: %d", header.TowerAngle)
+	}
+	segments := make([][3]int16, header.N)
+	if err := binary.Read(reader, binary.LittleEndian, segments); err != nil {
+		return types.CameraMsg{}, err
+	}
+
+	cam := types.CameraMsg{
+		Id:           int(header.Id),
+		FrameId:      int(header.FrameId),
+		TimestampMs:  int(header.TimestampMs),
+		IrTowerAngle: int(header.TowerAngle),
+		Segments:     make([]types.CameraSegment, 0, header.N),
+	}
+	for _, s := range segments {
+		cam.Segments = append(cam.Segments, types.CameraSegment{StartMM: int(s[0]), WidthMM: int(s[1]), DistanceMM: int(s[2])})
+	}
+	return cam, nil
+}
+
+// EncodeCameraMsg packs a camera frame like the camera module does.
+func EncodeCameraMsg(cam types.CameraMsg) []byte {
+	header := cameraHeader{
+		Id:          uint8(cam.Id),
+		FrameId:     uint16(cam.FrameId),
+		TimestampMs: uint32(cam.TimestampMs),
+		TowerAngle:  uint8(cam.IrTowerAngle),
+		N:           uint8(len(cam.Segments)),
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, header)
+	for _, s := range cam.Segments {
+		binary.Write(buf, binary.LittleEndian, [3]int16{int16(s.StartMM), int16(s.WidthMM), int16(s.DistanceMM)})
+	}
+	return buf.Bytes()
+}
+
+type cameraHeader struct {
+	Id          uint8
+	FrameId     uint16
+	TimestampMs uint32
+	TowerAngle  uint8
+	N           uint8
+}
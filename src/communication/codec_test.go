@@ -0,0 +1,40 @@
+package communication
+
+import (
+	"encoding/binary"
+	"golang-server/types"
+	"reflect"
+	"testing"
+)
+
+func TestAdvMsgRoundTrip(t *testing.T) {
+	if size := binary.Size(advWire{}); size != advMsgSize {
+		t.Fatalf("advWire is %d bytes, advMsgSize is %d", size, advMsgSize)
+	}
+	msg := types.AdvMsg{Id: 5, X: -120, Y: 340, Theta: 95, Ir1x: 100, Ir1y: -20, Ir2x: 0, Ir2y: 250, Ir3x: -80, Ir3y: 0, Ir4x: 12, Ir4y: 13, IrTowerAngle: 45, Valid: 1, AccelX: 0.5, AccelY: -0.25, GyroZ: 12}
+	var covariance [25]float32
+	for i := range covariance {
+		covariance[i] = float32(i) / 10
+	}
+	msg.SetCovariance(covariance)
+
+	payload := EncodeAdvMsg(msg)
+	if len(payload) != advMsgSize {
+		t.Fatalf("encoded %d bytes, expected %d", len(payload), advMsgSize)
+	}
+	decoded, err := DecodeAdvMsg(payload)
+	if err != nil || decoded != msg {
+		t.Errorf("decoded %+v, %v, expected %+v", decoded, err, msg)
+	}
+	if _, err := DecodeAdvMsg(payload[1:]); err == nil {
+		t.Error("short payload accepted")
+	}
+}
+
+func TestCameraMsgRoundTrip(t *testing.T) {
+	cam := types.CameraMsg{Id: 5, FrameId: 300, TimestampMs: 99, IrTowerAngle: 120, Segments: []types.CameraSegment{{StartMM: -50, WidthMM: 100, DistanceMM: 700}}}
+	decoded, err := DecodeCameraMsg(EncodeCameraMsg(cam))
+	if err != nil || !reflect.DeepEqual(decoded, cam) {
+		t.Errorf("decoded %+v, %v, expected %+v", decoded, err, cam)
+	}
+}
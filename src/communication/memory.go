@@ -0,0 +1,56 @@
+package communication
+
+import "sync"
+
+// MemoryTransport connects the server to robots in the same process, e.g. tests or simulated robots.
+type MemoryTransport struct {
+	subscribers
+	mu        sync.Mutex
+	published []MemoryMsg
+	OnPublish func(msg MemoryMsg) //called for every published payload if set
+}
+
+// MemoryMsg is a payload published to a robot.
+type MemoryMsg struct {
+	Stream   Stream
+	Id       int
+	Payload  []byte
+	Delivery Delivery
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Send delivers a payload from a robot to the subscribers, and returns when they have handled it.
+func (t *MemoryTransport) Send(stream Stream, id int, payload []byte) {
+	t.dispatch(stream, id, payload)
+}
+
+// Published returns everything published so far.
+func (t *MemoryTransport) Published() []MemoryMsg {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]MemoryMsg{}, t.published...)
+}
+
+func (t *MemoryTransport) Subscribe(stream Stream, handler func(id int, payload []byte)) error {
+	t.add(stream, handler)
+	return nil
+}
+
+func (t *MemoryTransport) Publish(stream Stream, id int, payload []byte, delivery Delivery) error {
+	msg := MemoryMsg{stream, id, append([]byte{}, payload...), delivery}
+	t.mu.Lock()
+	t.published = append(t.published, msg)
+	onPublish := t.OnPublish
+	t.mu.Unlock()
+	if onPublish != nil {
+		onPublish(msg)
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Close() error {
+	return nil
+}
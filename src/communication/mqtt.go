@@ -1,172 +1,203 @@
 package communication
 
 import (
-	"bytes"
-	"encoding/binary"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"golang-server/config"
-	"golang-server/log"
-	"golang-server/types"
+	"os"
 	"strconv"
-	"time"
+	"strings"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-func InitMqtt() mqtt.Client {
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", config.Broker, config.Port))
-	opts.SetDefaultPublishHandler(messagePubHandler)
-	opts.OnConnect = connectHandler
-	opts.OnConnectionLost = connectLostHandler
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.GGeneralLogger.Println("Failed to connect to mqtt broker. Error: ", token.Error())
-		panic(token.Error())
-	}
-	return client
+// mqttTransport publishes commands on v2/server/NRF_x/cmd and subscribes to v2/robot/NRF_x/<stream>.
+// Reliable payloads are published with QoS 1, and retained payloads are also retained by the broker, so
+// robots that reconnect receive them.
+type mqttTransport struct {
+	client mqtt.Client
 }
 
-type coordinate struct {
-	x int16
-	y int16
+// mqttSubscribeTopics are the topics of each stream. Telemetry is only subscribed from NRF_5, like the
+// robot code in the golang-server branch sends it.
+var mqttSubscribeTopics = map[Stream]string{
+	StreamAdv:    "v2/robot/NRF_5/adv",
+	StreamCamera: "v2/robot/+/cam",
 }
 
-type advMsgUnpacking struct {
-	id      uint8
-	x       int16
-	y       int16
-	theta   int16
-	accel_x float32
-	accel_y float32
-	// accel_z      float32
-	// gyro_x       float32
-	// gyro_y       float32
-	gyro_z       float32
-	ir           [4]coordinate
-	covMatrix    [25]float32 //not used currently, but needed to keep the byte size correct
-	valid        uint8
-	irTowerAngle uint8 //not used currently, but needed to keep the byte size correct
+// mqttSettings are the connection settings of the broker, from config and the environment.
+type mqttSettings struct {
+	scheme, host, path        string
+	port                      int
+	caFile, certFile, keyFile string
+	username, password        string
+	clientId                  string
+	cleanSession              bool
 }
 
-var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-	fmt.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
-	log.GGeneralLogger.Println("Received message from unsubscribed topic: ", msg.Topic(), " Message: ", msg.Payload())
+func mqttSettingsFromConfig() mqttSettings {
+	s := mqttSettings{
+		scheme:       config.BrokerScheme,
+		host:         config.Broker,
+		path:         config.BrokerPath,
+		port:         config.Port,
+		caFile:       config.MqttCAFile,
+		certFile:     config.MqttCertFile,
+		keyFile:      config.MqttKeyFile,
+		username:     config.MqttUsername,
+		password:     config.MqttPassword,
+		clientId:     config.MqttClientId,
+		cleanSession: config.MqttCleanSession,
+	}
+	if username, set := os.LookupEnv("MQTT_USERNAME"); set {
+		s.username = username
+	}
+	if password, set := os.LookupEnv("MQTT_PASSWORD"); set {
+		s.password = password
+	}
+	return s
 }
 
-var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	fmt.Println("Connected to mqtt broker")
-	log.GGeneralLogger.Println("Connected to mqtt broker")
+// brokerUrl is e.g. tcp://slam:1883 or wss://slam:8084/mqtt.
+func (s mqttSettings) brokerUrl() (string, error) {
+	switch s.scheme {
+	case "tcp", "ssl":
+		return fmt.Sprintf("%s://%s:%d", s.scheme, s.host, s.port), nil
+	case "ws", "wss":
+		return fmt.Sprintf("%s://%s:%d%s", s.scheme, s.host, s.port, s.path), nil
+	}
+	return "", fmt.Errorf("unknown broker scheme %q, expected tcp, ssl, ws or wss", s.scheme)
 }
 
-var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	fmt.Printf("Lost connection to mqtt broker. Error: %v", err)
-	log.GGeneralLogger.Println("Lost connection to mqtt broker. Error: ", err)
+// tlsConfig verifies the broker with the CA bundle, or the system roots if there is none, and presents
+// the client certificate if there is one.
+func (s mqttSettings) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.caFile != "" {
+		pem, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", s.caFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if (s.certFile == "") != (s.keyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if s.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
 
-func ThreadMqttPublish(
-	client mqtt.Client,
-	chPublish <-chan [3]int,
-) {
-	prefixByte := []byte{2} //because the robot code expects a byte here
-	for msg := range chPublish {
-
-		buf := new(bytes.Buffer)
-		binary.Write(buf, binary.LittleEndian, prefixByte)
-		binary.Write(buf, binary.LittleEndian, int16(msg[1]))
-		binary.Write(buf, binary.LittleEndian, int16(msg[2]))
-
-		token := client.Publish("v2/server/NRF_"+strconv.Itoa(msg[0])+"/cmd", 0, false, buf.Bytes())
-		token.Wait()
-		time.Sleep(time.Second)
+func (s mqttSettings) clientOptions() (*mqtt.ClientOptions, error) {
+	url, err := s.brokerUrl()
+	if err != nil {
+		return nil, err
+	}
+	if !s.cleanSession && s.clientId == "" {
+		return nil, errors.New("a persistent session needs a client id")
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(url)
+	if s.scheme == "ssl" || s.scheme == "wss" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	} else if s.caFile != "" || s.certFile != "" {
+		logger.Warn("Certificates are ignored without TLS", "scheme", s.scheme)
+	}
+	opts.SetUsername(s.username)
+	opts.SetPassword(s.password)
+	opts.SetClientID(s.clientId)
+	opts.SetCleanSession(s.cleanSession)
+	return opts, nil
+}
 
-		//logging is done in the different functions that writes to chPublish
+func initMqttTransport() (*mqttTransport, error) {
+	settings := mqttSettingsFromConfig()
+	opts, err := settings.clientOptions()
+	if err != nil {
+		logger.Error("Invalid mqtt settings", "err", err)
+		return nil, err
+	}
+	opts.SetDefaultPublishHandler(messagePubHandler)
+	opts.OnConnect = connectHandler
+	opts.OnConnectionLost = connectLostHandler
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		logger.Error("Failed to connect to mqtt broker", "broker", opts.Servers[0].String(), "err", token.Error())
+		return nil, token.Error()
 	}
+	logger.Info("Connected", "transport", "mqtt", "broker", opts.Servers[0].String(), "client_id", settings.clientId, "user", settings.username, "clean_session", settings.cleanSession)
+	return &mqttTransport{client}, nil
 }
 
-var lastsize int = 0
+var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
+	logger.Warn("Received message from unsubscribed topic", "transport", "mqtt", "topic", msg.Topic(), "bytes", len(msg.Payload()))
+}
 
-func advMessageHandler(
-	chIncomingMsg chan<- types.AdvMsg,
-) mqtt.MessageHandler {
-	return func(client mqtt.Client, msg mqtt.Message) {
-		payload := msg.Payload()
-		reader := bytes.NewReader(payload)
+var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
+	logger.Info("Connected to mqtt broker")
+}
 
-		if len(payload) != lastsize {
-			println("Incoming payload is: ", len(payload))
-			lastsize = len(payload)
-		}
+var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
+	logger.Error("Lost connection to mqtt broker", "err", err)
+}
 
-		if len(payload) == 137 { //expected size, make sure this the same as the robot is sending
-			m := advMsgUnpacking{}
-			binary.Read(reader, binary.LittleEndian, &m.id)
-			binary.Read(reader, binary.LittleEndian, &m.x)
-			binary.Read(reader, binary.LittleEndian, &m.y)
-			binary.Read(reader, binary.LittleEndian, &m.theta)
-			binary.Read(reader, binary.LittleEndian, &m.accel_x)
-			binary.Read(reader, binary.LittleEndian, &m.accel_y)
-			// binary.Read(reader, binary.LittleEndian, &m.accel_z)
-			// binary.Read(reader, binary.LittleEndian, &m.gyro_x)
-			// binary.Read(reader, binary.LittleEndian, &m.gyro_y)
-			binary.Read(reader, binary.LittleEndian, &m.gyro_z)
-			binary.Read(reader, binary.LittleEndian, &m.ir[0].x)
-			binary.Read(reader, binary.LittleEndian, &m.ir[0].y)
-			binary.Read(reader, binary.LittleEndian, &m.ir[1].x)
-			binary.Read(reader, binary.LittleEndian, &m.ir[1].y)
-			binary.Read(reader, binary.LittleEndian, &m.ir[2].x)
-			binary.Read(reader, binary.LittleEndian, &m.ir[2].y)
-			binary.Read(reader, binary.LittleEndian, &m.ir[3].x)
-			binary.Read(reader, binary.LittleEndian, &m.ir[3].y)
-			binary.Read(reader, binary.LittleEndian, &m.covMatrix)
-			binary.Read(reader, binary.LittleEndian, &m.valid)
-			binary.Read(reader, binary.LittleEndian, &m.irTowerAngle)
-
-			// Debug: Print the raw payload in hex format
-			// fmt.Print("Payload hex: ")
-			// for i, b := range payload {
-			// 	fmt.Printf("%02X ", b)
-			// 	if (i+1)%16 == 0 {
-			// 		fmt.Println()
-			// 	}
-			// }
-			// fmt.Println()
-
-			newMsg := types.AdvMsg{
-				Id:           int(m.id),
-				X:            int(m.x),
-				Y:            int(m.y),
-				Theta:        int(m.theta),
-				Ir1x:         int(m.ir[0].x),
-				Ir1y:         int(m.ir[0].y),
-				Ir2x:         int(m.ir[1].x),
-				Ir2y:         int(m.ir[1].y),
-				Ir3x:         int(m.ir[2].x),
-				Ir3y:         int(m.ir[2].y),
-				Ir4x:         int(m.ir[3].x),
-				Ir4y:         int(m.ir[3].y),
-				IrTowerAngle: int(m.irTowerAngle),
-			}
-
-			chIncomingMsg <- newMsg
-
-			// One robots sends about 30 messages per second. Uncomment the following lines to see the messages.
-
-			//fmt.Printf("Id: %d, x: %d, y: %d, theta: %d, ir1x: %d, ir1y: %d, ir2x: %d, ir2y: %d, ir3x: %d, ir3y: %d, ir4x: %d, ir4y: %d\n", newMsg.id, newMsg.x, newMsg.y, newMsg.theta, newMsg.ir1x, newMsg.ir1y, newMsg.ir2x, newMsg.ir2y, newMsg.ir3x, newMsg.ir3y, newMsg.ir4x, newMsg.ir4y)
-			//log.GGeneralLogger.Printf("Id: %d, x: %d, y: %d, theta: %d, ir1x: %d, ir1y: %d, ir2x: %d, ir2y: %d, ir3x: %d, ir3y: %d, ir4x: %d, ir4y: %d\n", newMsg.id, newMsg.x, newMsg.y, newMsg.theta, newMsg.ir1x, newMsg.ir1y, newMsg.ir2x, newMsg.ir2y, newMsg.ir3x, newMsg.ir3y, newMsg.ir4x, newMsg.ir4y)
-			//fmt.Printf("Id: %d, x: %d, y: %d, theta: %d\n", newMsg.id, newMsg.x, newMsg.y, newMsg.theta)
-			//log.GGeneralLogger.Printf("Id: %d, x: %d, y: %d, theta: %d\n", newMsg.id, newMsg.x, newMsg.y, newMsg.theta)
+func (t *mqttTransport) Subscribe(stream Stream, handler func(id int, payload []byte)) error {
+	topic, exist := mqttSubscribeTopics[stream]
+	if !exist {
+		return fmt.Errorf("no topic to subscribe for stream %q", stream)
+	}
+	token := t.client.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+		id, ok := topicRobotId(msg.Topic())
+		if !ok {
+			logger.Warn("Message on topic without robot id dropped", "topic", msg.Topic())
+			return
 		}
+		handler(id, msg.Payload())
+	})
+	token.Wait()
+	if token.Error() != nil {
+		return token.Error()
 	}
+	logger.Info("Subscribed", "transport", "mqtt", "topic", topic)
+	return nil
 }
 
-func Subscribe(
-	client mqtt.Client,
-	chIncomingMsg chan<- types.AdvMsg,
-) {
-	topic := "v2/robot/NRF_5/adv"
-	token := client.Subscribe(topic, 1, advMessageHandler(chIncomingMsg))
+func (t *mqttTransport) Publish(stream Stream, id int, payload []byte, delivery Delivery) error {
+	qos := byte(0)
+	if delivery >= Reliable {
+		qos = 1
+	}
+	token := t.client.Publish("v2/server/NRF_"+strconv.Itoa(id)+"/"+string(stream), qos, delivery == Retained, payload)
 	token.Wait()
-	fmt.Printf("Subscribed to topic: %s", topic)
-	log.GGeneralLogger.Println("Subscribed to topic: ", topic)
+	return token.Error()
+}
+
+func (t *mqttTransport) Close() error {
+	t.client.Disconnect(250)
+	return nil
+}
+
+// topicRobotId returns the robot id of a topic like v2/robot/NRF_5/cam.
+func topicRobotId(topic string) (int, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || !strings.HasPrefix(parts[2], "NRF_") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(parts[2], "NRF_"))
+	return id, err == nil
 }
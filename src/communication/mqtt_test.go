@@ -0,0 +1,170 @@
+package communication
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type publishedMsg struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+// fakeClient records published messages. Only Publish is implemented.
+type fakeClient struct {
+	mqtt.Client
+	mu        sync.Mutex
+	published []publishedMsg
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, publishedMsg{topic, qos, retained, payload.([]byte)})
+	return &doneToken{}
+}
+
+func (c *fakeClient) messages() []publishedMsg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]publishedMsg{}, c.published...)
+}
+
+type doneToken struct{}
+
+func (t *doneToken) Wait() bool                     { return true }
+func (t *doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t *doneToken) Done() <-chan struct{}          { ch := make(chan struct{}); close(ch); return ch }
+func (t *doneToken) Error() error                   { return nil }
+
+func TestMqttPublishReliable(t *testing.T) {
+	client := &fakeClient{}
+	transport := &mqttTransport{client}
+	transport.Publish(StreamCommand, 5, []byte{2, 0, 0, 0, 0}, BestEffort)
+	transport.Publish(StreamCommand, 5, []byte{4, 0, 0, 0, 0}, Retained)
+	transport.Publish(StreamCommand, 5, []byte{5, 0, 0, 0, 0}, Reliable)
+
+	published := client.messages()
+	if len(published) != 3 || published[0].qos != 0 || published[0].retained {
+		t.Fatalf("Expected a goal with QoS 0, not retained. Got: %+v", published)
+	}
+	if stop := published[1]; stop.qos != 1 || !stop.retained || stop.topic != "v2/server/NRF_5/cmd" {
+		t.Errorf("The stop was not published retained with QoS 1. Got: %+v", stop)
+	}
+	if wait := published[2]; wait.qos != 1 || wait.retained {
+		t.Errorf("The traffic wait was not published with QoS 1, not retained. Got: %+v", wait)
+	}
+}
+
+func TestTopicRobotId(t *testing.T) {
+	if id, ok := topicRobotId("v2/robot/NRF_12/cam"); !ok || id != 12 {
+		t.Errorf("Got robot %d, %v from v2/robot/NRF_12/cam", id, ok)
+	}
+	if _, ok := topicRobotId("v2/robot/cam"); ok {
+		t.Error("Robot id found in v2/robot/cam")
+	}
+}
+
+func TestMqttBrokerUrl(t *testing.T) {
+	tests := []struct {
+		settings mqttSettings
+		url      string
+	}{
+		{mqttSettings{scheme: "tcp", host: "slam", port: 1883}, "tcp://slam:1883"},
+		{mqttSettings{scheme: "ssl", host: "slam", port: 8883, path: "/mqtt"}, "ssl://slam:8883"},
+		{mqttSettings{scheme: "wss", host: "slam", port: 8084, path: "/mqtt"}, "wss://slam:8084/mqtt"},
+	}
+	for _, test := range tests {
+		if url, err := test.settings.brokerUrl(); err != nil || url != test.url {
+			t.Errorf("Expected %s, got %s, %v", test.url, url, err)
+		}
+	}
+	if _, err := (mqttSettings{scheme: "mqtts"}).brokerUrl(); err == nil {
+		t.Error("Unknown scheme accepted")
+	}
+}
+
+func TestMqttClientOptions(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := writeCertificates(t, dir)
+	settings := mqttSettings{scheme: "ssl", host: "slam", port: 8883, caFile: caFile, certFile: certFile, keyFile: keyFile,
+		username: "server", password: "secret", clientId: "golang-server", cleanSession: false}
+
+	opts, err := settings.clientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.TLSConfig == nil || opts.TLSConfig.RootCAs == nil || len(opts.TLSConfig.Certificates) != 1 {
+		t.Errorf("Expected the CA bundle and the client certificate in the TLS config. Got: %+v", opts.TLSConfig)
+	}
+	if opts.Username != "server" || opts.Password != "secret" || opts.ClientID != "golang-server" || opts.CleanSession {
+		t.Errorf("Expected the credentials and a persistent session. Got: %q %q %q %v", opts.Username, opts.Password, opts.ClientID, opts.CleanSession)
+	}
+
+	invalid := map[string]mqttSettings{
+		"persistent session without client id": {scheme: "tcp", cleanSession: false},
+		"certificate without key":              {scheme: "ssl", certFile: certFile, cleanSession: true},
+		"missing CA bundle":                    {scheme: "wss", caFile: filepath.Join(dir, "missing.pem"), cleanSession: true},
+		"CA bundle without certificates":       {scheme: "ssl", caFile: keyFile, cleanSession: true},
+	}
+	for name, settings := range invalid {
+		if _, err := settings.clientOptions(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+// writeCertificates writes a self-signed CA, and a client certificate and key signed by it.
+func writeCertificates(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "golang-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(clientKey)
+
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	return write("ca.pem", "CERTIFICATE", caDer), write("client.pem", "CERTIFICATE", clientDer), write("client.key", "EC PRIVATE KEY", keyDer)
+}
@@ -0,0 +1,82 @@
+package communication
+
+import (
+	"golang-server/metrics"
+	"golang-server/types"
+	"time"
+)
+
+// newTimer is replaced by the tests, which end the wait between goals themselves.
+var newTimer = time.After
+
+// ThreadPublish publishes commands to the robots. Stop, pause and resume on chPublishControl are
+// published before anything queued on chPublish, and retained (with MQTT: QoS 1 and retained) so robots
+// that reconnect also receive them. Traffic waits are only reliable, so they do not outlive the server. Goals and velocities are dropped while the robot they are for is
+// stopped or paused.
+func ThreadPublish(
+	transport Transport,
+	chPublish <-chan types.PublishMsg,
+	chPublishControl <-chan types.PublishMsg,
+) {
+	halted := map[int]bool{} //robots are also paused one by one by traffic management
+	handleControl := func(msg types.PublishMsg) {
+		halted[msg.Id] = msg.Kind != types.PublishResume
+		if msg.Traffic {
+			publishCommand(transport, msg, Reliable)
+		} else {
+			publishCommand(transport, msg, Retained)
+		}
+	}
+	var targetGap <-chan time.Time //goals are published at most once per second
+	for {
+		var msg types.PublishMsg
+		select {
+		case control := <-chPublishControl:
+			handleControl(control)
+			continue
+		default:
+		}
+		select {
+		case control := <-chPublishControl:
+			handleControl(control)
+			continue
+		case msg = <-chPublish:
+		}
+
+		//velocity commands are repeated quickly while teleoperating, and must not be delayed
+		if msg.Kind == types.PublishTarget {
+			if targetGap != nil {
+				select {
+				case <-targetGap:
+				case control := <-chPublishControl:
+					handleControl(control)
+				}
+			}
+			targetGap = newTimer(time.Second)
+		}
+		if halted[msg.Id] {
+			logger.Warn("Robot is halted, command dropped", "robot", msg.Id)
+			metrics.CommandsDropped.Inc("halted")
+			continue
+		}
+		publishCommand(transport, msg, BestEffort)
+
+		//logging is done in the different functions that writes to chPublish
+	}
+}
+
+var publishKindNames = map[uint8]string{
+	types.PublishTarget:   "target",
+	types.PublishVelocity: "velocity",
+	types.PublishStop:     "stop",
+	types.PublishPause:    "pause",
+	types.PublishResume:   "resume",
+}
+
+func publishCommand(transport Transport, msg types.PublishMsg, delivery Delivery) {
+	start := time.Now()
+	defer func() { metrics.CommandPublishSeconds.Observe(time.Since(start).Seconds(), publishKindNames[msg.Kind]) }()
+	if err := transport.Publish(StreamCommand, msg.Id, EncodeCommand(msg), delivery); err != nil {
+		logger.Error("Failed to publish command", "robot", msg.Id, "err", err)
+	}
+}
@@ -0,0 +1,63 @@
+package communication
+
+import (
+	"golang-server/types"
+	"testing"
+	"time"
+)
+
+// publishedTo returns a transport that sends everything published on the returned channel.
+func publishedTo() (*MemoryTransport, chan MemoryMsg) {
+	transport := NewMemoryTransport()
+	published := make(chan MemoryMsg, 16)
+	transport.OnPublish = func(msg MemoryMsg) { published <- msg }
+	return transport, published
+}
+
+func TestPublishStopBeforeQueuedGoals(t *testing.T) {
+	transport, published := publishedTo()
+	chPublish := make(chan types.PublishMsg, 3)
+	chPublishControl := make(chan types.PublishMsg, 3)
+	//the wait between goals only ends when the test says so
+	gap := make(chan time.Time)
+	realTimer := newTimer
+	newTimer = func(time.Duration) <-chan time.Time { return gap }
+	defer func() { newTimer = realTimer }()
+
+	//two goals are queued, the second has to wait after the first
+	chPublish <- types.PublishMsg{Kind: types.PublishTarget, Id: 5, Values: [2]int{100, 0}}
+	chPublish <- types.PublishMsg{Kind: types.PublishTarget, Id: 5, Values: [2]int{200, 0}}
+	go ThreadPublish(transport, chPublish, chPublishControl)
+	if first := <-published; first.Payload[0] != types.PublishTarget {
+		t.Fatalf("Expected the first goal to be published, got %+v", first)
+	}
+	chPublishControl <- types.PublishMsg{Kind: types.PublishStop, Id: 5}
+
+	stop := <-published
+	if stop.Payload[0] != types.PublishStop || stop.Delivery != Retained || stop.Stream != StreamCommand || stop.Id != 5 {
+		t.Errorf("The stop was not published reliably. Got: %+v", stop)
+	}
+	//published after the queued goal has been handled
+	chPublish <- types.PublishMsg{Kind: types.PublishVelocity, Id: 6}
+	if next := <-published; next.Id != 6 {
+		t.Errorf("Expected the queued goal to be dropped, got %+v", next)
+	}
+}
+
+func TestPauseOneRobot(t *testing.T) {
+	transport, published := publishedTo()
+	chPublish := make(chan types.PublishMsg, 3)
+	chPublishControl := make(chan types.PublishMsg, 3)
+
+	chPublishControl <- types.PublishMsg{Kind: types.PublishPause, Id: 1}
+	go ThreadPublish(transport, chPublish, chPublishControl)
+	chPublish <- types.PublishMsg{Kind: types.PublishVelocity, Id: 1, Values: [2]int{100, 0}}
+	chPublish <- types.PublishMsg{Kind: types.PublishVelocity, Id: 2, Values: [2]int{100, 0}}
+
+	if pause := <-published; pause.Payload[0] != types.PublishPause || pause.Id != 1 {
+		t.Fatalf("Expected the pause of robot 1 first. Got: %+v", pause)
+	}
+	if next := <-published; next.Id != 2 {
+		t.Fatalf("Expected the command to robot 1 to be dropped. Got: %+v", next)
+	}
+}
@@ -0,0 +1,178 @@
+package communication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang-server/config"
+	"io"
+	"os"
+	"sync"
+)
+
+// serialTransport talks to the robots through a USB-serial nRF dongle, which relays the frames over the
+// radio. Every frame is followed by its CRC-16/CCITT-FALSE, little-endian, and the two are COBS encoded
+// and ended with a 0 byte, so the reader finds the start of the next frame after a corrupt one.
+//
+// The device is put into raw mode at config.SerialBaud, see setRawMode. The radio link may lose frames, so
+// reliable payloads are written config.SerialReliableRepeats times.
+type serialTransport struct {
+	subscribers
+	port    io.ReadWriteCloser
+	writeMu sync.Mutex
+}
+
+func initSerialTransport(device string) (*serialTransport, error) {
+	port, err := os.OpenFile(device, os.O_RDWR|serialOpenFlags, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRawMode(port, config.SerialBaud); err != nil {
+		port.Close()
+		return nil, fmt.Errorf("failed to set up %s: %w", device, err)
+	}
+	logger.Info("Opened serial device", "transport", "serial", "device", device, "baud", config.SerialBaud)
+	return newSerialTransport(port), nil
+}
+
+func newSerialTransport(port io.ReadWriteCloser) *serialTransport {
+	t := &serialTransport{port: port}
+	go t.read()
+	return t
+}
+
+func (t *serialTransport) read() {
+	reader := bufio.NewReader(t.port)
+	for {
+		packet, err := reader.ReadBytes(0)
+		if err != nil {
+			logger.Info("Serial transport stopped", "err", err)
+			return
+		}
+		if len(packet) == 1 {
+			continue //empty packet, e.g. a delimiter sent to resynchronize
+		}
+		frame, err := unpackSerial(packet[:len(packet)-1])
+		if err != nil {
+			logger.Warn("Invalid serial frame", "err", err)
+			continue
+		}
+		stream, id, payload, err := decodeFrame(frame)
+		if err != nil {
+			logger.Warn("Invalid serial frame", "err", err)
+			continue
+		}
+		if !t.dispatch(stream, id, payload) {
+			logger.Debug("Serial frame without subscriber dropped", "stream", stream, "robot", id)
+		}
+	}
+}
+
+func (t *serialTransport) Subscribe(stream Stream, handler func(id int, payload []byte)) error {
+	t.add(stream, handler)
+	logger.Info("Subscribed", "transport", "serial", "stream", stream)
+	return nil
+}
+
+func (t *serialTransport) Publish(stream Stream, id int, payload []byte, delivery Delivery) error {
+	frame, err := encodeFrame(stream, id, payload)
+	if err != nil {
+		return err
+	}
+	repeats := 1
+	if delivery >= Reliable {
+		repeats = config.SerialReliableRepeats
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	packet := packSerial(frame)
+	for i := 0; i < repeats; i++ {
+		if _, err := t.port.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *serialTransport) Close() error {
+	return t.port.Close()
+}
+
+// packSerial adds the CRC, COBS encodes and adds the 0 delimiter.
+func packSerial(frame []byte) []byte {
+	withCrc := binary.LittleEndian.AppendUint16(append([]byte{}, frame...), crc16(frame))
+	return append(cobsEncode(withCrc), 0)
+}
+
+// unpackSerial is the inverse of packSerial, without the delimiter.
+func unpackSerial(packet []byte) ([]byte, error) {
+	withCrc, err := cobsDecode(packet)
+	if err != nil {
+		return nil, err
+	}
+	if len(withCrc) < 2 {
+		return nil, errors.New("frame is too short for the CRC")
+	}
+	frame := withCrc[:len(withCrc)-2]
+	if expected, actual := binary.LittleEndian.Uint16(withCrc[len(frame):]), crc16(frame); expected != actual {
+		return nil, fmt.Errorf("CRC is %04x, expected %04x", actual, expected)
+	}
+	return frame, nil
+}
+
+// crc16 is CRC-16/CCITT-FALSE: polynomial 0x1021, initial value 0xffff, not reflected. It is
+// crc16_compute() with a NULL initial value in the nRF5 SDK.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// cobsEncode replaces every 0 byte by the distance to the next one (Consistent Overhead Byte Stuffing),
+// so the encoded data has no 0 bytes.
+func cobsEncode(data []byte) []byte {
+	encoded := make([]byte, 1, len(data)+len(data)/254+2)
+	codeIndex, code := 0, byte(1)
+	for _, b := range data {
+		if b != 0 {
+			encoded = append(encoded, b)
+			code++
+		}
+		if b == 0 || code == 0xff {
+			encoded[codeIndex] = code
+			codeIndex, code = len(encoded), 1
+			encoded = append(encoded, 0)
+		}
+	}
+	encoded[codeIndex] = code
+	return encoded
+}
+
+func cobsDecode(encoded []byte) ([]byte, error) {
+	data := make([]byte, 0, len(encoded))
+	for i := 0; i < len(encoded); {
+		code := int(encoded[i])
+		if code == 0 {
+			return nil, errors.New("COBS data contains a 0 byte")
+		}
+		if i+code > len(encoded) {
+			return nil, errors.New("COBS block runs past the end")
+		}
+		data = append(data, encoded[i+1:i+code]...)
+		i += code
+		if code < 0xff && i < len(encoded) {
+			data = append(data, 0)
+		}
+	}
+	return data, nil
+}
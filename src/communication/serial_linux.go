@@ -0,0 +1,38 @@
+package communication
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialOpenFlags keeps the device from becoming the controlling terminal of the server.
+const serialOpenFlags = unix.O_NOCTTY
+
+var baudRates = map[int]uint32{
+	9600: unix.B9600, 19200: unix.B19200, 38400: unix.B38400, 57600: unix.B57600, 115200: unix.B115200,
+	230400: unix.B230400, 460800: unix.B460800, 921600: unix.B921600, 1000000: unix.B1000000,
+}
+
+// setRawMode turns off everything the tty does to the bytes, like cfmakeraw(3), so the binary frames are
+// passed through as they are and a read returns as soon as a byte has arrived.
+func setRawMode(port *os.File, baud int) error {
+	speed, exist := baudRates[baud]
+	if !exist {
+		return fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	fd := int(port.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB | unix.CBAUD
+	termios.Cflag |= unix.CS8 | unix.CLOCAL | unix.CREAD | speed
+	termios.Ispeed, termios.Ospeed = speed, speed
+	termios.Cc[unix.VMIN], termios.Cc[unix.VTIME] = 1, 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+}
@@ -0,0 +1,53 @@
+package communication
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSerialRawMode(t *testing.T) {
+	//a pseudo terminal starts in cooked mode like a serial device
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("No pseudo terminals:", err)
+	}
+	defer master.Close()
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|serialOpenFlags, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if err := setRawMode(port, 115200); err != nil {
+		t.Fatal(err)
+	}
+	termios, err := unix.IoctlGetTermios(int(port.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if termios.Lflag&(unix.ICANON|unix.ECHO) != 0 || termios.Iflag&unix.ICRNL != 0 || termios.Oflag&unix.OPOST != 0 {
+		t.Errorf("Expected raw mode, got lflag %x, iflag %x, oflag %x", termios.Lflag, termios.Iflag, termios.Oflag)
+	}
+	//a frame without a newline can be read right away
+	master.Write([]byte{'\r', 0x03, 0x00})
+	port.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 3)
+	if _, err := port.Read(buf); err != nil || buf[0] != '\r' {
+		t.Errorf("Read %v, %v", buf, err)
+	}
+
+	if err := setRawMode(port, 12345); err == nil {
+		t.Error("Expected an error for an unsupported baud rate")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package communication
+
+import "os"
+
+const serialOpenFlags = 0
+
+// setRawMode is only implemented on Linux. Elsewhere the device must be set up before the server starts.
+func setRawMode(port *os.File, baud int) error {
+	logger.Warn("Serial raw mode is only set on Linux, set up the device with stty", "transport", "serial", "baud", baud)
+	return nil
+}
@@ -0,0 +1,40 @@
+package communication
+
+import (
+	"golang-server/metrics"
+	"golang-server/types"
+	"strconv"
+)
+
+var lastsize int = 0
+
+func advMessageHandler(
+	chIncomingMsg chan<- types.AdvMsg,
+) func(id int, payload []byte) {
+	return func(id int, payload []byte) {
+
+		if len(payload) != lastsize {
+			logger.Debug("Telemetry payload size changed", "robot", id, "bytes", len(payload))
+			lastsize = len(payload)
+		}
+
+		newMsg, err := DecodeAdvMsg(payload) //make sure advMsgSize is the same as the robot is sending
+		if err == nil {
+			metrics.RobotMessages.Inc(strconv.Itoa(newMsg.Id), "adv")
+			chIncomingMsg <- newMsg
+		} else {
+			logger.Warn("Invalid telemetry message", "robot", id, "err", err)
+			metrics.DecodeFailures.Inc("adv", "size")
+		}
+	}
+}
+
+// Subscribe decodes the telemetry from the robots and sends it to chIncomingMsg.
+func Subscribe(
+	transport Transport,
+	chIncomingMsg chan<- types.AdvMsg,
+) {
+	if err := transport.Subscribe(StreamAdv, advMessageHandler(chIncomingMsg)); err != nil {
+		logger.Error("Failed to subscribe", "stream", StreamAdv, "err", err)
+	}
+}
@@ -1,82 +1,80 @@
 package communication
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
 	"golang-server/config"
 	"golang-server/log"
+	"golang-server/metrics"
 	"golang-server/types"
+	"strconv"
+	"time"
+)
+
+var cameraLogger = log.Component("camera")
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+const (
+	frameReorderLimit = 100             //frames, a frame further behind the latest means the robot restarted its counter
+	frameResetGap     = 2 * time.Second //after this long without frames from a robot, any frame id is accepted
 )
 
-// SubscribeCamera subscribes to camera topic and dispatches messages to chCamera
-// only if config.UseNiclaVision is enabled. The camera payload is expected to
-// contain three little-endian int16 values: x_start_mm, x_width_mm, distance_mm.
-func SubscribeCamera(client mqtt.Client, chCamera chan<- types.CameraMsg) {
+// SubscribeCamera subscribes to the camera topics of all robots and dispatches frames to chCamera
+// only if config.UseNiclaVision is enabled. Frames that are older than the latest frame received
+// from the same robot are dropped, see frameFilter.
+func SubscribeCamera(transport Transport, chCamera chan<- types.CameraMsg) {
 	if !config.UseNiclaVision {
-		fmt.Println("\nNicla vision disabled via config.UseNiclaVision; camera subscription skipped")
-		log.GGeneralLogger.Println("Nicla vision disabled via config.UseNiclaVision; camera subscription skipped")
+		cameraLogger.Info("Nicla vision disabled via config.UseNiclaVision; camera subscription skipped")
 		return
 	}
 
-	handler := func(client mqtt.Client, msg mqtt.Message) {
-		payload := msg.Payload()
-		topic := string(msg.Topic())
-		log.GGeneralLogger.Printf("Camera message received on topic %s, %d bytes", topic, len(payload))
-		reader := bytes.NewReader(payload)
-
-		// Expect 1 byte identifier + 3x int16 = 7 bytes total
-		if len(payload) != 7 {
-			log.GGeneralLogger.Printf("Camera payload length not 7 bytes: %d bytes", len(payload))
+	frames := frameFilter{last: make(map[int]int), received: make(map[int]time.Time)}
+	handler := func(id int, payload []byte) {
+		cam, err := DecodeCameraMsg(payload)
+		if err != nil {
+			cameraLogger.Warn("Invalid camera message", "robot", id, "err", err)
+			metrics.DecodeFailures.Inc("cam", "invalid")
 			return
 		}
-
-		// Read payload
-		var identifier uint8
-		var start int16
-		var width int16
-		var distance int16
-
-		if err := binary.Read(reader, binary.LittleEndian, &identifier); err != nil {
-			log.GGeneralLogger.Printf("Failed to read camera payload identifier: %v", err)
+		if id != cam.Id {
+			cameraLogger.Warn("Camera message with wrong robot id dropped", "robot", id, "payload_robot", cam.Id)
+			metrics.DecodeFailures.Inc("cam", "id_mismatch")
 			return
 		}
-		if err := binary.Read(reader, binary.LittleEndian, &start); err != nil {
-			log.GGeneralLogger.Printf("Failed to read camera payload start: %v", err)
-			return
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &width); err != nil {
-			log.GGeneralLogger.Printf("Failed to read camera payload width: %v", err)
-			return
-		}
-		if err := binary.Read(reader, binary.LittleEndian, &distance); err != nil {
-			log.GGeneralLogger.Printf("Failed to read camera payload distance: %v", err)
+		if !frames.accept(cam.Id, cam.FrameId, time.Now()) {
+			cameraLogger.Debug("Stale camera frame dropped", "robot", cam.Id, "frame", cam.FrameId, "latest", frames.last[cam.Id])
+			metrics.DecodeFailures.Inc("cam", "stale")
 			return
 		}
 
-		// Log a single line with the parsed camera values including id
-		log.GGeneralLogger.Printf("Camera message received: id=%d start=%d width=%d distance=%d", int(identifier), int(start), int(width), int(distance))
+		cameraLogger.Debug("Camera frame received", "robot", cam.Id, "frame", cam.FrameId,
+			"time", cam.TimestampMs, "angle", cam.IrTowerAngle, "segments", cam.Segments)
+		metrics.RobotMessages.Inc(strconv.Itoa(cam.Id), "cam")
+		chCamera <- cam
+	}
 
-		// Ignore empty / invalid camera measurements (no detection)
-		if start == 0 && width == 0 && distance == 0 {
-			return
-		}
+	if err := transport.Subscribe(StreamCamera, handler); err != nil {
+		cameraLogger.Error("Failed to subscribe", "stream", StreamCamera, "err", err)
+	}
+}
+
+// isNewerFrame compares two frame ids, allowing the 16 bit counter to wrap around.
+func isNewerFrame(frameId, last int) bool {
+	return frameId != last && uint16(frameId-last) < 1<<15
+}
+
+// frameFilter keeps the latest frame id of every robot. A robot that restarts counts from 0 again, so a
+// large step back, or a frame after a gap in time, is taken as a restart instead of a stale frame.
+type frameFilter struct {
+	last     map[int]int
+	received map[int]time.Time
+}
 
-		// Build and send a dedicated CameraMsg
-		cam := types.CameraMsg{
-			Id:         int(identifier),
-			StartMM:    int(start),
-			WidthMM:    int(width),
-			DistanceMM: int(distance),
+// accept returns true if the frame is newer than the latest frame of the robot, and makes it the latest.
+func (f *frameFilter) accept(id, frameId int, now time.Time) bool {
+	if last, exist := f.last[id]; exist && !isNewerFrame(frameId, last) {
+		if uint16(last-frameId) < frameReorderLimit && now.Sub(f.received[id]) < frameResetGap {
+			return false
 		}
-		chCamera <- cam
+		cameraLogger.Info("Camera frame counter restarted", "robot", id, "frame", frameId, "latest", last)
 	}
-
-	topic := "v2/robot/cam"
-	token := client.Subscribe(topic, 1, handler)
-	token.Wait()
-	fmt.Printf("\nSubscribed to camera topic: %s\n", topic)
-	log.GGeneralLogger.Println("Subscribed to camera topic: ", topic)
+	f.last[id], f.received[id] = frameId, now
+	return true
 }
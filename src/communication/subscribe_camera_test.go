@@ -0,0 +1,88 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang-server/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func cameraPayload(id uint8, frameId uint16, angle uint8, segments ...[3]int16) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, id)
+	binary.Write(buf, binary.LittleEndian, frameId)
+	binary.Write(buf, binary.LittleEndian, uint32(1234))
+	binary.Write(buf, binary.LittleEndian, angle)
+	binary.Write(buf, binary.LittleEndian, uint8(len(segments)))
+	binary.Write(buf, binary.LittleEndian, segments)
+	return buf.Bytes()
+}
+
+func TestDecodeCameraMsg(t *testing.T) {
+	cam, err := DecodeCameraMsg(cameraPayload(5, 7, 90, [3]int16{-100, 200, 400}, [3]int16{150, 50, 600}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.CameraMsg{Id: 5, FrameId: 7, TimestampMs: 1234, IrTowerAngle: 90, Segments: []types.CameraSegment{
+		{StartMM: -100, WidthMM: 200, DistanceMM: 400},
+		{StartMM: 150, WidthMM: 50, DistanceMM: 600},
+	}}
+	if !reflect.DeepEqual(cam, expected) {
+		t.Errorf("decoded %+v, expected %+v", cam, expected)
+	}
+
+	cam, err = DecodeCameraMsg(cameraPayload(5, 8, 90))
+	if err != nil || len(cam.Segments) != 0 {
+		t.Errorf("empty frame decoded to %+v, %v", cam, err)
+	}
+
+	payload := cameraPayload(5, 9, 90, [3]int16{0, 400, 400})
+	if _, err := DecodeCameraMsg(payload[:len(payload)-1]); err == nil {
+		t.Error("truncated payload accepted")
+	}
+	if _, err := DecodeCameraMsg(cameraPayload(5, 9, 200)); err == nil {
+		t.Error("tower angle 200 accepted")
+	}
+}
+
+func TestIsNewerFrame(t *testing.T) {
+	cases := []struct {
+		frameId, last int
+		newer         bool
+	}{
+		{2, 1, true},
+		{1, 1, false},
+		{1, 2, false},
+		{0, 65535, true},
+		{65535, 0, false},
+	}
+	for _, c := range cases {
+		if isNewerFrame(c.frameId, c.last) != c.newer {
+			t.Errorf("isNewerFrame(%d, %d) != %v", c.frameId, c.last, c.newer)
+		}
+	}
+}
+
+func TestFrameFilterRestart(t *testing.T) {
+	frames := frameFilter{last: make(map[int]int), received: make(map[int]time.Time)}
+	start := time.Unix(0, 0)
+	for i, c := range []struct {
+		frameId  int
+		after    time.Duration
+		accepted bool
+	}{
+		{5000, 0, true},
+		{5001, 30 * time.Millisecond, true},
+		{4990, 60 * time.Millisecond, false}, //reordered
+		{0, 90 * time.Millisecond, true},     //the robot restarted
+		{1, 120 * time.Millisecond, true},
+		{0, 150 * time.Millisecond, false},              //reordered
+		{0, 150*time.Millisecond + frameResetGap, true}, //restarted again after a gap
+	} {
+		if accepted := frames.accept(3, c.frameId, start.Add(c.after)); accepted != c.accepted {
+			t.Errorf("Frame %d (%d): expected accepted %v, got %v", i, c.frameId, c.accepted, accepted)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package communication
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/log"
+	"sync"
+)
+
+var logger = log.Component("communication")
+
+// Stream is a kind of message between the server and the robots, named like the last part of the MQTT
+// topics, e.g. v2/robot/NRF_5/adv.
+type Stream string
+
+const (
+	StreamAdv     Stream = "adv" //telemetry, robot to server, see DecodeAdvMsg
+	StreamCamera  Stream = "cam" //camera frames, robot to server, see DecodeCameraMsg
+	StreamCommand Stream = "cmd" //commands, server to robot, see EncodeCommand
+)
+
+// Transport moves payloads between the server and the robots. Every transport carries the same payloads,
+// see codec.go, so the backend does not know which one is used.
+type Transport interface {
+	// Subscribe calls handler with every payload of the stream from any robot. id is the robot the payload
+	// came from. The handler is called from one goroutine per transport, and may block.
+	Subscribe(stream Stream, handler func(id int, payload []byte)) error
+	// Publish sends a payload to one robot, see Delivery.
+	Publish(stream Stream, id int, payload []byte, delivery Delivery) error
+	Close() error
+}
+
+// Delivery tells how hard a transport tries to get a payload to the robot.
+type Delivery int
+
+const (
+	BestEffort Delivery = iota //goals and velocities, a lost one is replaced by the next
+	Reliable                   //acknowledged or repeated if the transport can do that, like traffic waits
+	Retained                   //reliable, and kept for robots that reconnect, like the emergency stop
+)
+
+// InitTransport connects to the robots with the transport selected by config.Transport.
+func InitTransport() (Transport, error) {
+	switch config.Transport {
+	case "mqtt":
+		return initMqttTransport()
+	case "udp":
+		return initUdpTransport(config.UdpAddress)
+	case "serial":
+		return initSerialTransport(config.SerialDevice)
+	case "memory":
+		return NewMemoryTransport(), nil
+	}
+	return nil, fmt.Errorf("unknown transport %q", config.Transport)
+}
+
+// Frames are used by the transports without topics, UDP and serial:
+//
+//	uint8 stream (0 adv, 1 cam, 2 cmd), uint8 robot id, payload
+var streamCodes = []Stream{StreamAdv, StreamCamera, StreamCommand}
+
+const frameHeaderSize = 2
+
+func encodeFrame(stream Stream, id int, payload []byte) ([]byte, error) {
+	for code, s := range streamCodes {
+		if s == stream {
+			return append([]byte{byte(code), byte(id)}, payload...), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown stream %q", stream)
+}
+
+func decodeFrame(frame []byte) (Stream, int, []byte, error) {
+	if len(frame) < frameHeaderSize {
+		return "", 0, nil, fmt.Errorf("frame is %d bytes, header needs %d", len(frame), frameHeaderSize)
+	}
+	if int(frame[0]) >= len(streamCodes) {
+		return "", 0, nil, fmt.Errorf("unknown stream code %d", frame[0])
+	}
+	return streamCodes[frame[0]], int(frame[1]), frame[frameHeaderSize:], nil
+}
+
+// subscribers holds the handlers of the transports that dispatch the payloads themselves.
+type subscribers struct {
+	mu       sync.Mutex
+	handlers map[Stream][]func(id int, payload []byte)
+}
+
+func (s *subscribers) add(stream Stream, handler func(id int, payload []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = map[Stream][]func(int, []byte){}
+	}
+	s.handlers[stream] = append(s.handlers[stream], handler)
+}
+
+// dispatch calls the handlers of the stream, and returns false if there are none.
+func (s *subscribers) dispatch(stream Stream, id int, payload []byte) bool {
+	s.mu.Lock()
+	handlers := s.handlers[stream]
+	s.mu.Unlock()
+	for _, handler := range handlers {
+		handler(id, payload)
+	}
+	return len(handlers) > 0
+}
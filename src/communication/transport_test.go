@@ -0,0 +1,138 @@
+package communication
+
+import (
+	"bufio"
+	"bytes"
+	"golang-server/config"
+	"golang-server/types"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCobs(t *testing.T) {
+	long := make([]byte, 600)
+	for i := range long {
+		long[i] = byte(i%255 + 1)
+	}
+	for _, data := range [][]byte{{}, {0}, {0, 0}, {1, 2, 0, 3}, {0x11, 0x22, 0x00, 0x33}, long, append(long[:254:254], 0)} {
+		encoded := cobsEncode(data)
+		if bytes.IndexByte(encoded, 0) != -1 {
+			t.Errorf("Encoded %v contains a 0 byte: %v", data, encoded)
+		}
+		decoded, err := cobsDecode(encoded)
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("Decoded %v, %v, expected %v", decoded, err, data)
+		}
+	}
+	if encoded := cobsEncode([]byte{0x11, 0x22, 0x00, 0x33}); !bytes.Equal(encoded, []byte{0x03, 0x11, 0x22, 0x02, 0x33}) {
+		t.Errorf("Encoded %v", encoded)
+	}
+}
+
+func TestCrc16(t *testing.T) {
+	if crc := crc16([]byte("123456789")); crc != 0x29b1 {
+		t.Errorf("CRC is %04x, expected 29b1", crc)
+	}
+	packet := packSerial([]byte{1, 5, 9})
+	packet[1] ^= 0xff
+	if _, err := unpackSerial(packet[:len(packet)-1]); err == nil {
+		t.Error("Corrupt frame accepted")
+	}
+}
+
+func TestSerialTransport(t *testing.T) {
+	server, robot := net.Pipe()
+	transport := newSerialTransport(server)
+	defer transport.Close()
+	chReceive := make(chan types.AdvMsg)
+	Subscribe(transport, chReceive)
+
+	//noise before the first delimiter is dropped with the frame it belongs to
+	frame, _ := encodeFrame(StreamAdv, 7, EncodeAdvMsg(types.AdvMsg{Id: 7, X: 120}))
+	go robot.Write(append([]byte{0x42, 0x00}, packSerial(frame)...))
+	select {
+	case msg := <-chReceive:
+		if msg.Id != 7 || msg.X != 120 {
+			t.Errorf("Received %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No message received")
+	}
+
+	//reliable commands are repeated, since the radio may lose them
+	go transport.Publish(StreamCommand, 7, EncodeCommand(types.PublishMsg{Kind: types.PublishStop, Id: 7}), Retained)
+	reader := bufio.NewReader(robot)
+	for i := 0; i < config.SerialReliableRepeats; i++ {
+		packet, err := reader.ReadBytes(0)
+		if err != nil {
+			t.Fatalf("Packet %d: %v", i+1, err)
+		}
+		frame, err = unpackSerial(packet[:len(packet)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stream, id, payload, err := decodeFrame(frame); err != nil || stream != StreamCommand || id != 7 || payload[0] != types.PublishStop {
+			t.Errorf("Robot received %s, %d, %v, %v", stream, id, payload, err)
+		}
+	}
+}
+
+func TestUdpTransport(t *testing.T) {
+	transport, err := initUdpTransport("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	chCamera := make(chan types.CameraMsg)
+	SubscribeCamera(transport, chCamera)
+
+	robot, err := net.DialUDP("udp", nil, transport.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer robot.Close()
+	if err := transport.Publish(StreamCommand, 3, []byte{4, 0, 0, 0, 0}, Retained); err == nil {
+		t.Error("Published to a robot that has not sent anything")
+	}
+	frame, _ := encodeFrame(StreamCamera, 3, EncodeCameraMsg(types.CameraMsg{Id: 3, FrameId: 1, IrTowerAngle: 90}))
+	robot.Write(frame)
+	select {
+	case cam := <-chCamera:
+		if cam.Id != 3 || cam.FrameId != 1 {
+			t.Errorf("Received %+v", cam)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No camera frame received")
+	}
+
+	//reliable commands are repeated, since UDP may lose them
+	if err := transport.Publish(StreamCommand, 3, []byte{4, 0, 0, 0, 0}, Retained); err != nil {
+		t.Fatal(err)
+	}
+	robot.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	for i := 0; i < config.UdpReliableRepeats; i++ {
+		n, err := robot.Read(buf)
+		if err != nil {
+			t.Fatalf("Datagram %d: %v", i+1, err)
+		}
+		if stream, id, payload, err := decodeFrame(buf[:n]); err != nil || stream != StreamCommand || id != 3 || payload[0] != 4 {
+			t.Errorf("Robot received %s, %d, %v, %v", stream, id, payload, err)
+		}
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport()
+	chReceive := make(chan types.AdvMsg, 1)
+	Subscribe(transport, chReceive)
+	transport.Send(StreamAdv, 5, EncodeAdvMsg(types.AdvMsg{Id: 5, Theta: 90}))
+	if msg := <-chReceive; msg.Id != 5 || msg.Theta != 90 {
+		t.Errorf("Received %+v", msg)
+	}
+	transport.Send(StreamAdv, 5, []byte{1, 2, 3})
+	if len(chReceive) != 0 {
+		t.Error("Invalid payload was not dropped")
+	}
+}
@@ -0,0 +1,89 @@
+package communication
+
+import (
+	"fmt"
+	"golang-server/config"
+	"net"
+	"sync"
+)
+
+// udpTransport receives one frame per datagram on config.UdpAddress. Commands are sent to the address a
+// robot last sent from, so a robot has to send before it can be commanded. UDP has no delivery
+// guarantee, so reliable payloads are sent config.UdpReliableRepeats times; the commands they carry can
+// be repeated safely.
+type udpTransport struct {
+	subscribers
+	conn   *net.UDPConn
+	mu     sync.Mutex
+	robots map[int]*net.UDPAddr //robot id -> address of its latest datagram
+}
+
+func initUdpTransport(address string) (*udpTransport, error) {
+	local, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", local)
+	if err != nil {
+		return nil, err
+	}
+	t := &udpTransport{conn: conn, robots: map[int]*net.UDPAddr{}}
+	go t.read()
+	logger.Info("Listening for robots", "transport", "udp", "address", conn.LocalAddr().String())
+	return t, nil
+}
+
+func (t *udpTransport) read() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			logger.Info("UDP transport stopped", "err", err)
+			return
+		}
+		stream, id, payload, err := decodeFrame(buf[:n])
+		if err != nil {
+			logger.Warn("Invalid UDP frame", "from", from.String(), "err", err)
+			continue
+		}
+		t.mu.Lock()
+		t.robots[id] = from
+		t.mu.Unlock()
+		if !t.dispatch(stream, id, append([]byte{}, payload...)) {
+			logger.Debug("UDP frame without subscriber dropped", "stream", stream, "robot", id)
+		}
+	}
+}
+
+func (t *udpTransport) Subscribe(stream Stream, handler func(id int, payload []byte)) error {
+	t.add(stream, handler)
+	logger.Info("Subscribed", "transport", "udp", "stream", stream)
+	return nil
+}
+
+func (t *udpTransport) Publish(stream Stream, id int, payload []byte, delivery Delivery) error {
+	t.mu.Lock()
+	to, known := t.robots[id]
+	t.mu.Unlock()
+	if !known {
+		return fmt.Errorf("robot %d has not sent anything, its address is unknown", id)
+	}
+	frame, err := encodeFrame(stream, id, payload)
+	if err != nil {
+		return err
+	}
+	repeats := 1
+	if delivery >= Reliable {
+		repeats = config.UdpReliableRepeats
+	}
+	for i := 0; i < repeats; i++ {
+		if _, err := t.conn.WriteToUDP(frame, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
@@ -4,6 +4,25 @@ package config
 //"broker.emqx.io" can be used for testing. The program does not run unless it connects to a broker.
 const Broker = "slam" //"broker.emqx.io"
 const Port = 1883
+const BrokerScheme = "tcp"    //"tcp", "ssl" (TLS), "ws" or "wss" (websocket over TLS)
+const BrokerPath = "/mqtt"    //websocket path, only used by "ws" and "wss"
+const MqttCAFile = ""         //PEM CA bundle to verify the broker with, empty for the system roots
+const MqttCertFile = ""       //PEM client certificate, for brokers that authenticate clients by certificate
+const MqttKeyFile = ""        //PEM private key of MqttCertFile
+const MqttUsername = ""       //MQTT_USERNAME in the environment overrides it
+const MqttPassword = ""       //MQTT_PASSWORD in the environment overrides it, so it need not be committed
+const MqttClientId = ""       //empty lets the broker assign one
+const MqttCleanSession = true //false keeps subscriptions and QoS 1 messages across reconnects, needs MqttClientId
+
+// TRANSPORT
+// How the server talks to the robots: "mqtt", "udp", "serial" or "memory" (no robots, e.g. to try the
+// gui). All carry the same payloads, see communication/transport.go.
+const Transport = "mqtt"
+const UdpAddress = ":5005"          //local address the robots send their frames to
+const UdpReliableRepeats = 3        //times stop, pause and resume are sent, since UDP may lose them
+const SerialDevice = "/dev/ttyACM0" //nRF dongle relaying the frames to the robots
+const SerialBaud = 115200           //set in raw mode, USB CDC devices ignore it
+const SerialReliableRepeats = 3     //times stop, pause and resume are written, since the radio may lose them
 
 // MAP
 const MapSize = 400            //cm, 400x400 squares
@@ -13,16 +32,175 @@ const MapCenterY = MapSize / 2 //cm (origin is at the top left corner)
 // ROBOT
 const IrSensorMaxDistance = 60 //cm
 
-// Camera mounting offset (mm) measured from robot center forward along robot body.
-// Increase if the camera is mounted ahead of the robot center so segments map
-// correctly in front of the robot.
-const CameraMountOffsetMM = 30 //mm
+// SENSOR MODELS
+// The noise of the sensors is range dependent, the standard deviation is base + perCm * range.
+const IrNoiseBaseCm = 0.5
+const IrNoisePerCm = 0.02
+const CameraNoiseBaseCm = 1.0
+const CameraNoisePerCm = 0.03
+const CameraFovDeg = 80       //degrees, horizontal field of view of the camera
+const CameraMaxRangeCm = 150  //cm, segments further away are ignored
+const CameraClearRangeCm = 80 //cm, how far free space is cleared when the camera sees nothing
+
+// Camera mounting per robot. OffsetMM is measured from the robot center forward along the robot body.
+// Increase it if the camera is mounted ahead of the robot center so segments map correctly in front of
+// the robot. TiltDeg is how far the camera is tilted down from horizontal.
+type SensorMount struct {
+	OffsetMM int     //mm
+	TiltDeg  float64 //degrees
+}
+
+var DefaultCameraMount = SensorMount{OffsetMM: 30, TiltDeg: 0}
+var CameraMounts = map[int]SensorMount{} //robot id -> mount, e.g. {5: {OffsetMM: 40, TiltDeg: 10}}
+
+// IR noise per robot, for robots whose IR sensors are noisier or better than IrNoiseBaseCm and IrNoisePerCm.
+type SensorNoise struct {
+	BaseCm float64 //cm
+	PerCm  float64 //cm of standard deviation per cm of range
+}
+
+var IrNoise = map[int]SensorNoise{} //robot id -> noise, e.g. {5: {BaseCm: 1, PerCm: 0.03}}
+
+// SCAN MATCHING
+// Server-side pose correction. IR and camera points are collected for a short window per robot
+// and aligned against the occupancy grid before they are added to the map.
+const UseScanMatching = false
+const ScanMatchWindow = 10      //messages (IR and camera) per robot before matching
+const ScanMatchSearchRadius = 5 //cm, translation search in each direction
+const ScanMatchSearchAngle = 4  //degrees, rotation search in each direction
+const ScanMatchMinPoints = 6    //minimum number of obstacle points needed to attempt a match
+
+// POSE GRAPH
+// Keyframes with their local IR and camera scans are stored per robot and connected by odometry edges.
+// Loop closures are found by scan matching against older keyframes, and the map is rebuilt from the optimized poses.
+const UsePoseGraph = false
+const KeyframeDistance = 20        //cm travelled before a new keyframe is made
+const KeyframeAngle = 20           //degrees turned before a new keyframe is made
+const LoopClosureRadius = 50       //cm, only keyframes closer than this are matched
+const LoopClosureMinAge = 10       //keyframes, recent keyframes of the same robot are not matched
+const LoopClosureSearchRadius = 10 //cm, translation search in each direction
+const LoopClosureSearchAngle = 10  //degrees, rotation search in each direction
+const PoseGraphIterations = 10     //Gauss-Newton iterations per optimization
+const KeyframeObservations = 500   //IR rays, camera segments and camera wedges each, older ones are thinned out
+
+// MAP MERGING
+// Robots waiting for initialization build a local map in their own frame. The server estimates where the
+// local map fits in the global map and proposes the alignment in the Init tab, where the operator confirms it.
+const UseMapMerging = false
+const MapMergeMinCells = 30    //obstacle cells needed in a local map before it is matched
+const MapMergeConfidence = 0.6 //fraction of matching obstacle cells needed to propose an alignment
+const MapMergeAngleStep = 5    //degrees, coarse rotation search step
+const MapMergeInterval = 5     //seconds between match attempts per robot
+
+// DYNAMIC OBSTACLES
+// With UseChangeDetection, cells that change between open and obstacle are highlighted in the map, see Show
+// map changes in the View tab. With UseCellDecay, cells that are not observed for CellDecayAfter fade back
+// toward unknown, so people walking past do not leave permanent obstacles. Cells that have changed before
+// decay at the faster rate.
+const UseChangeDetection = false
+const UseCellDecay = false
+const CellDecayInterval = 1      //s between decay steps
+const CellDecayAfter = 30        //s a cell is not observed before it decays
+const CellDecayRate = 0.02       //log-odds per second toward unknown, 0 keeps the static parts of the map
+const DynamicCellDecayRate = 0.5 //log-odds per second toward unknown for cells that have changed
+const ContradictionKeep = 0.5    //part of the log-odds kept when an observation contradicts a cell
+const ChangeHighlightTime = 10   //s a change is highlighted
+
+// COSTMAP
+// The costmap combines the static map, the live obstacles and the keep-out zones, and inflates them by the
+// footprint of each robot. Goals where a robot would touch an obstacle are moved away from it or rejected,
+// see GeofenceClipGoals. StaticMapFile is a png with one pixel per cell, black for obstacles, white for open
+// and gray for unknown cells, e.g. the map.png saved in a session directory. Empty for no static map.
+const UseCostmap = false
+const StaticMapFile = ""
+const MapSaveInterval = 30     //s between saves of map.png, so a session that is killed keeps its map
+const RobotRadius = 10         //cm, footprint radius of the robots
+const InflationRadius = 25     //cm past the footprint where obstacles still add cost
+const CostScalingFactor = 0.15 //per cm, how fast the cost falls past the footprint
+const CostmapGoalSearch = 30   //cm, how far a goal too close to an obstacle can be moved
+
+var RobotRadii = map[int]float64{} //robot id -> footprint radius in cm, for robots other than RobotRadius
 
 // GUI
 const GuiFrameRate = 5            //fps
 const MapMinimumDisplaySize = 400 //px
+const MapMaxZoom = 8              //times the size of the whole map
+const MapZoomStep = 1.2           //zoom factor per scroll step
 const WindowBreadth = 650         //px
 const WindowHeight = 400          //px
 
+// ROBOT PANELS
+// Each robot has a panel with its latest telemetry and plots of the last TelemetryHistory seconds. It opens
+// from the Manual tab or by tapping the robot on the map.
+const TelemetryHistory = 30   //s shown in the plots
+const TelemetryRateWindow = 2 //s over which the message rate is counted
+
+// GEOFENCES
+// Keep-out and allowed zones are drawn in the GUI or loaded from a file. They are saved in GeofenceFile,
+// which is loaded when the server starts.
+const GeofenceFile = "geofences.json"
+const GeofenceClipGoals = true //move forbidden goals to the closest allowed position, otherwise reject them
+const GeofenceMargin = 5       //cm, distance kept from a zone border when a goal is moved
+const GeofenceAutoStop = false //stop all robots when a robot enters a keep-out zone, otherwise only warn
+
+// TRAFFIC
+// The straight path from each robot to its goal is predicted at TrafficSpeed and reserved cell by cell.
+// A robot whose path crosses the reservations of a robot with an older goal is paused until the conflict
+// is gone.
+const UseTrafficManagement = false
+const TrafficCheckInterval = 500 //ms
+const TrafficSpeed = 15          //cm/s, assumed robot speed
+const TrafficCellSize = 15       //cm, robots in neighbouring cells at the same time conflict
+const TrafficTimeMargin = 2      //s, uncertainty in when a robot reaches a cell
+const TrafficHorizon = 20        //s, paths are only predicted this far ahead
+const TrafficGoalReached = 10    //cm, a robot this close to its goal has no path
+
+// COVERAGE
+// Exploration statistics are shown in the Stats tab and written to coverage.csv in the session directory.
+// A summary is written to summary.txt when the server is closed. Regions of interest are drawn in the
+// Zones tab.
+const CoverageLogInterval = 5 //s between rows in coverage.csv
+
+// EXPORT
+// The map, trajectories, poses and goals are exported as SVG, PNG or GeoJSON from the Export tab, or from a
+// saved session with "go run . export", see the README.
+const ExportMargin = 10        //cm kept around the explored part of the map when cropping
+const ExportPngScale = 4       //px per cm
+const ExportTrajectoryStep = 2 //cm a robot moves before its trajectory gets a new point
+
+// METRICS
+// Prometheus metrics are served on http://<MetricsAddress>/metrics, see metrics/slam.go. Empty to disable.
+const MetricsAddress = ":2112"
+
+// LOGGING
+// Every run writes general.log, the trajectory log and recordings to a new directory under LogDir.
+const LogDir = "logs"
+const LogLevel = "info"  //"debug", "info", "warn" or "error"
+const LogFormat = "text" //"text" or "json"
+const LogMaxSizeMB = 10  //general.log is rotated when it is larger than this
+const LogMaxAge = 24     //hours, general.log is rotated when it is older than this
+const LogMaxBackups = 5  //rotated files kept, general.log.1 is the newest
+
+// The trajectory log has one row per message from an initialized robot, see log/trajectory.go.
+const TrajectoryFormat = "csv" //"csv" or "jsonl"
+
+// AUDIT
+// Every command is written to audit.jsonl in the session directory, with its source, operator and
+// outcome, see backend/audit.go. With UseOperatorRoles, commands are only sent if the role of the
+// operator allows them. OperatorsFile maps operator names to roles, e.g. {"alice": "supervisor"}.
+const UseOperatorRoles = false
+const OperatorsFile = "operators.json"
+const GuiOperator = "" //operator of the gui, empty for the user running the server
+
+// TELEOP
+// Velocity commands are repeated at TeleopRate while keys or the gamepad are held, and a stop is sent
+// as soon as they are released. Held keys are released when the window loses the focus, or when no key
+// event arrives for TeleopKeyTimeout, which must be longer than the key repeat delay of the system.
+const TeleopRate = 10         //Hz
+const TeleopLinearSpeed = 100 //mm/s at full input
+const TeleopAngularSpeed = 45 //degrees/s at full input
+const TeleopKeyTimeout = 1000 //ms
+const TeleopGamepadDeadzone = 0.15
+
 // Enable nicla vision camera handling in the server
 const UseNiclaVision = true
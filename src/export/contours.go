@@ -0,0 +1,146 @@
+package export
+
+import (
+	"golang-server/config"
+	"math"
+	"slices"
+)
+
+// Obstacle contours follow the borders of the obstacle cells. Corner (i, j) is the top left corner of
+// cell (i, j) in map indices, where y grows downward.
+
+// directions in map indices, each a right turn from the one before: east, south, west, north
+var directions = [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+
+type contourEdge struct {
+	x, y      int //corner the edge starts at
+	direction int //index in directions
+}
+
+// contours returns the borders of the obstacles as closed rings in map coordinates, the first point
+// repeated at the end. Outer borders are counterclockwise and the borders of holes clockwise, like the
+// rings of GeoJSON polygons. Where two obstacles only touch at a corner, they get separate rings.
+func contours(grid *Grid) [][][2]float64 {
+	obstacle := func(x, y int) bool {
+		return x >= 0 && x < config.MapSize && y >= 0 && y < config.MapSize && grid[x][y] == Obstacle
+	}
+	//every side of an obstacle cell next to another cell is an edge, with the obstacle on its right
+	edges := map[contourEdge]bool{}
+	starts := []contourEdge{} //in scan order, so the rings are always in the same order
+	for y := 0; y < config.MapSize; y++ {
+		for x := 0; x < config.MapSize; x++ {
+			if !obstacle(x, y) {
+				continue
+			}
+			sides := [4]struct {
+				free bool
+				edge contourEdge
+			}{
+				{!obstacle(x, y-1), contourEdge{x, y, 0}},
+				{!obstacle(x+1, y), contourEdge{x + 1, y, 1}},
+				{!obstacle(x, y+1), contourEdge{x + 1, y + 1, 2}},
+				{!obstacle(x-1, y), contourEdge{x, y + 1, 3}},
+			}
+			for _, side := range sides {
+				if side.free {
+					edges[side.edge] = true
+					starts = append(starts, side.edge)
+				}
+			}
+		}
+	}
+
+	rings := [][][2]float64{}
+	for _, start := range starts {
+		if !edges[start] {
+			continue //already part of a ring
+		}
+		corners := [][2]int{{start.x, start.y}}
+		for edge, found := start, true; found; {
+			delete(edges, edge)
+			x, y := edge.x+directions[edge.direction][0], edge.y+directions[edge.direction][1]
+			corners = append(corners, [2]int{x, y})
+			//turning right first keeps to the obstacle, so obstacles touching at a corner are not joined
+			found = false
+			for _, turn := range []int{1, 0, 3} {
+				next := contourEdge{x, y, (edge.direction + turn) % 4}
+				if edges[next] {
+					edge, found = next, true
+					break
+				}
+			}
+		}
+		rings = append(rings, ringCoordinates(corners))
+	}
+	return rings
+}
+
+// ringCoordinates converts the corners of a closed ring to map coordinates, leaving out the corners
+// where the ring goes straight on. The edges have the obstacle on their right in map indices, which is
+// clockwise with y up, so the ring is reversed.
+func ringCoordinates(corners [][2]int) [][2]float64 {
+	n := len(corners) - 1 //the last corner is the first one again
+	ring := make([][2]float64, 0, n+1)
+	for i := 0; i < n; i++ {
+		previous, next := corners[(i+n-1)%n], corners[i+1]
+		if (corners[i][0]-previous[0])*(next[1]-corners[i][1]) == (corners[i][1]-previous[1])*(next[0]-corners[i][0]) {
+			continue
+		}
+		ring = append(ring, [2]float64{float64(corners[i][0]-config.MapCenterX) - 0.5, float64(config.MapCenterY-corners[i][1]) + 0.5})
+	}
+	slices.Reverse(ring)
+	return append(ring, ring[0])
+}
+
+// signedArea is positive for counterclockwise rings.
+func signedArea(ring [][2]float64) float64 {
+	area := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// insideRing tells if a point is inside a closed ring, by counting the edges crossed by a ray to the right.
+func insideRing(ring [][2]float64, x, y float64) bool {
+	inside := false
+	for i := 0; i+1 < len(ring); i++ {
+		a, b := ring[i], ring[i+1]
+		if (a[1] > y) != (b[1] > y) && x < a[0]+(y-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// obstaclePolygons groups the contours into polygons, each an outer ring followed by its holes.
+func obstaclePolygons(grid *Grid) [][][][2]float64 {
+	rings := contours(grid)
+	polygons := [][][][2]float64{}
+	var holes [][][2]float64
+	for _, ring := range rings {
+		if signedArea(ring) > 0 {
+			polygons = append(polygons, [][][2]float64{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	//a hole belongs to the smallest outer ring around it. The obstacle is on the left of the edges, so a
+	//point just right of an edge of the hole is inside the hole, and not on any ring.
+	for _, hole := range holes {
+		a, b := hole[0], hole[1]
+		length := math.Hypot(b[0]-a[0], b[1]-a[1])
+		x, y := (a[0]+b[0])/2+0.25*(b[1]-a[1])/length, (a[1]+b[1])/2-0.25*(b[0]-a[0])/length
+		best, bestArea := -1, 0.0
+		for i, polygon := range polygons {
+			area := signedArea(polygon[0])
+			if (best == -1 || area < bestArea) && insideRing(polygon[0], x, y) {
+				best, bestArea = i, area
+			}
+		}
+		if best != -1 {
+			polygons[best] = append(polygons[best], hole)
+		}
+	}
+	return polygons
+}
@@ -0,0 +1,164 @@
+package export
+
+import (
+	"fmt"
+	"golang-server/config"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Figures of the map and of what the robots did, for reports: SVG, high resolution PNG and GeoJSON.
+// Everything is in the map frame, in cm, with y up. A scene is either the live state of the backend or a
+// saved session, see LoadSession.
+
+// Values of the cells of a Grid, the same as the map in the backend.
+const (
+	Open uint8 = 1 << iota
+	Unknown
+	Obstacle
+)
+
+// Grid is an occupancy grid in map indices, see config.MapCenterX.
+type Grid = [config.MapSize][config.MapSize]uint8
+
+// Scene is what can be exported.
+type Scene struct {
+	Map          *Grid                //nil for no map
+	Trajectories map[int][][2]float64 //robot id -> positions in order, cm
+	Poses        map[int][3]float64   //robot id -> x, y (cm), theta (degrees)
+	Goals        map[int][2]float64   //robot id -> goal, cm
+}
+
+// Layers selects what is drawn.
+type Layers uint8
+
+const (
+	LayerMap Layers = 1 << iota
+	LayerTrajectories
+	LayerPoses
+	LayerGoals
+	LayerAxes //axes through the origin and a scale bar
+	AllLayers = LayerMap | LayerTrajectories | LayerPoses | LayerGoals | LayerAxes
+)
+
+var layerNames = []string{"map", "trajectories", "poses", "goals", "axes"}
+
+// LayerNames are the names accepted by ParseLayers, in order.
+func LayerNames() []string {
+	return slices.Clone(layerNames)
+}
+
+// ParseLayers returns the layers with the given names, e.g. "map" and "trajectories".
+func ParseLayers(names []string) (Layers, error) {
+	var layers Layers
+	for _, name := range names {
+		i := slices.Index(layerNames, strings.TrimSpace(name))
+		if i == -1 {
+			return 0, fmt.Errorf("unknown layer %q, expected one of %s", name, strings.Join(layerNames, ", "))
+		}
+		layers |= 1 << i
+	}
+	return layers, nil
+}
+
+type Options struct {
+	Layers Layers
+	Crop   bool //crop to the explored part of the map, otherwise the whole map is drawn
+	Scale  int  //px per cm, the size of PNG and SVG images
+}
+
+// Formats are the file formats that can be written, also used as file extensions.
+var Formats = []string{"svg", "png", "geojson"}
+
+// Write writes the scene in a format from Formats.
+func Write(w io.Writer, format string, scene *Scene, options Options) error {
+	switch format {
+	case "svg":
+		return WriteSVG(w, scene, options)
+	case "png":
+		return WritePNG(w, scene, options)
+	case "geojson":
+		return WriteGeoJSON(w, scene, options)
+	}
+	return fmt.Errorf("unknown export format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// cellCenter returns the map coordinates of a cell.
+func cellCenter(x, y int) (float64, float64) {
+	return float64(x - config.MapCenterX), float64(config.MapCenterY - y)
+}
+
+// bounds returns the part of the map that is drawn, in cm: the whole map, or with options.Crop the cells
+// that are not unknown and everything the robots did, config.ExportMargin past them.
+func bounds(scene *Scene, options Options) figure {
+	xMin, yMin := cellCenter(0, config.MapSize-1)
+	xMax, yMax := cellCenter(config.MapSize-1, 0)
+	xMin, yMin, xMax, yMax = xMin-0.5, yMin-0.5, xMax+0.5, yMax+0.5
+	if !options.Crop {
+		return figure{xMin, yMin, xMax, yMax}
+	}
+	cropped := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	add := func(x, y float64) {
+		cropped = [4]float64{min(cropped[0], x), min(cropped[1], y), max(cropped[2], x), max(cropped[3], y)}
+	}
+	if scene.Map != nil && options.Layers&LayerMap != 0 {
+		for x := range scene.Map {
+			for y := range scene.Map[x] {
+				if scene.Map[x][y] != Unknown {
+					cx, cy := cellCenter(x, y)
+					add(cx-0.5, cy-0.5)
+					add(cx+0.5, cy+0.5)
+				}
+			}
+		}
+	}
+	if options.Layers&LayerTrajectories != 0 {
+		for _, trajectory := range scene.Trajectories {
+			for _, p := range trajectory {
+				add(p[0], p[1])
+			}
+		}
+	}
+	if options.Layers&LayerPoses != 0 {
+		for _, pose := range scene.Poses {
+			add(pose[0], pose[1])
+		}
+	}
+	if options.Layers&LayerGoals != 0 {
+		for _, goal := range scene.Goals {
+			add(goal[0], goal[1])
+		}
+	}
+	if math.IsInf(cropped[0], 1) {
+		return figure{xMin, yMin, xMax, yMax} //nothing to crop to
+	}
+	return figure{max(cropped[0]-config.ExportMargin, xMin), max(cropped[1]-config.ExportMargin, yMin),
+		min(cropped[2]+config.ExportMargin, xMax), min(cropped[3]+config.ExportMargin, yMax)}
+}
+
+// AppendPosition adds a position to a trajectory, unless it is closer than config.ExportTrajectoryStep to
+// the last one.
+func AppendPosition(trajectory [][2]float64, x, y float64) [][2]float64 {
+	if n := len(trajectory); n > 0 && math.Hypot(x-trajectory[n-1][0], y-trajectory[n-1][1]) < config.ExportTrajectoryStep {
+		return trajectory
+	}
+	return append(trajectory, [2]float64{x, y})
+}
+
+// robotIds returns the robots of the scene, in order.
+func robotIds(scene *Scene) []int {
+	ids := []int{}
+	for id := range scene.Trajectories {
+		ids = append(ids, id)
+	}
+	for id := range scene.Poses {
+		ids = append(ids, id)
+	}
+	for id := range scene.Goals {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
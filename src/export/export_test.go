@@ -0,0 +1,227 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"golang-server/config"
+	"golang-server/log"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func unknownGrid() *Grid {
+	grid := &Grid{}
+	for x := range grid {
+		for y := range grid[x] {
+			grid[x][y] = Unknown
+		}
+	}
+	return grid
+}
+
+// setCell sets the cell at map coordinates x, y.
+func setCell(grid *Grid, x, y int, value uint8) {
+	grid[config.MapCenterX+x][config.MapCenterY-y] = value
+}
+
+func testScene() *Scene {
+	grid := unknownGrid()
+	for x := -20; x <= 20; x++ {
+		for y := -10; y <= 10; y++ {
+			setCell(grid, x, y, Open)
+		}
+		setCell(grid, x, 10, Obstacle)
+	}
+	return &Scene{
+		Map:          grid,
+		Trajectories: map[int][][2]float64{1: {{0, 0}, {5, 0}, {10, 2}}, 2: {{-10, -5}, {-10, 0}}},
+		Poses:        map[int][3]float64{1: {10, 2, 45}},
+		Goals:        map[int][2]float64{1: {15, 5}},
+	}
+}
+
+func TestContours(t *testing.T) {
+	grid := unknownGrid()
+	setCell(grid, 3, 4, Obstacle)
+	rings := contours(grid)
+	if len(rings) != 1 || len(rings[0]) != 5 {
+		t.Fatalf("Expected a square, got %v", rings)
+	}
+	if area := signedArea(rings[0]); area != 1 {
+		t.Errorf("Expected a counterclockwise ring of area 1, got area %v", area)
+	}
+	if !insideRing(rings[0], 3, 4) || insideRing(rings[0], 4, 4) {
+		t.Errorf("Expected the ring around the cell at (3, 4), got %v", rings[0])
+	}
+}
+
+func TestObstaclePolygonsWithHole(t *testing.T) {
+	//a 5x5 block with a 1x1 hole in the middle, and a separate cell
+	grid := unknownGrid()
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			setCell(grid, x, y, Obstacle)
+		}
+	}
+	setCell(grid, 2, 2, Open)
+	setCell(grid, 10, 10, Obstacle)
+
+	polygons := obstaclePolygons(grid)
+	if len(polygons) != 2 {
+		t.Fatalf("Expected 2 polygons, got %d", len(polygons))
+	}
+	block := polygons[0]
+	if len(polygons[1]) == 2 {
+		block = polygons[1]
+	}
+	if len(block) != 2 {
+		t.Fatalf("Expected the block to have one hole, got %d rings", len(block))
+	}
+	if outer, hole := signedArea(block[0]), signedArea(block[1]); outer != 25 || hole != -1 {
+		t.Errorf("Expected areas 25 and -1, got %v and %v", outer, hole)
+	}
+}
+
+func TestParseLayers(t *testing.T) {
+	layers, err := ParseLayers([]string{"map", " goals"})
+	if err != nil || layers != LayerMap|LayerGoals {
+		t.Errorf("Expected map and goals, got %b (%v)", layers, err)
+	}
+	if layers, err := ParseLayers(LayerNames()); err != nil || layers != AllLayers {
+		t.Errorf("Expected all layers, got %b (%v)", layers, err)
+	}
+	if _, err := ParseLayers([]string{"robots"}); err == nil {
+		t.Error("Expected an error for an unknown layer")
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "geojson", testScene(), Options{Layers: AllLayers}); err != nil {
+		t.Fatal(err)
+	}
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry   struct{ Type string } `json:"geometry"`
+			Properties map[string]any        `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &collection); err != nil {
+		t.Fatalf("Not valid JSON: %v", err)
+	}
+	kinds := map[string]int{}
+	for _, feature := range collection.Features {
+		kinds[feature.Geometry.Type+" "+feature.Properties["kind"].(string)]++
+	}
+	expected := map[string]int{"Polygon obstacle": 1, "LineString trajectory": 2, "Point pose": 1, "Point goal": 1}
+	if collection.Type != "FeatureCollection" || len(kinds) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, kinds)
+	}
+	for kind, n := range expected {
+		if kinds[kind] != n {
+			t.Errorf("Expected %d %s, got %d", n, kind, kinds[kind])
+		}
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "svg", testScene(), Options{Layers: AllLayers, Crop: true, Scale: 2}); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	//cropped to x -20..20 and y -10..10, plus the margin
+	width, height := 41+2*config.ExportMargin, 21+2*config.ExportMargin
+	if !strings.Contains(svg, `viewBox="0 0 `+strconv.Itoa(width)+" "+strconv.Itoa(height)+`"`) {
+		t.Errorf("Expected a %dx%d view box, got %s", width, height, strings.SplitN(svg, "\n", 2)[0])
+	}
+	for _, element := range []string{"<polyline", "<circle", "<path", "<text", "NRF-1"} {
+		if !strings.Contains(svg, element) {
+			t.Errorf("Expected %s in the svg", element)
+		}
+	}
+	buf.Reset()
+	Write(&buf, "svg", testScene(), Options{Layers: LayerMap, Scale: 1})
+	if strings.Contains(buf.String(), "<polyline") {
+		t.Error("Expected no trajectories without the layer")
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "png", testScene(), Options{Layers: AllLayers, Crop: true, Scale: 3}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, height := 3*(41+2*config.ExportMargin), 3*(21+2*config.ExportMargin)
+	if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+		t.Errorf("Expected %dx%d px, got %v", width, height, img.Bounds())
+	}
+	//the wall at y = 10, near the top
+	if r, g, b, _ := img.At(width/2, 3*config.ExportMargin+1).RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Errorf("Expected the obstacle to be black, got %d %d %d", r, g, b)
+	}
+}
+
+func TestMapImageRoundTrip(t *testing.T) {
+	grid := testScene().Map
+	var buf bytes.Buffer
+	if err := WriteMapImage(&buf, grid); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadMapImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *read != *grid {
+		t.Error("The map read back is different")
+	}
+}
+
+func TestLoadSession(t *testing.T) {
+	dir := t.TempDir()
+	scene := testScene()
+	file, _ := os.Create(filepath.Join(dir, "map.png"))
+	WriteMapImage(file, scene.Map)
+	file.Close()
+
+	//only some of the columns, like a trimmed log
+	file, _ = os.Create(filepath.Join(dir, "trajectory.csv"))
+	file.WriteString("robot_id,x_cm,y_cm,theta_deg\n4,0,0,0\n4,1,0,0\n4,10,0,90\n")
+	file.Close()
+
+	goal := [2]int{30, 40}
+	file, _ = os.Create(filepath.Join(dir, "audit.jsonl"))
+	audit := log.NewAuditLogger(file)
+	audit.Write(log.AuditRecord{Time: time.Now(), Command: "goal", Robot: 4, Map: &goal, Outcome: log.AuditSent})
+	audit.Write(log.AuditRecord{Time: time.Now(), Command: "goal", Robot: 4, Map: &[2]int{0, 0}, Outcome: log.AuditDropped})
+	audit.Close()
+
+	loaded, err := LoadSession(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Map == nil || *loaded.Map != *scene.Map {
+		t.Error("Expected the map from map.png")
+	}
+	//the second position is closer than config.ExportTrajectoryStep to the first
+	if got := loaded.Trajectories[4]; len(got) != 2 || got[1] != [2]float64{10, 0} {
+		t.Errorf("Expected the trajectory [[0 0] [10 0]], got %v", got)
+	}
+	if loaded.Poses[4] != [3]float64{10, 0, 90} || loaded.Goals[4] != [2]float64{30, 40} {
+		t.Errorf("Expected the pose (10, 0, 90) and the goal (30, 40), got %v and %v", loaded.Poses[4], loaded.Goals[4])
+	}
+
+	if _, err := LoadSession(t.TempDir()); err == nil {
+		t.Error("Expected an error for an empty directory")
+	}
+}
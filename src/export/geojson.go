@@ -0,0 +1,48 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// GeoJSON coordinates are in the map frame, in cm, not longitude and latitude. GIS tools load them as a
+// layer without a coordinate reference system.
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+func geoJSON(geometryType string, coordinates any, properties map[string]any) geoJSONFeature {
+	return geoJSONFeature{"Feature", geoJSONGeometry{geometryType, coordinates}, properties}
+}
+
+// WriteGeoJSON writes a FeatureCollection: obstacle contours as polygons with holes, trajectories as
+// line strings, and poses and goals as points. Every feature has a "kind" property, and all but the
+// obstacles a "robot" property. Only options.Layers is used.
+func WriteGeoJSON(w io.Writer, scene *Scene, options Options) error {
+	features := []geoJSONFeature{}
+	if scene.Map != nil && options.Layers&LayerMap != 0 {
+		for _, polygon := range obstaclePolygons(scene.Map) {
+			features = append(features, geoJSON("Polygon", polygon, map[string]any{"kind": "obstacle"}))
+		}
+	}
+	for _, id := range robotIds(scene) {
+		if trajectory := scene.Trajectories[id]; options.Layers&LayerTrajectories != 0 && len(trajectory) >= 2 {
+			features = append(features, geoJSON("LineString", trajectory, map[string]any{"kind": "trajectory", "robot": id}))
+		}
+		if pose, exist := scene.Poses[id]; options.Layers&LayerPoses != 0 && exist {
+			features = append(features, geoJSON("Point", pose[:2], map[string]any{"kind": "pose", "robot": id, "theta": pose[2]}))
+		}
+		if goal, exist := scene.Goals[id]; options.Layers&LayerGoals != 0 && exist {
+			features = append(features, geoJSON("Point", goal, map[string]any{"kind": "goal", "robot": id}))
+		}
+	}
+	return json.NewEncoder(w).Encode(map[string]any{"type": "FeatureCollection", "features": features})
+}
@@ -0,0 +1,71 @@
+package export
+
+import (
+	"fmt"
+	"golang-server/config"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Maps are saved as png images with one pixel per cell, in map indices: black for obstacles, white for
+// open cells and gray for unknown cells. The backend saves the map of a session as map.png in the session
+// directory, and can load one as the static layer of the costmap, see config.StaticMapFile.
+
+var mapPalette = color.Palette{color.Black, color.White, color.Gray{0x80}}
+
+func WriteMapImage(w io.Writer, grid *Grid) error {
+	img := image.NewPaletted(image.Rect(0, 0, config.MapSize, config.MapSize), mapPalette)
+	for x := range grid {
+		for y := range grid[x] {
+			switch grid[x][y] {
+			case Obstacle:
+				img.SetColorIndex(x, y, 0)
+			case Open:
+				img.SetColorIndex(x, y, 1)
+			default:
+				img.SetColorIndex(x, y, 2)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// ReadMapImage reads a map saved by WriteMapImage. Other images of the same size are read by brightness,
+// dark pixels, including the red obstacles of the gui, are obstacles and light pixels are open.
+func ReadMapImage(r io.Reader) (*Grid, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != config.MapSize || bounds.Dy() != config.MapSize {
+		return nil, fmt.Errorf("the map is %dx%d px, expected %dx%d", bounds.Dx(), bounds.Dy(), config.MapSize, config.MapSize)
+	}
+	grid := &Grid{}
+	for x := range grid {
+		for y := range grid[x] {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			switch {
+			case gray < 0x60:
+				grid[x][y] = Obstacle
+			case gray > 0xc0:
+				grid[x][y] = Open
+			default:
+				grid[x][y] = Unknown
+			}
+		}
+	}
+	return grid, nil
+}
+
+func LoadMapImage(path string) (*Grid, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadMapImage(file)
+}
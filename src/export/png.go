@@ -0,0 +1,124 @@
+package export
+
+import (
+	"golang-server/config"
+	"golang-server/utilities"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// raster draws on an image of the figure, options.Scale px per cm.
+type raster struct {
+	img   *image.RGBA
+	f     figure
+	scale float64
+}
+
+// pixel returns the pixel of a point in map coordinates.
+func (r *raster) pixel(x, y float64) (float64, float64) {
+	return (x - r.f.xMin) * r.scale, (r.f.yMax - y) * r.scale
+}
+
+// disk fills the pixels within radius px of a pixel, blending translucent colors with what is drawn.
+func (r *raster) disk(px, py, radius float64, c color.NRGBA) {
+	for y := int(math.Floor(py - radius)); y <= int(math.Ceil(py+radius)); y++ {
+		for x := int(math.Floor(px - radius)); x <= int(math.Ceil(px+radius)); x++ {
+			if !(image.Point{x, y}.In(r.img.Bounds())) || math.Hypot(float64(x)-px, float64(y)-py) > radius {
+				continue
+			}
+			blend := func(src, dst uint8) uint8 { return uint8((uint16(src)*uint16(c.A) + uint16(dst)*uint16(255-c.A)) / 255) }
+			dst := r.img.RGBAAt(x, y)
+			r.img.SetRGBA(x, y, color.RGBA{blend(c.R, dst.R), blend(c.G, dst.G), blend(c.B, dst.B), 0xff})
+		}
+	}
+}
+
+// line draws a line between two points in map coordinates, width px wide.
+func (r *raster) line(x0, y0, x1, y1, width float64, c color.RGBA) {
+	px0, py0 := r.pixel(x0, y0)
+	px1, py1 := r.pixel(x1, y1)
+	utilities.SupercoverLine(px0, py0, px1, py1, func(x, y int) {
+		r.disk(float64(x), float64(y), width/2, color.NRGBA(c))
+	})
+}
+
+// text writes a label with its baseline starting dx, dy px from a point in map coordinates.
+func (r *raster) text(x, y float64, dx, dy int, label string, c color.Color) {
+	px, py := r.pixel(x, y)
+	d := font.Drawer{Dst: r.img, Src: image.NewUniform(c), Face: basicfont.Face7x13, Dot: fixed.P(int(px)+dx, int(py)+dy)}
+	d.DrawString(label)
+}
+
+// WritePNG draws the scene at options.Scale px per cm.
+func WritePNG(w io.Writer, scene *Scene, options Options) error {
+	f := bounds(scene, options)
+	scale := float64(max(options.Scale, 1))
+	r := &raster{image.NewRGBA(image.Rect(0, 0, int(math.Ceil(f.width()*scale)), int(math.Ceil(f.height()*scale)))), f, scale}
+	draw.Draw(r.img, r.img.Bounds(), image.NewUniform(unknownColor), image.Point{}, draw.Src)
+	lineWidth := max(scale/2, 1) //px
+
+	if scene.Map != nil && options.Layers&LayerMap != 0 {
+		for py := 0; py < r.img.Bounds().Dy(); py++ {
+			yIndex := config.MapCenterY - int(math.Round(f.yMax-(float64(py)+0.5)/scale))
+			for px := 0; px < r.img.Bounds().Dx(); px++ {
+				xIndex := config.MapCenterX + int(math.Round(f.xMin+(float64(px)+0.5)/scale))
+				if xIndex < 0 || xIndex >= config.MapSize || yIndex < 0 || yIndex >= config.MapSize {
+					continue
+				}
+				switch scene.Map[xIndex][yIndex] {
+				case Open:
+					r.img.SetRGBA(px, py, openColor)
+				case Obstacle:
+					r.img.SetRGBA(px, py, obstacleColor)
+				}
+			}
+		}
+	}
+
+	if options.Layers&LayerAxes != 0 {
+		if f.yMin < 0 && f.yMax > 0 {
+			r.line(f.xMin, 0, f.xMax, 0, 1, axesColor)
+			r.text(f.xMax, 0, -2*basicfont.Face7x13.Advance, -4, "x", axesColor)
+		}
+		if f.xMin < 0 && f.xMax > 0 {
+			r.line(0, f.yMin, 0, f.yMax, 1, axesColor)
+			r.text(0, f.yMax, 4, basicfont.Face7x13.Height, "y", axesColor)
+		}
+		x, y, length := f.scaleBar()
+		for i := 0.0; i < 3; i++ {
+			r.line(x, y-i/scale, x+length, y-i/scale, 1, obstacleColor)
+		}
+		r.text(x, y, 0, -6, scaleLabel(length), obstacleColor)
+	}
+
+	for i, id := range robotIds(scene) {
+		c := robotColors[i%len(robotColors)]
+		if trajectory := scene.Trajectories[id]; options.Layers&LayerTrajectories != 0 {
+			for j := 1; j < len(trajectory); j++ {
+				r.line(trajectory[j-1][0], trajectory[j-1][1], trajectory[j][0], trajectory[j][1], lineWidth, c)
+			}
+		}
+		if goal, exist := scene.Goals[id]; options.Layers&LayerGoals != 0 && exist {
+			r.line(goal[0]-goalSize, goal[1]-goalSize, goal[0]+goalSize, goal[1]+goalSize, lineWidth, c)
+			r.line(goal[0]-goalSize, goal[1]+goalSize, goal[0]+goalSize, goal[1]-goalSize, lineWidth, c)
+		}
+		if pose, exist := scene.Poses[id]; options.Layers&LayerPoses != 0 && exist {
+			x, y, theta := pose[0], pose[1], pose[2]*math.Pi/180
+			px, py := r.pixel(x, y)
+			translucent := color.NRGBA{c.R, c.G, c.B, 0x50}
+			r.disk(px, py, config.RobotRadius*scale, translucent)
+			r.line(x, y, x+config.RobotRadius*math.Cos(theta), y+config.RobotRadius*math.Sin(theta), lineWidth, c)
+			r.text(x+config.RobotRadius, y, 4, 0, "NRF-"+strconv.Itoa(id), c)
+		}
+	}
+	return png.Encode(w, r.img)
+}
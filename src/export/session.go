@@ -0,0 +1,103 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"golang-server/log"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadSession loads a scene from a session directory written by the server: the map from map.png, the
+// trajectories and poses from trajectory.csv or trajectory.jsonl, and the goals from audit.jsonl. Files
+// that are missing are left out of the scene. A single map image or trajectory log can also be loaded.
+func LoadSession(path string) (*Scene, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	scene := &Scene{Trajectories: map[int][][2]float64{}, Poses: map[int][3]float64{}, Goals: map[int][2]float64{}}
+	if !info.IsDir() {
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".png":
+			scene.Map, err = LoadMapImage(path)
+		case ".csv", ".jsonl":
+			err = scene.loadTrajectory(path, ext[1:])
+		default:
+			err = fmt.Errorf("%s is not a map image or a trajectory log", path)
+		}
+		return scene, err
+	}
+
+	found := false
+	load := func(name string, loader func(path string) error) error {
+		err := loader(filepath.Join(path, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		found = true
+		return nil
+	}
+	loaders := []struct {
+		name   string
+		loader func(path string) error
+	}{
+		{"map.png", func(path string) (err error) { scene.Map, err = LoadMapImage(path); return }},
+		{"trajectory.csv", func(path string) error { return scene.loadTrajectory(path, "csv") }},
+		{"trajectory.jsonl", func(path string) error { return scene.loadTrajectory(path, "jsonl") }},
+		{"audit.jsonl", scene.loadGoals},
+	}
+	for _, l := range loaders {
+		if err := load(l.name, l.loader); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no map.png, trajectory log or audit log in %s", path)
+	}
+	return scene, nil
+}
+
+// loadTrajectory adds the positions of the robots in a trajectory log, and takes their poses from the
+// last rows. Positions closer than config.ExportTrajectoryStep to the one before are left out.
+func (scene *Scene) loadTrajectory(path, format string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	rows, err := log.ReadTrajectory(file, format)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		x, y := float64(row.X), float64(row.Y)
+		scene.Trajectories[row.Id] = AppendPosition(scene.Trajectories[row.Id], x, y)
+		scene.Poses[row.Id] = [3]float64{x, y, float64(row.Theta)}
+	}
+	return nil
+}
+
+// loadGoals takes the goal of each robot from the last goal in an audit log that was sent, manual or
+// automatic.
+func (scene *Scene) loadGoals(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	records, err := log.ReadAudit(file)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if (record.Command == "goal" || record.Command == "auto_goal") && record.Outcome == log.AuditSent && record.Map != nil {
+			scene.Goals[record.Robot] = [2]float64{float64(record.Map[0]), float64(record.Map[1])}
+		}
+	}
+	return nil
+}
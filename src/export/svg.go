@@ -0,0 +1,135 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"golang-server/config"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	obstacleColor = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	openColor     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	unknownColor  = color.RGBA{0x80, 0x80, 0x80, 0xff}
+	axesColor     = color.RGBA{0x40, 0x40, 0x40, 0xff}
+	//one color per robot, in the order of the robot ids
+	robotColors = []color.RGBA{
+		{0x1f, 0x77, 0xb4, 0xff}, {0xff, 0x7f, 0x0e, 0xff}, {0x2c, 0xa0, 0x2c, 0xff}, {0xd6, 0x27, 0x28, 0xff},
+		{0x94, 0x67, 0xbd, 0xff}, {0x8c, 0x56, 0x4b, 0xff}, {0xe3, 0x77, 0xc2, 0xff}, {0x17, 0xbe, 0xcf, 0xff},
+	}
+)
+
+const goalSize = 4 //cm, half the width of the cross at a goal
+
+// figure is the part of the map that is drawn, in cm.
+type figure struct {
+	xMin, yMin, xMax, yMax float64
+}
+
+func (f figure) width() float64  { return f.xMax - f.xMin }
+func (f figure) height() float64 { return f.yMax - f.yMin }
+
+// scaleBar returns where the scale bar starts, in map coordinates, and its length: a round length (1, 2
+// or 5 times a power of ten) about a fifth of the width.
+func (f figure) scaleBar() (x, y, length float64) {
+	target := f.width() / 5
+	length = math.Pow(10, math.Floor(math.Log10(target)))
+	if length*5 <= target {
+		length *= 5
+	} else if length*2 <= target {
+		length *= 2
+	}
+	return f.xMin + f.width()/20, f.yMin + f.height()/20, length
+}
+
+func scaleLabel(length float64) string {
+	if length >= 100 {
+		return strconv.FormatFloat(length/100, 'g', -1, 64) + " m"
+	}
+	return strconv.FormatFloat(length, 'g', -1, 64) + " cm"
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// WriteSVG draws the scene with one user unit per cm, and a size of options.Scale px per cm.
+func WriteSVG(w io.Writer, scene *Scene, options Options) error {
+	f := bounds(scene, options)
+	//svg y grows downward
+	sx := func(x float64) float64 { return x - f.xMin }
+	sy := func(y float64) float64 { return f.yMax - y }
+	fontSize := max(f.width(), f.height()) / 50
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`+"\n",
+		f.width()*float64(options.Scale), f.height()*float64(options.Scale), f.width(), f.height())
+	fmt.Fprintf(out, `<rect width="%g" height="%g" fill="%s"/>`+"\n", f.width(), f.height(), hex(unknownColor))
+
+	if scene.Map != nil && options.Layers&LayerMap != 0 {
+		//one rectangle per run of open or obstacle cells in a row
+		fmt.Fprintf(out, `<g shape-rendering="crispEdges">`+"\n")
+		for y := 0; y < config.MapSize; y++ {
+			for x := 0; x < config.MapSize; {
+				value, start := scene.Map[x][y], x
+				for x < config.MapSize && scene.Map[x][y] == value {
+					x++
+				}
+				xStart, yCell := cellCenter(start, y)
+				if value == Unknown || xStart+float64(x-start)-0.5 < f.xMin || xStart-0.5 > f.xMax || yCell+0.5 < f.yMin || yCell-0.5 > f.yMax {
+					continue
+				}
+				c := openColor
+				if value == Obstacle {
+					c = obstacleColor
+				}
+				fmt.Fprintf(out, `<rect x="%g" y="%g" width="%d" height="1" fill="%s"/>`+"\n", sx(xStart-0.5), sy(yCell+0.5), x-start, hex(c))
+			}
+		}
+		fmt.Fprintf(out, "</g>\n")
+	}
+
+	if options.Layers&LayerAxes != 0 {
+		axis := fmt.Sprintf(`stroke="%s" stroke-width="%g"`, hex(axesColor), fontSize/8)
+		if f.yMin < 0 && f.yMax > 0 {
+			fmt.Fprintf(out, `<line x1="0" y1="%g" x2="%g" y2="%g" %s/>`+"\n", sy(0), f.width(), sy(0), axis)
+			fmt.Fprintf(out, `<text x="%g" y="%g" font-size="%g" font-family="sans-serif" text-anchor="end" fill="%s">x</text>`+"\n", f.width()-fontSize/2, sy(0)-fontSize/2, fontSize, hex(axesColor))
+		}
+		if f.xMin < 0 && f.xMax > 0 {
+			fmt.Fprintf(out, `<line x1="%g" y1="0" x2="%g" y2="%g" %s/>`+"\n", sx(0), sx(0), f.height(), axis)
+			fmt.Fprintf(out, `<text x="%g" y="%g" font-size="%g" font-family="sans-serif" fill="%s">y</text>`+"\n", sx(0)+fontSize/2, fontSize, fontSize, hex(axesColor))
+		}
+		x, y, length := f.scaleBar()
+		fmt.Fprintf(out, `<rect x="%g" y="%g" width="%g" height="%g" fill="%s"/>`+"\n", sx(x), sy(y), length, fontSize/4, hex(obstacleColor))
+		fmt.Fprintf(out, `<text x="%g" y="%g" font-size="%g" font-family="sans-serif" fill="%s">%s</text>`+"\n", sx(x), sy(y)-fontSize/2, fontSize, hex(obstacleColor), scaleLabel(length))
+	}
+
+	for i, id := range robotIds(scene) {
+		c := hex(robotColors[i%len(robotColors)])
+		if trajectory := scene.Trajectories[id]; options.Layers&LayerTrajectories != 0 && len(trajectory) >= 2 {
+			points := make([]string, len(trajectory))
+			for j, p := range trajectory {
+				points[j] = fmt.Sprintf("%g,%g", sx(p[0]), sy(p[1]))
+			}
+			fmt.Fprintf(out, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%g" stroke-linejoin="round"/>`+"\n", strings.Join(points, " "), c, fontSize/6)
+		}
+		if goal, exist := scene.Goals[id]; options.Layers&LayerGoals != 0 && exist {
+			x, y := sx(goal[0]), sy(goal[1])
+			fmt.Fprintf(out, `<path d="M%g,%g L%g,%g M%g,%g L%g,%g" stroke="%s" stroke-width="%g"/>`+"\n",
+				x-goalSize, y-goalSize, x+goalSize, y+goalSize, x-goalSize, y+goalSize, x+goalSize, y-goalSize, c, fontSize/5)
+		}
+		if pose, exist := scene.Poses[id]; options.Layers&LayerPoses != 0 && exist {
+			x, y, theta := sx(pose[0]), sy(pose[1]), pose[2]*math.Pi/180
+			fmt.Fprintf(out, `<circle cx="%g" cy="%g" r="%d" fill="%s" fill-opacity="0.3" stroke="%s" stroke-width="%g"/>`+"\n", x, y, config.RobotRadius, c, c, fontSize/6)
+			fmt.Fprintf(out, `<line x1="%g" y1="%g" x2="%g" y2="%g" stroke="%s" stroke-width="%g"/>`+"\n",
+				x, y, x+config.RobotRadius*math.Cos(theta), y-config.RobotRadius*math.Sin(theta), c, fontSize/5)
+			fmt.Fprintf(out, `<text x="%g" y="%g" font-size="%g" font-family="sans-serif" fill="%s">NRF-%d</text>`+"\n", x+config.RobotRadius+fontSize/4, y, fontSize, c, id)
+		}
+	}
+	fmt.Fprintf(out, "</svg>\n")
+	return out.Flush()
+}
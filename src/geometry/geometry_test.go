@@ -0,0 +1,87 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 1e-9
+
+func closePose(a, b Pose2D) bool {
+	return math.Abs(a.X-b.X) < tolerance && math.Abs(a.Y-b.Y) < tolerance &&
+		math.Abs(float64(Rad(a.Theta-b.Theta).Normalize())) < tolerance
+}
+
+func TestUnits(t *testing.T) {
+	if Mm(1234).Cm() != 123.4 || Cm(250).M() != 2.5 || M(0.5).Mm() != 500 {
+		t.Error("Length conversion failed")
+	}
+	if Mm(-15).Cm().Round() != -2 || Mm(-14).Cm().Round() != -1 {
+		t.Error("Lengths must be rounded, not truncated")
+	}
+	if math.Abs(float64(Deg(180).Rad())-math.Pi) > tolerance || math.Abs(float64(Rad(math.Pi/2).Deg())-90) > tolerance {
+		t.Error("Angle conversion failed")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for angle, expected := range map[Deg]Deg{0: 0, 180: 180, -180: 180, 190: -170, 540: 180, -450: -90, 720.5: 0.5} {
+		if got := angle.Normalize(); math.Abs(float64(got-expected)) > tolerance {
+			t.Errorf("Normalize(%v): expected %v, got %v", angle, expected, got)
+		}
+	}
+	for angle, expected := range map[Deg]Deg{-90: 270, 360: 0, 450: 90, -1e-15: 0} {
+		if got := angle.Normalize360(); math.Abs(float64(got-expected)) > tolerance {
+			t.Errorf("Normalize360(%v): expected %v, got %v", angle, expected, got)
+		}
+	}
+	if got := Rad(3 * math.Pi).Normalize(); math.Abs(float64(got)-math.Pi) > tolerance {
+		t.Errorf("Expected pi, got %v", got)
+	}
+}
+
+func TestPoseApply(t *testing.T) {
+	//a robot at (10, 20) facing along the y axis sees a point 5 cm ahead and 1 cm to its right
+	robot := NewPose(10, 20, 90)
+	x, y := robot.Apply(Vec2{5, -1}).Round()
+	if x != 11 || y != 25 {
+		t.Errorf("Expected (11, 25), got (%d, %d)", x, y)
+	}
+	if v := robot.Transform().Apply(Vec2{5, -1}); math.Abs(v.X-11) > tolerance || math.Abs(v.Y-25) > tolerance {
+		t.Errorf("Transform2D and Pose2D disagree: %v", v)
+	}
+	if v := robot.Inverse().Apply(Vec2{11, 25}); math.Abs(v.X-5) > tolerance || math.Abs(v.Y+1) > tolerance {
+		t.Errorf("Expected (5, -1) back, got %v", v)
+	}
+}
+
+func TestPoseCompose(t *testing.T) {
+	a, b, c := NewPose(100, -50, 30), NewPose(-20, 7, 170), NewPose(3, 4, -100)
+	if got := a.Compose(b).Compose(c); !closePose(got, a.Compose(b.Compose(c))) {
+		t.Errorf("Composition is not associative: %+v", got)
+	}
+	if got := a.Compose(a.Inverse()); !closePose(got, Pose2D{}) {
+		t.Errorf("Expected the identity, got %+v", got)
+	}
+	if got := a.Compose(a.Between(b)); !closePose(got, b) {
+		t.Errorf("Expected %+v, got %+v", b, got)
+	}
+	if got := a.Transform().Compose(b.Transform()).Inverse().Pose(); !closePose(got, a.Compose(b).Inverse()) {
+		t.Errorf("Transform2D and Pose2D disagree: %+v", got)
+	}
+	//headings wrap around
+	if x, y, theta := NewPose(0, 0, 170).Compose(NewPose(0, 0, 20)).Round(); x != 0 || y != 0 || theta != -170 {
+		t.Errorf("Expected heading -170, got %d", theta)
+	}
+}
+
+// Composing many small steps does not drift, unlike rounding after every step.
+func TestPoseNoDrift(t *testing.T) {
+	pose, step := Pose2D{}, NewPose(Mm(3).Cm(), 0, 1)
+	for i := 0; i < 360; i++ {
+		pose = pose.Compose(step)
+	}
+	if !closePose(pose, Pose2D{}) {
+		t.Errorf("Expected a closed circle, got %+v", pose)
+	}
+}
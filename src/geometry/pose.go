@@ -0,0 +1,109 @@
+package geometry
+
+import "math"
+
+// Poses and transforms in the plane (SE(2)). Positions are in cm and angles in radians, counterclockwise
+// from the x axis. A pose of frame B given in frame A is also the transform of points from B to A: the
+// pose of a robot in the map takes points in the body frame of the robot to the map. Values are kept as
+// float64 and only rounded to whole cm or degrees where a map cell or an integer pose is needed, so the
+// rounding error of one step is not carried into the next.
+
+// Vec2 is a point or a displacement, in cm.
+type Vec2 struct {
+	X, Y float64
+}
+
+// VecMm returns the point (x, y) given in mm.
+func VecMm(x, y Mm) Vec2 {
+	return Vec2{float64(x.Cm()), float64(y.Cm())}
+}
+
+func (v Vec2) Add(w Vec2) Vec2      { return Vec2{v.X + w.X, v.Y + w.Y} }
+func (v Vec2) Sub(w Vec2) Vec2      { return Vec2{v.X - w.X, v.Y - w.Y} }
+func (v Vec2) Scale(f float64) Vec2 { return Vec2{f * v.X, f * v.Y} }
+func (v Vec2) Norm() Cm             { return Cm(math.Hypot(v.X, v.Y)) }
+
+// Rotate rotates the vector around the origin.
+func (v Vec2) Rotate(theta Rad) Vec2 {
+	c, s := math.Cos(float64(theta)), math.Sin(float64(theta))
+	return Vec2{c*v.X - s*v.Y, s*v.X + c*v.Y}
+}
+
+// Round returns the point in whole cm, e.g. map coordinates.
+func (v Vec2) Round() (int, int) {
+	return Cm(v.X).Round(), Cm(v.Y).Round()
+}
+
+// RoundMm returns the point in whole mm.
+func (v Vec2) RoundMm() (int, int) {
+	return Cm(v.X).Mm().Round(), Cm(v.Y).Mm().Round()
+}
+
+// Pose2D is a position [cm] and heading [rad] in a frame.
+type Pose2D struct {
+	X, Y, Theta float64
+}
+
+// NewPose returns the pose at (x, y) with heading theta, normalized.
+func NewPose(x, y Cm, theta Deg) Pose2D {
+	return Pose2D{float64(x), float64(y), float64(theta.Rad().Normalize())}
+}
+
+func (p Pose2D) Position() Vec2 { return Vec2{p.X, p.Y} }
+
+// Heading returns Theta in degrees.
+func (p Pose2D) Heading() Deg { return Rad(p.Theta).Deg() }
+
+// Round returns the pose in whole cm and degrees.
+func (p Pose2D) Round() (x, y, theta int) {
+	return Cm(p.X).Round(), Cm(p.Y).Round(), p.Heading().Round()
+}
+
+// Apply transforms a point from the frame of the pose to the frame the pose is given in.
+func (p Pose2D) Apply(v Vec2) Vec2 {
+	return v.Rotate(Rad(p.Theta)).Add(p.Position())
+}
+
+// Compose returns child, given in the frame of p, in the frame p is given in.
+func (p Pose2D) Compose(child Pose2D) Pose2D {
+	position := p.Apply(child.Position())
+	return Pose2D{position.X, position.Y, float64(Rad(p.Theta + child.Theta).Normalize())}
+}
+
+// Inverse returns the pose of the parent frame in the frame of p.
+func (p Pose2D) Inverse() Pose2D {
+	position := p.Position().Rotate(Rad(-p.Theta))
+	return Pose2D{-position.X, -position.Y, float64(Rad(-p.Theta).Normalize())}
+}
+
+// Between returns q relative to p, so that p.Compose(p.Between(q)) is q.
+func (p Pose2D) Between(q Pose2D) Pose2D {
+	return p.Inverse().Compose(q)
+}
+
+// Transform2D is a Pose2D with its rotation computed once, to transform many points, e.g. every cell
+// of a map.
+type Transform2D struct {
+	pose     Pose2D
+	cos, sin float64
+}
+
+func (p Pose2D) Transform() Transform2D {
+	return Transform2D{p, math.Cos(p.Theta), math.Sin(p.Theta)}
+}
+
+func (t Transform2D) Pose() Pose2D { return t.pose }
+
+// Apply transforms a point from the child frame to the parent frame.
+func (t Transform2D) Apply(v Vec2) Vec2 {
+	return Vec2{t.cos*v.X - t.sin*v.Y + t.pose.X, t.sin*v.X + t.cos*v.Y + t.pose.Y}
+}
+
+// Compose returns the transform that applies child first, then t.
+func (t Transform2D) Compose(child Transform2D) Transform2D {
+	return t.pose.Compose(child.pose).Transform()
+}
+
+func (t Transform2D) Inverse() Transform2D {
+	return t.pose.Inverse().Transform()
+}
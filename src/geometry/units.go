@@ -0,0 +1,66 @@
+package geometry
+
+import "math"
+
+// Lengths and angles in the units used by the robots (mm, degrees), the map (cm, degrees) and the
+// statistics (m). Converting through these types keeps the unit of a value visible, e.g.
+// Mm(msg.X).Cm() instead of msg.X / 10, which also truncates.
+
+type Mm float64
+type Cm float64
+type M float64
+type Deg float64
+type Rad float64
+
+func (v Mm) Cm() Cm { return Cm(v / 10) }
+func (v Mm) M() M   { return M(v / 1000) }
+func (v Cm) Mm() Mm { return Mm(v * 10) }
+func (v Cm) M() M   { return M(v / 100) }
+func (v M) Mm() Mm  { return Mm(v * 1000) }
+func (v M) Cm() Cm  { return Cm(v * 100) }
+
+func (a Deg) Rad() Rad { return Rad(a * math.Pi / 180) }
+func (a Rad) Deg() Deg { return Deg(a * 180 / math.Pi) }
+
+// Round returns the length in whole mm.
+func (v Mm) Round() int { return int(math.Round(float64(v))) }
+
+// Round returns the length in whole cm, e.g. map coordinates.
+func (v Cm) Round() int { return int(math.Round(float64(v))) }
+
+// Round returns the angle in whole degrees.
+func (a Deg) Round() int { return int(math.Round(float64(a))) }
+
+// Normalize returns the angle in (-180, 180].
+func (a Deg) Normalize() Deg {
+	a = Deg(math.Mod(float64(a), 360))
+	if a > 180 {
+		a -= 360
+	} else if a <= -180 {
+		a += 360
+	}
+	return a
+}
+
+// Normalize360 returns the angle in [0, 360), e.g. a heading.
+func (a Deg) Normalize360() Deg {
+	a = Deg(math.Mod(float64(a), 360))
+	if a < 0 {
+		a += 360
+	}
+	if a >= 360 {
+		a = 0 //a tiny negative angle rounds to 360
+	}
+	return a
+}
+
+// Normalize returns the angle in (-pi, pi].
+func (a Rad) Normalize() Rad {
+	a = Rad(math.Mod(float64(a), 2*math.Pi))
+	if a > math.Pi {
+		a -= 2 * math.Pi
+	} else if a <= -math.Pi {
+		a += 2 * math.Pi
+	}
+	return a
+}
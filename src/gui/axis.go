@@ -12,9 +12,10 @@ import (
 type mapAxis struct {
 	xAxis, yAxis *canvas.Line
 	xText, yText *canvas.Text
+	viewport     *mapViewport
 }
 
-func initMapAxis() *mapAxis {
+func initMapAxis(viewport *mapViewport) *mapAxis {
 	xAxis := canvas.NewLine(orangeT)
 	xAxis.Position1 = fyne.NewPos(0, config.MapCenterY)
 	xAxis.Position2 = fyne.NewPos(config.MapSize, config.MapCenterY)
@@ -24,29 +25,23 @@ func initMapAxis() *mapAxis {
 	xText := canvas.NewText("x="+strconv.Itoa(config.MapSize-config.MapCenterX), darkRed)
 	yText := canvas.NewText("y="+strconv.Itoa(config.MapSize-config.MapCenterY), darkRed)
 
-	return &mapAxis{xAxis, yAxis, xText, yText}
+	return &mapAxis{xAxis, yAxis, xText, yText, viewport}
 }
 
 // Layout is called to pack all child objects into a specified size.
 func (m *mapAxis) Layout(objects []fyne.CanvasObject, size fyne.Size) {
-	//The map is square and centered, but we must offset the position of the lines relative to the top left corner of the container
-	dx, dy := float32(0), float32(0)
-	if size.Height > size.Width {
-		dy += (size.Height - size.Width) / 2
-	} else {
-		dx += (size.Width - size.Height) / 2
-	}
-	currentMapSize := min(size.Height, size.Width)
-	currentRatio := currentMapSize / float32(config.MapSize)
-
-	m.xAxis.Position1 = fyne.NewPos(dx, config.MapCenterY*currentRatio+dy)
-	m.xAxis.Position2 = fyne.NewPos(currentMapSize+dx, config.MapCenterY*currentRatio+dy)
-
-	m.yAxis.Position1 = fyne.NewPos(config.MapCenterX*currentRatio+dx, dy)
-	m.yAxis.Position2 = fyne.NewPos(config.MapCenterX*currentRatio+dx, currentMapSize+dy)
-
-	m.xText.Move(fyne.NewPos(currentMapSize+dx-43, config.MapCenterY*currentRatio+dy))
-	m.yText.Move(fyne.NewPos(config.MapCenterX*currentRatio+3+dx, dy+1))
+	//The axes span the map, and follow the zoom and pan of the viewport
+	xMin, xMax := float32(-config.MapCenterX), float32(config.MapSize-config.MapCenterX)
+	yMin, yMax := float32(config.MapCenterY-config.MapSize), float32(config.MapCenterY)
+
+	m.xAxis.Position1 = m.viewport.mapToScreen(size, xMin, 0)
+	m.xAxis.Position2 = m.viewport.mapToScreen(size, xMax, 0)
+
+	m.yAxis.Position1 = m.viewport.mapToScreen(size, 0, yMax)
+	m.yAxis.Position2 = m.viewport.mapToScreen(size, 0, yMin)
+
+	m.xText.Move(m.xAxis.Position2.SubtractXY(43, 0))
+	m.yText.Move(m.yAxis.Position1.AddXY(3, 1))
 }
 
 // MinSize finds the smallest size that satisfies all the child objects.
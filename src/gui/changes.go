@@ -0,0 +1,44 @@
+package gui
+
+import (
+	"golang-server/config"
+	"golang-server/types"
+	"image"
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+)
+
+var (
+	appearedColor = color.RGBA{0xff, 0xd7, 0x00, 0xff} //gold
+	vanishedColor = color.RGBA{0x00, 0xbf, 0xff, 0xff} //light blue
+)
+
+// changeOverlay highlights the cells where the map changed between open and obstacle, so moving
+// obstacles can be told apart from the static map. See backend/dynamic.go.
+type changeOverlay struct {
+	image     *image.RGBA //one pixel per map cell, transparent where nothing changed
+	container *fyne.Container
+}
+
+func initChangeOverlay(viewport *mapViewport) *changeOverlay {
+	overlay := &changeOverlay{image: image.NewRGBA(image.Rect(0, 0, config.MapSize, config.MapSize))}
+	overlay.container = container.NewStack(canvas.NewRaster(renderCells(viewport, overlay.image)))
+	return overlay
+}
+
+// setChanges applies the changes since the last update. The overlay is redrawn with the map.
+func (o *changeOverlay) setChanges(changes []types.MapChange) {
+	for _, change := range changes {
+		var c color.RGBA //transparent
+		switch change.Kind {
+		case types.ChangeAppeared:
+			c = appearedColor
+		case types.ChangeVanished:
+			c = vanishedColor
+		}
+		o.image.SetRGBA(change.X, change.Y, c)
+	}
+}
@@ -0,0 +1,38 @@
+package gui
+
+import (
+	"golang-server/config"
+	"image"
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+)
+
+const costUnknown = 255 //see backend/costmap.go
+
+// costmapOverlay shows the cost of each cell for the default robot radius, red over the obstacles and
+// their inflation, fading with the cost. Free and unknown cells are transparent. See backend/costmap.go.
+type costmapOverlay struct {
+	image     *image.RGBA //one pixel per map cell
+	container *fyne.Container
+}
+
+func initCostmapOverlay(viewport *mapViewport) *costmapOverlay {
+	overlay := &costmapOverlay{image: image.NewRGBA(image.Rect(0, 0, config.MapSize, config.MapSize))}
+	overlay.container = container.NewStack(canvas.NewRaster(renderCells(viewport, overlay.image)))
+	overlay.container.Hide()
+	return overlay
+}
+
+// setCosts replaces the costs, indexed by x*config.MapSize+y. The overlay is redrawn with the map.
+func (o *costmapOverlay) setCosts(costs []uint8) {
+	for i, cost := range costs {
+		var c color.NRGBA //transparent
+		if cost != costUnknown {
+			c = color.NRGBA{0xff, 0x30, 0x30, uint8(int(cost) * 0xa0 / 0xfe)}
+		}
+		o.image.Set(i/config.MapSize, i%config.MapSize, c)
+	}
+}
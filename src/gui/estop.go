@@ -0,0 +1,99 @@
+package gui
+
+import (
+	"golang-server/config"
+	"golang-server/types"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// motionControls are the emergency stop, pause and resume buttons for all robots, and the motion
+// state reported by the backend. The stop is published to the robots right away, and so is the pause
+// unless config.UseOperatorRoles asks the backend to check it first. Every command is then queued for the
+// backend, which records it and sets the motion state, so a busy backend never delays the stop or
+// freezes the gui.
+type motionControls struct {
+	mu               sync.Mutex
+	robots           map[int]bool //every robot the gui has heard of
+	status           *canvas.Text
+	container        *fyne.Container
+	queue            chan types.Command
+	chPublishControl chan<- types.PublishMsg
+}
+
+func initMotionControls(chG2bCommand chan<- types.Command, chPublishControl chan<- types.PublishMsg) *motionControls {
+	m := &motionControls{robots: map[int]bool{}, queue: make(chan types.Command, 16), chPublishControl: chPublishControl}
+	go func() {
+		for command := range m.queue {
+			chG2bCommand <- command
+		}
+	}()
+	estop := widget.NewButtonWithIcon("E-STOP (Esc)", theme.MediaStopIcon(), m.stop)
+	estop.Importance = widget.DangerImportance
+	pause := widget.NewButtonWithIcon("Pause all", theme.MediaPauseIcon(), func() { m.send(types.PauseCommand) })
+	resume := widget.NewButtonWithIcon("Resume", theme.MediaPlayIcon(), func() { m.send(types.ResumeCommand) })
+	m.status = canvas.NewText("", red)
+	m.status.TextStyle = fyne.TextStyle{Bold: true}
+	m.container = container.NewHBox(estop, pause, resume, m.status)
+	return m
+}
+
+// stop is the emergency stop, also bound to the Escape key.
+func (m *motionControls) stop() {
+	m.send(types.StopCommand)
+}
+
+// addRobot makes the stop reach the robot, also before it is initialized.
+func (m *motionControls) addRobot(id int) {
+	m.mu.Lock()
+	m.robots[id] = true
+	m.mu.Unlock()
+}
+
+func (m *motionControls) send(commandType int) {
+	switch {
+	case commandType == types.StopCommand:
+		m.publish(types.PublishStop)
+	case commandType == types.PauseCommand && !config.UseOperatorRoles:
+		m.publish(types.PublishPause)
+	}
+	//logged by the backend
+	select {
+	case m.queue <- types.Command{CommandType: commandType, Id: -1, Source: types.SourceGui, Operator: operator}:
+	default:
+		logger.Error("Too many motion commands queued, command dropped", "command", commandType)
+	}
+}
+
+// publish sends a stop or pause to every robot without waiting. If the channel is full, the backend
+// publishes it when it handles the command.
+func (m *motionControls) publish(kind uint8) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.robots {
+		select {
+		case m.chPublishControl <- types.PublishMsg{Kind: kind, Id: id}:
+		default:
+			logger.Error("Control channel full, the backend publishes the command instead", "robot", id)
+		}
+	}
+}
+
+func (m *motionControls) setMotion(motion int) {
+	text := ""
+	switch motion {
+	case types.MotionStopped:
+		text = "STOPPED"
+	case types.MotionPaused:
+		text = "PAUSED"
+	}
+	if text != m.status.Text {
+		m.status.Text = text
+		m.status.Refresh()
+	}
+}
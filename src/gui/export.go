@@ -0,0 +1,37 @@
+package gui
+
+import (
+	"golang-server/export"
+	"golang-server/types"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// initExportTab lets the operator save the map and what the robots did as SVG, PNG or GeoJSON. The
+// backend writes the file, and tells when it is done.
+func initExportTab(w fyne.Window, chG2bExport chan<- types.ExportRequest) *fyne.Container {
+	format := widget.NewSelect(export.Formats, nil)
+	format.SetSelected(export.Formats[0])
+	layers := widget.NewCheckGroup(export.LayerNames(), nil)
+	layers.SetSelected(export.LayerNames())
+	crop := widget.NewCheck("Crop to the explored area", nil)
+	crop.SetChecked(true)
+	save := widget.NewButton("Export...", func() {
+		request := types.ExportRequest{Format: format.Selected, Layers: layers.Selected, Crop: crop.Checked}
+		save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			request.Writer, request.Name = writer, writer.URI().Name()
+			chG2bExport <- request
+			logger.Info("Export requested", "file", writer.URI().Path(), "format", request.Format, "layers", strings.Join(request.Layers, ","))
+		}, w)
+		save.SetFileName("map." + request.Format)
+		save.Show()
+	})
+	return container.NewVBox(widget.NewLabel("Format"), format, widget.NewLabel("Layers"), layers, crop, save)
+}
@@ -0,0 +1,31 @@
+//go:build linux || windows
+
+package gui
+
+import (
+	"golang-server/config"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// pollGamepad reads the first gamepad through GLFW, which Fyne uses for its window. The left stick drives
+// while the left bumper is held. GLFW must be initialized, so this is started when the app has started.
+// GLFW asks for joysticks to be polled from the main thread, which Fyne owns and gives no way to run on.
+// Polling from another goroutine works with the Linux and Windows backends, so only they are built with it.
+func pollGamepad(t *teleop) {
+	ticker := time.NewTicker(time.Second / config.TeleopRate)
+	for range ticker.C {
+		linear, angular := 0.0, 0.0
+		joystick := glfw.Joystick1
+		if joystick.Present() && joystick.IsGamepad() {
+			state := joystick.GetGamepadState()
+			if state != nil && state.Buttons[glfw.ButtonLeftBumper] == glfw.Press {
+				//stick up and left are negative
+				linear = -gamepadDeadzone(float64(state.Axes[glfw.AxisLeftY]))
+				angular = -gamepadDeadzone(float64(state.Axes[glfw.AxisLeftX]))
+			}
+		}
+		t.setGamepad(linear, angular)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package gui
+
+// pollGamepad does nothing in the browser, where GLFW is not available, and on the platforms where
+// polling GLFW joysticks outside the main thread has not been verified, see gamepad.go.
+func pollGamepad(t *teleop) {
+	logger.Info("Gamepad not supported on this platform, teleop uses the keyboard only")
+}
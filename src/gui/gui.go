@@ -1,23 +1,28 @@
 package gui
 
 import (
+	"fmt"
 	"golang-server/config"
 	"golang-server/log"
+	"golang-server/metrics"
 	"golang-server/types"
 	"image"
 	"image/color"
 	"strconv"
 	"time"
 	"math"
+	"os/user"
 	"sync"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
-	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/widget"
 )
 
+var logger = log.Component("gui")
+
 var (
 	red     = color.RGBA{0xff, 0x00, 0x00, 0xff}
 	green   = color.RGBA{0x00, 0xff, 0xff, 0xff}
@@ -29,6 +34,19 @@ var (
 	black   = color.Black
 )
 
+// operator is recorded with every command from the gui, see config.GuiOperator.
+var operator = guiOperator()
+
+func guiOperator() string {
+	if config.GuiOperator != "" {
+		return config.GuiOperator
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
 var (
 	lastMultiRobot []types.RobotState
 	lastId2Index   map[int]int
@@ -38,12 +56,37 @@ var (
 
 func InitGui(
 	chG2bCommand chan<- types.Command,
-) (fyne.Window, *image.RGBA, *canvas.Image, *multiRobotHandle, *container.AppTabs, *container.AppTabs) {
+	chG2bZones chan<- []types.Zone,
+	chG2bExport chan<- types.ExportRequest,
+	chPublishControl chan<- types.PublishMsg,
+) (fyne.Window, *image.RGBA, *mapView, *multiRobotHandle, *poseGraphOverlay, *teleop, *motionControls, *statsPanel, *robotPanels, *container.AppTabs, *container.AppTabs) {
 
 	a := app.New()
 	w := a.NewWindow("Canvas")
 	w.Resize(fyne.NewSize(config.WindowBreadth, config.WindowHeight))
 
+	//teleop initialization, the gamepad can only be read when the window is running
+	teleop := initTeleop(w, chG2bCommand)
+	a.Lifecycle().SetOnStarted(func() {
+		go teleop.run()
+		go pollGamepad(teleop)
+	})
+	//key releases are not seen while another application has the focus
+	a.Lifecycle().SetOnExitedForeground(teleop.releaseKeys)
+
+	//emergency stop, keys are only seen when no widget has the keyboard focus
+	motion := initMotionControls(chG2bCommand, chPublishControl)
+	if deskCanvas, ok := w.Canvas().(desktop.Canvas); ok {
+		deskCanvas.SetOnKeyDown(func(ev *fyne.KeyEvent) {
+			if ev.Name == fyne.KeyEscape {
+				motion.stop()
+			}
+			teleop.setKey(ev.Name, true)
+		})
+		deskCanvas.SetOnKeyUp(func(ev *fyne.KeyEvent) { teleop.setKey(ev.Name, false) })
+		w.Canvas().SetOnTypedKey(func(ev *fyne.KeyEvent) { teleop.repeatKey(ev.Name) })
+	}
+
 	//map initialization
 	mapShape := image.Rect(0, 0, config.MapSize, config.MapSize)
 	mapImage := image.NewRGBA(mapShape)
@@ -52,63 +95,120 @@ func InitGui(
 			mapImage.Set(x, y, gray)
 		}
 	}
-	mapCanvas := canvas.NewImageFromImage(mapImage)
-	mapCanvas.FillMode = canvas.ImageFillContain
-	mapCanvas.SetMinSize(fyne.NewSize(config.MapMinimumDisplaySize, config.MapMinimumDisplaySize))
+	//all map layers are drawn through the same viewport
+	viewport := initMapViewport()
 
 	//robot initialization
-	allRobotsHandle := initMultiRobotHandle()
+	allRobotsHandle := initMultiRobotHandle(viewport)
+
+	//overlay initialization
+	graphOverlay := initPoseGraphOverlay(viewport)
+
+	//exploration statistics
+	stats := initStatsPanel()
+
+	//robot panels, opened from the Manual tab or by tapping a robot
+	panels := initRobotPanels()
 
 	//input initialization
 	manualInput := container.NewAppTabs()
 	automaticInput := initAutoInput(chG2bCommand)
 	initInput := container.NewAppTabs()
+
+	//map axis initialization
+	axis := initMapAxis(viewport)
+	axisContainer := container.New(axis, axis.xAxis, axis.yAxis, axis.xText, axis.yText)
+
+	//merging into one interactive map, tapping it sends a goal
+	mapWithRobots := initMapView(viewport, mapImage, axisContainer, graphOverlay.container, allRobotsHandle.container)
+	mapWithRobots.onTap = func(x, y int) {
+		sendGoalFromMap(chG2bCommand, mapWithRobots.toolbar, x, y)
+	}
+	mapWithRobots.onRobot = panels.show
 	inputTabs := container.NewAppTabs(
 		container.NewTabItem("Init", initInput),
 		container.NewTabItem("Automatic", automaticInput),
 		container.NewTabItem("Manual", manualInput),
+		container.NewTabItem("Zones", initZonesTab(w, mapWithRobots, chG2bZones)),
+		container.NewTabItem("View", initViewTab(graphOverlay, mapWithRobots.changes, mapWithRobots.costmap)),
+		container.NewTabItem("Stats", stats.container),
+		container.NewTabItem("Export", initExportTab(w, chG2bExport)),
 	)
-
-	//map axis initialization
-	axis := initMapAxis()
-	axisContainer := container.New(axis, axis.xAxis, axis.yAxis, axis.xText, axis.yText)
-
-	//merging into one container
-	mapWithRobots := container.NewStack(mapCanvas, axisContainer, allRobotsHandle.container)
-	InputAndMap := container.NewHSplit(inputTabs, mapWithRobots)
+	mapWithToolbar := container.NewBorder(container.NewVBox(motion.container, mapWithRobots.toolbar.container), nil, nil, nil, mapWithRobots)
+	InputAndMap := container.NewHSplit(inputTabs, mapWithToolbar)
 	w.SetContent(InputAndMap)
 
-	return w, mapImage, mapCanvas, allRobotsHandle, manualInput, initInput
+	return w, mapImage, mapWithRobots, allRobotsHandle, graphOverlay, teleop, motion, stats, panels, manualInput, initInput
 }
 
 func ThreadGuiUpdate(
 	mapImage *image.RGBA,
-	mapCanvas *canvas.Image,
+	mapView *mapView,
 	allRobotsHandle *multiRobotHandle,
+	graphOverlay *poseGraphOverlay,
+	teleop *teleop,
+	motion *motionControls,
+	stats *statsPanel,
+	panels *robotPanels,
 	manualInput *container.AppTabs,
 	initInput *container.AppTabs,
 	chG2bCommand chan<- types.Command,
 	chG2bRobotInit chan<- [4]int,
 	chB2gRobotPendingInit <-chan int,
 	chB2gUpdate <-chan types.UpdateGui,
+	chB2gMergeProposal <-chan types.MergeProposal,
+	chG2bMergeReject chan<- int,
+	chB2gNotice <-chan string,
 ) {
 	chRobotGuiInit := make(chan [4]int, 3)
+	mergeBoxes := map[int]*fyne.Container{} //robot id -> box showing the latest map alignment
 	for {
 		select {
 		case partialState := <-chB2gUpdate:
-			redrawMap(mapImage, partialState.NewOpen, partialState.NewObstacle)
-			mapCanvas.Refresh()
+			start := time.Now()
+			redrawMap(mapImage, partialState.NewOpen, partialState.NewObstacle, partialState.NewUnknown)
+			mapView.changes.setChanges(partialState.Changes)
+			if partialState.Costmap != nil {
+				mapView.costmap.setCosts(partialState.Costmap)
+			}
+			mapView.followRobot(partialState.MultiRobot, partialState.Id2index)
+			if partialState.PoseGraph != nil {
+				graphOverlay.setView(*partialState.PoseGraph)
+			}
+			if partialState.ZonesChanged {
+				mapView.zones.setZones(partialState.Zones)
+			}
+			mapView.traffic.setConflicts(partialState.Traffic)
 			redrawRobots(allRobotsHandle, partialState.MultiRobot, partialState.Id2index)
+			stateMu.Lock()
+			lastMultiRobot, lastId2Index = partialState.MultiRobot, partialState.Id2index
+			stateMu.Unlock()
+			mapView.refreshLayers()
+			motion.setMotion(partialState.Motion)
+			stats.setStats(partialState.Coverage)
+			panels.setTelemetry(partialState.Telemetry)
+			metrics.GuiFrameSeconds.Observe(time.Since(start).Seconds())
 		case idPending := <-chB2gRobotPendingInit:
-			initInput.Append(container.NewTabItem("NRF-"+strconv.Itoa(idPending), initInitializationInputTab(chG2bRobotInit, chRobotGuiInit, idPending)))
+			motion.addRobot(idPending)
+			mergeBoxes[idPending] = container.NewVBox()
+			initTab := container.NewVBox(initInitializationInputTab(mapView, chG2bRobotInit, chRobotGuiInit, idPending), mergeBoxes[idPending])
+			initInput.Append(container.NewTabItem("NRF-"+strconv.Itoa(idPending), initTab))
+		case proposal := <-chB2gMergeProposal:
+			if box, exist := mergeBoxes[proposal.Id]; exist {
+				showMergeProposal(box, proposal, chG2bRobotInit, chRobotGuiInit, chG2bMergeReject)
+			}
 		case init := <-chRobotGuiInit:
 			id := init[0]
+			delete(mergeBoxes, id)
 			for i := 0; i < len(initInput.Items); i++ {
 				if initInput.Items[i].Text == "NRF-"+strconv.Itoa(id) {
 					initInput.Remove(initInput.Items[i])
 				}
 			}
-			manualInput.Append(container.NewTabItem("NRF-"+strconv.Itoa(id), initManualInputTab(chG2bCommand, id)))
+			manualInput.Append(container.NewTabItem("NRF-"+strconv.Itoa(id), initManualInputTab(mapView, teleop, panels, chG2bCommand, chG2bRobotInit, id)))
+			mapView.toolbar.addRobot(id)
+		case notice := <-chB2gNotice:
+			mapView.toolbar.showNotice(notice)
 		}
 	}
 }
@@ -128,12 +228,16 @@ func redrawRobots(allRobotsHandle *multiRobotHandle, backendMultiRobot []types.R
 	}
 	for i := 0; i < backendNumRobots; i++ {
 		allRobotsHandle.setPoseLabel(i, backendMultiRobot[i].X, backendMultiRobot[i].Y, backendMultiRobot[i].Theta)
-		allRobotsHandle.Move(i, fyne.NewPos(float32(backendMultiRobot[i].X), -float32(backendMultiRobot[i].Y)))
+		allRobotsHandle.Move(i, float32(backendMultiRobot[i].X), float32(backendMultiRobot[i].Y))
 		allRobotsHandle.Rotate(i, float64(backendMultiRobot[i].Theta))
 	}
 }
 
-func redrawMap(mapImage *image.RGBA, newOpen [][2]int, newObstacle [][2]int) {
+func redrawMap(mapImage *image.RGBA, newOpen [][2]int, newObstacle [][2]int, newUnknown [][2]int) {
+	//unknown first, since a rebuilt map resets cells before they are set again
+	for _, point := range newUnknown {
+		mapImage.Set(point[0], point[1], gray)
+	}
 	for _, point := range newOpen {
 		mapImage.Set(point[0], point[1], white)
 	}
@@ -142,7 +246,7 @@ func redrawMap(mapImage *image.RGBA, newOpen [][2]int, newObstacle [][2]int) {
 	}
 }
 
-func initInitializationInputTab(chG2bRobotInit, chRobotGuiInit chan<- [4]int, id int) *fyne.Container {
+func initInitializationInputTab(mapView *mapView, chG2bRobotInit, chRobotGuiInit chan<- [4]int, id int) *fyne.Container {
 	inputX := widget.NewEntry()
 	inputX.SetPlaceHolder("x [cm]")
 
@@ -161,11 +265,9 @@ func initInitializationInputTab(chG2bRobotInit, chRobotGuiInit chan<- [4]int, id
 			//send to backend [cm, cm, degrees]
 			chG2bRobotInit <- [4]int{id, x, y, theta}
 			chRobotGuiInit <- [4]int{id, x, y, theta}
-			log.GGeneralLogger.Println("Initializing robot with ID: ", id, " x: ", x, " y: ", y, " theta: ", theta, ".")
+			logger.Info("Initializing robot", "robot", id, "x", x, "y", y, "theta", theta)
 		} else {
-			println("Invalid input")
-			log.GGeneralLogger.Println("Invalid input. Only integers are allowed.")
-
+			logger.Warn("Invalid input. Only integers are allowed.")
 		}
 	})
 
@@ -176,10 +278,70 @@ func initInitializationInputTab(chG2bRobotInit, chRobotGuiInit chan<- [4]int, id
 
 	})
 
-	initContainer := container.NewVBox(inputX, inputY, inputTheta, inputButton, defaultButton)
+	mapButton := widget.NewButton("Set pose on map", func() {
+		mapView.startPoseEstimate(id, func(x, y, theta int) {
+			chG2bRobotInit <- [4]int{id, x, y, theta}
+			chRobotGuiInit <- [4]int{id, x, y, theta}
+			logger.Info("Initializing robot from map", "robot", id, "x", x, "y", y, "theta", theta)
+		})
+	})
+
+	initContainer := container.NewVBox(inputX, inputY, inputTheta, inputButton, defaultButton, mapButton)
 	return initContainer
 }
 
+func initViewTab(graphOverlay *poseGraphOverlay, changes *changeOverlay, costmap *costmapOverlay) *fyne.Container {
+	showGraph := widget.NewCheck("Show pose graph", func(checked bool) {
+		if checked {
+			graphOverlay.container.Show()
+		} else {
+			graphOverlay.container.Hide()
+		}
+	})
+	showChanges := widget.NewCheck("Show map changes", func(checked bool) {
+		if checked {
+			changes.container.Show()
+		} else {
+			changes.container.Hide()
+		}
+	})
+	showChanges.SetChecked(config.UseChangeDetection)
+	showCostmap := widget.NewCheck("Show costmap", func(checked bool) {
+		if checked {
+			costmap.container.Show()
+		} else {
+			costmap.container.Hide()
+		}
+	})
+	if !config.UseCostmap {
+		showCostmap.Disable()
+	}
+	return container.NewVBox(showGraph, showChanges, showCostmap)
+}
+
+// showMergeProposal lets the operator accept or reject the initial pose found by map merging.
+func showMergeProposal(
+	box *fyne.Container,
+	proposal types.MergeProposal,
+	chG2bRobotInit, chRobotGuiInit chan<- [4]int,
+	chG2bMergeReject chan<- int,
+) {
+	label := widget.NewLabel(fmt.Sprintf("Map match: [%d, %d, %d]\nConfidence: %.0f%%", proposal.X, proposal.Y, proposal.Theta, proposal.Confidence*100))
+	acceptButton := widget.NewButton("Accept alignment", func() {
+		init := [4]int{proposal.Id, proposal.X, proposal.Y, proposal.Theta}
+		chG2bRobotInit <- init
+		chRobotGuiInit <- init
+		logger.Info("Initializing robot from map alignment", "robot", proposal.Id, "x", proposal.X, "y", proposal.Y, "theta", proposal.Theta)
+	})
+	rejectButton := widget.NewButton("Reject alignment", func() {
+		chG2bMergeReject <- proposal.Id
+		box.Objects = nil
+		box.Refresh()
+	})
+	box.Objects = []fyne.CanvasObject{widget.NewSeparator(), label, acceptButton, rejectButton}
+	box.Refresh()
+}
+
 func initAutoInput(chG2bCommand chan<- types.Command) *fyne.Container {
 	inputX := widget.NewEntry()
 	inputX.SetPlaceHolder("x [cm]")
@@ -189,16 +351,15 @@ func initAutoInput(chG2bCommand chan<- types.Command) *fyne.Container {
 		x, errX := strconv.Atoi(inputX.Text)
 		y, errY := strconv.Atoi(inputY.Text)
 		if errX == nil && errY == nil {
-			chG2bCommand <- types.Command{CommandType: types.AutomaticCommand, Id: -1, X: x, Y: y}
+			chG2bCommand <- types.Command{CommandType: types.AutomaticCommand, Id: -1, X: x, Y: y, Source: types.SourceGui, Operator: operator}
 		} else {
-			println("Invalid input")
-			log.GGeneralLogger.Println("Invalid input. Only integers are allowed.")
+			logger.Warn("Invalid input. Only integers are allowed.")
 		}
 	}))
 	return automaticContainer
 }
 
-func initManualInputTab(chG2bCommand chan<- types.Command, id int) *fyne.Container {
+func initManualInputTab(mapView *mapView, teleop *teleop, panels *robotPanels, chG2bCommand chan<- types.Command, chG2bRobotInit chan<- [4]int, id int) *fyne.Container {
 	inputX := widget.NewEntry()
 	inputX.SetPlaceHolder("x [cm]")
 	inputY := widget.NewEntry()
@@ -208,12 +369,19 @@ func initManualInputTab(chG2bCommand chan<- types.Command, id int) *fyne.Contain
 		x, errX := strconv.Atoi(inputX.Text)
 		y, errY := strconv.Atoi(inputY.Text)
 		if errX == nil && errY == nil {
-			chG2bCommand <- types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y}
+			chG2bCommand <- types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y, Source: types.SourceGui, Operator: operator}
 		} else {
-			println("Invalid input")
-			log.GGeneralLogger.Println("Invalid input. Only integers are allowed.")
+			logger.Warn("Invalid input. Only integers are allowed.")
 		}
 	}),
+		widget.NewButton("Re-localize on map", func() {
+			//an initialized robot is moved to the new pose by the backend
+			mapView.startPoseEstimate(id, func(x, y, theta int) {
+				chG2bRobotInit <- [4]int{id, x, y, theta}
+			})
+		}),
+		teleopCheck(teleop, id),
+		widget.NewButton("Show details", func() { panels.show(id) }),
 		SquareTestButton(chG2bCommand, id),
 		PatternTestButton(chG2bCommand, id),
 	)
@@ -236,7 +404,7 @@ func SquareTestButton(chG2bCommand chan<- types.Command, id int) *widget.Button
 	}
 
 	send := func(x, y int) {
-		chG2bCommand <- types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y}
+		chG2bCommand <- types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y, Source: types.SourceMission, Operator: operator}
 	}
 
 	waitForTarget := func(targetX, targetY int) {
@@ -305,7 +473,7 @@ func PatternTestButton(chG2bCommand chan<- types.Command, id int) *widget.Button
 	}
 
 	send := func(x, y int) {
-		chG2bCommand <- types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y}
+		chG2bCommand <- types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y, Source: types.SourceMission, Operator: operator}
 	}
 
 	waitForTarget := func(targetX, targetY int) {
@@ -0,0 +1,485 @@
+package gui
+
+import (
+	"golang-server/config"
+	"golang-server/geometry"
+	"golang-server/types"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	targetAutomatic = "Automatic"
+	followNone      = "None"
+)
+
+////////////////////////////////
+// Viewport
+////////////////////////////////
+
+// mapViewport is the transform from map coordinates (cm, y axis pointing up) to the screen, shared by
+// all map layers so they stay aligned at every zoom level. At zoom 1 the whole map is shown, square and
+// centered in the view.
+type mapViewport struct {
+	mu               sync.Mutex
+	zoom             float32
+	centerX, centerY float32 //cm, map coordinates shown at the center of the view
+	follow           int     //robot id kept at the center of the view, -1 for none
+}
+
+func initMapViewport() *mapViewport {
+	v := &mapViewport{follow: -1}
+	v.reset()
+	return v
+}
+
+func (v *mapViewport) reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.zoom = 1
+	v.centerX = config.MapSize/2 - config.MapCenterX
+	v.centerY = config.MapCenterY - config.MapSize/2
+}
+
+// ratio returns the number of pixels per cm in a view of the given size.
+func (v *mapViewport) ratio(size fyne.Size) float32 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.ratioLocked(size)
+}
+
+func (v *mapViewport) ratioLocked(size fyne.Size) float32 {
+	return fyne.Min(size.Height, size.Width) / config.MapSize * v.zoom
+}
+
+// mapToScreen converts map coordinates to a position in a view of the given size.
+func (v *mapViewport) mapToScreen(size fyne.Size, x, y float32) fyne.Position {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ratio := v.ratioLocked(size)
+	return fyne.NewPos(size.Width/2+(x-v.centerX)*ratio, size.Height/2-(y-v.centerY)*ratio)
+}
+
+// screenToMap converts a position in a view of the given size to map coordinates.
+func (v *mapViewport) screenToMap(size fyne.Size, pos fyne.Position) (float32, float32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.screenToMapLocked(size, pos)
+}
+
+func (v *mapViewport) screenToMapLocked(size fyne.Size, pos fyne.Position) (float32, float32) {
+	ratio := v.ratioLocked(size)
+	return v.centerX + (pos.X-size.Width/2)/ratio, v.centerY - (pos.Y-size.Height/2)/ratio
+}
+
+// zoomAt multiplies the zoom by factor, keeping the map point at pos in place.
+func (v *mapViewport) zoomAt(size fyne.Size, pos fyne.Position, factor float32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	x, y := v.screenToMapLocked(size, pos)
+	v.zoom = fyne.Min(fyne.Max(v.zoom*factor, 1), config.MapMaxZoom)
+	ratio := v.ratioLocked(size)
+	v.centerX = x - (pos.X-size.Width/2)/ratio
+	v.centerY = y + (pos.Y-size.Height/2)/ratio
+	v.clampCenter()
+}
+
+// pan moves the view by delta pixels.
+func (v *mapViewport) pan(size fyne.Size, delta fyne.Delta) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ratio := v.ratioLocked(size)
+	v.centerX -= delta.DX / ratio
+	v.centerY += delta.DY / ratio
+	v.clampCenter()
+}
+
+func (v *mapViewport) centerOn(x, y float32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.centerX, v.centerY = x, y
+	v.clampCenter()
+}
+
+// clampCenter keeps the center of the view inside the map.
+func (v *mapViewport) clampCenter() {
+	v.centerX = fyne.Min(fyne.Max(v.centerX, -config.MapCenterX), config.MapSize-config.MapCenterX)
+	v.centerY = fyne.Min(fyne.Max(v.centerY, config.MapCenterY-config.MapSize), config.MapCenterY)
+}
+
+func (v *mapViewport) followed() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.follow
+}
+
+func (v *mapViewport) setFollow(id int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.follow = id
+}
+
+////////////////////////////////
+// Map view
+////////////////////////////////
+
+// mapView is the interactive map. It draws the map and the layers on top of it through the same
+// viewport, sends a goal when the map is tapped, zooms on scroll and pans on drag.
+type mapView struct {
+	widget.BaseWidget
+	viewport  *mapViewport
+	mapCanvas *canvas.Raster
+	layers    *fyne.Container
+	toolbar   *mapToolbar
+	onTap     func(x, y int) //map coordinates
+	onRobot   func(id int)   //a robot was tapped instead of the map
+	estimate  *poseEstimate  //nil unless the operator is setting the pose of a robot
+	preview   *multiRobotHandle
+	zones     *zoneOverlay
+	traffic   *trafficOverlay
+	changes   *changeOverlay
+	costmap   *costmapOverlay
+	drawing   *zoneDrawing //nil unless the operator is drawing a zone
+}
+
+// poseEstimate is an armed "set pose" gesture. The operator presses at the position of the robot and
+// drags towards its heading.
+type poseEstimate struct {
+	id       int
+	onPose   func(x, y, theta int) //map coordinates, degrees
+	dragging bool
+	x, y     float32 //cm, map coordinates of the press
+	theta    int
+}
+
+// zoneDrawing is a zone being drawn, one vertex per tap.
+type zoneDrawing struct {
+	kind   string
+	points [][2]int //map coordinates
+	onZone func(zone types.Zone)
+}
+
+func initMapView(viewport *mapViewport, mapImage *image.RGBA, layers ...fyne.CanvasObject) *mapView {
+	m := &mapView{viewport: viewport}
+	m.mapCanvas = canvas.NewRaster(renderCells(viewport, mapImage))
+	m.mapCanvas.SetMinSize(fyne.NewSize(config.MapMinimumDisplaySize, config.MapMinimumDisplaySize))
+	m.preview = initMultiRobotHandle(viewport)
+	m.preview.container.Hide()
+	m.zones = initZoneOverlay(viewport)
+	m.traffic = initTrafficOverlay(viewport)
+	m.changes = initChangeOverlay(viewport)
+	m.costmap = initCostmapOverlay(viewport)
+	layers = append(layers, m.zones.container, m.traffic.container, m.preview.container)
+	m.layers = container.NewStack(append([]fyne.CanvasObject{m.mapCanvas, m.costmap.container, m.changes.container}, layers...)...)
+	m.toolbar = initMapToolbar(m)
+	m.ExtendBaseWidget(m)
+	return m
+}
+
+// renderCells draws the visible part of an image with one pixel per map cell, e.g. the map, one map
+// cell per block of pixels.
+func renderCells(viewport *mapViewport, mapImage *image.RGBA) func(w, h int) image.Image {
+	return func(w, h int) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		size := fyne.NewSize(float32(w), float32(h))
+		columns := make([]int, w)
+		for px := range columns {
+			x, _ := viewport.screenToMap(size, fyne.NewPos(float32(px)+0.5, 0))
+			columns[px] = config.MapCenterX + int(math.Floor(float64(x)))
+		}
+		for py := 0; py < h; py++ {
+			_, y := viewport.screenToMap(size, fyne.NewPos(0, float32(py)+0.5))
+			yIndex := int(math.Floor(config.MapCenterY - float64(y)))
+			if yIndex < 0 || yIndex >= config.MapSize {
+				continue
+			}
+			for px, xIndex := range columns {
+				if xIndex >= 0 && xIndex < config.MapSize {
+					img.SetRGBA(px, py, mapImage.RGBAAt(xIndex, yIndex))
+				}
+			}
+		}
+		return img
+	}
+}
+
+// refreshLayers redraws the map and lays out all layers again, after the viewport has changed.
+func (m *mapView) refreshLayers() {
+	m.layers.Refresh()
+}
+
+func (m *mapView) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(m.layers)
+}
+
+func (m *mapView) Tapped(ev *fyne.PointEvent) {
+	//give the keyboard back to the emergency stop and teleop keys
+	if c := fyne.CurrentApp().Driver().CanvasForObject(m); c != nil {
+		c.Unfocus()
+	}
+	x, y := m.viewport.screenToMap(m.Size(), ev.Position)
+	xIndex, yIndex := config.MapCenterX+x, config.MapCenterY-y
+	if xIndex < 0 || yIndex < 0 || xIndex >= config.MapSize || yIndex >= config.MapSize {
+		return
+	}
+	if m.estimate != nil {
+		//a tap without dragging keeps the default heading
+		m.finishPoseEstimate(int(math.Round(float64(x))), int(math.Round(float64(y))), 90)
+		return
+	}
+	if m.drawing != nil {
+		m.addZoneVertex(int(math.Round(float64(x))), int(math.Round(float64(y))))
+		return
+	}
+	if id, found := m.robotAt(ev.Position); found && m.onRobot != nil {
+		m.onRobot(id)
+		return
+	}
+	if m.onTap != nil {
+		m.onTap(int(math.Round(float64(x))), int(math.Round(float64(y))))
+	}
+}
+
+// robotAt returns the robot drawn at a screen position, if any.
+func (m *mapView) robotAt(pos fyne.Position) (int, bool) {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	for id, index := range lastId2Index {
+		if index >= len(lastMultiRobot) {
+			continue
+		}
+		robot := m.viewport.mapToScreen(m.Size(), float32(lastMultiRobot[index].X), float32(lastMultiRobot[index].Y))
+		if math.Hypot(float64(robot.X-pos.X), float64(robot.Y-pos.Y)) <= robotTapRadius {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (m *mapView) Scrolled(ev *fyne.ScrollEvent) {
+	factor := float32(math.Pow(config.MapZoomStep, float64(ev.Scrolled.DY)/10)) //one scroll step is 10 px
+	m.viewport.zoomAt(m.Size(), ev.Position, factor)
+	m.refreshLayers()
+}
+
+func (m *mapView) Dragged(ev *fyne.DragEvent) {
+	if m.estimate != nil {
+		m.dragPoseEstimate(ev)
+		return
+	}
+	m.toolbar.follow.SetSelected(followNone)
+	m.viewport.pan(m.Size(), ev.Dragged)
+	m.refreshLayers()
+}
+
+func (m *mapView) DragEnd() {
+	if m.estimate != nil && m.estimate.dragging {
+		m.finishPoseEstimate(int(math.Round(float64(m.estimate.x))), int(math.Round(float64(m.estimate.y))), m.estimate.theta)
+	}
+}
+
+// startPoseEstimate arms the map, so the next press and drag sets the pose of the robot.
+func (m *mapView) startPoseEstimate(id int, onPose func(x, y, theta int)) {
+	m.cancelGesture()
+	m.estimate = &poseEstimate{id: id, onPose: onPose, theta: 90}
+	m.toolbar.showStatus("NRF-"+strconv.Itoa(id)+": press at the robot, drag towards its heading", false)
+}
+
+// cancelGesture stops setting a pose or drawing a zone.
+func (m *mapView) cancelGesture() {
+	m.cancelPoseEstimate()
+	if m.drawing != nil {
+		m.drawing = nil
+		m.zones.setDraft(nil)
+	}
+	m.toolbar.showStatus("", false)
+}
+
+func (m *mapView) cancelPoseEstimate() {
+	if m.estimate == nil {
+		return
+	}
+	m.estimate = nil
+	//the preview shows the id of the robot, so it is made again for the next one
+	m.preview.layout.robots = nil
+	m.preview.container.Objects = nil
+	m.preview.container.Hide()
+	m.toolbar.showStatus("", false)
+}
+
+// dragPoseEstimate places the preview at the press position and points it towards the cursor.
+func (m *mapView) dragPoseEstimate(ev *fyne.DragEvent) {
+	size := m.Size()
+	if !m.estimate.dragging {
+		//the first drag event moved the cursor away from where it was pressed
+		m.estimate.x, m.estimate.y = m.viewport.screenToMap(size, ev.Position.Subtract(ev.Dragged))
+		m.estimate.dragging = true
+		m.preview.AddRobot(m.estimate.id)
+		m.preview.container.Show()
+	}
+	x, y := m.viewport.screenToMap(size, ev.Position)
+	if x != m.estimate.x || y != m.estimate.y {
+		m.estimate.theta = geometry.Rad(math.Atan2(float64(y-m.estimate.y), float64(x-m.estimate.x))).Deg().Round()
+	}
+	xPose, yPose := int(math.Round(float64(m.estimate.x))), int(math.Round(float64(m.estimate.y)))
+	m.preview.setPoseLabel(0, xPose, yPose, m.estimate.theta)
+	m.preview.Move(0, m.estimate.x, m.estimate.y)
+	m.preview.Rotate(0, float64(m.estimate.theta))
+	m.preview.container.Refresh()
+}
+
+func (m *mapView) finishPoseEstimate(x, y, theta int) {
+	onPose := m.estimate.onPose
+	m.cancelPoseEstimate()
+	onPose(x, y, theta)
+}
+
+// startZoneDrawing arms the map, so taps add vertices to a new zone until it is finished.
+func (m *mapView) startZoneDrawing(kind string, onZone func(zone types.Zone)) {
+	m.cancelGesture()
+	m.drawing = &zoneDrawing{kind: kind, onZone: onZone}
+	m.toolbar.showStatus("Drawing "+kind+" zone: tap the corners", false)
+}
+
+func (m *mapView) addZoneVertex(x, y int) {
+	m.drawing.points = append(m.drawing.points, [2]int{x, y})
+	m.zones.setDraft(m.drawing.points)
+	m.toolbar.showStatus("Drawing "+m.drawing.kind+" zone: "+strconv.Itoa(len(m.drawing.points))+" corners", len(m.drawing.points) >= 3)
+}
+
+func (m *mapView) finishZoneDrawing() {
+	drawing := m.drawing
+	m.cancelGesture()
+	if drawing != nil && len(drawing.points) >= 3 {
+		drawing.onZone(types.Zone{Kind: drawing.kind, Points: drawing.points})
+	}
+}
+
+// followRobot centers the view on the followed robot, if any.
+func (m *mapView) followRobot(multiRobot []types.RobotState, id2index map[int]int) {
+	index, exist := id2index[m.viewport.followed()]
+	if !exist || index >= len(multiRobot) {
+		return
+	}
+	m.viewport.centerOn(float32(multiRobot[index].X), float32(multiRobot[index].Y))
+}
+
+////////////////////////////////
+// Toolbar
+////////////////////////////////
+
+// mapToolbar selects who gets the goals sent by tapping the map, and controls the viewport.
+type mapToolbar struct {
+	target, follow *widget.Select
+	status         *widget.Label //instructions while setting a pose or drawing a zone
+	cancel, finish *widget.Button
+	notice         *widget.Label //latest notice from the backend
+	container      *fyne.Container
+}
+
+func initMapToolbar(m *mapView) *mapToolbar {
+	target := widget.NewSelect([]string{targetAutomatic}, nil)
+	target.SetSelected(targetAutomatic)
+	follow := widget.NewSelect([]string{followNone}, func(selected string) {
+		id, ok := robotIdFromLabel(selected)
+		if !ok {
+			id = -1
+		}
+		m.viewport.setFollow(id)
+	})
+	follow.SetSelected(followNone)
+
+	zoomCenter := func(factor float32) {
+		m.viewport.zoomAt(m.Size(), fyne.NewPos(m.Size().Width/2, m.Size().Height/2), factor)
+		m.refreshLayers()
+	}
+	zoomIn := widget.NewButtonWithIcon("", theme.ZoomInIcon(), func() { zoomCenter(config.MapZoomStep) })
+	zoomOut := widget.NewButtonWithIcon("", theme.ZoomOutIcon(), func() { zoomCenter(1 / config.MapZoomStep) })
+	resetView := widget.NewButtonWithIcon("", theme.ZoomFitIcon(), func() {
+		follow.SetSelected(followNone)
+		m.viewport.reset()
+		m.refreshLayers()
+	})
+
+	status := widget.NewLabel("")
+	cancel := widget.NewButtonWithIcon("", theme.CancelIcon(), m.cancelGesture)
+	finish := widget.NewButtonWithIcon("Finish", theme.ConfirmIcon(), m.finishZoneDrawing)
+	status.Hide()
+	cancel.Hide()
+	finish.Hide()
+	notice := widget.NewLabel("")
+	notice.Hide()
+
+	toolbar := container.NewVBox(
+		container.NewHBox(widget.NewLabel("Goal:"), target, widget.NewLabel("Follow:"), follow, zoomIn, zoomOut, resetView),
+		container.NewHBox(status, finish, cancel),
+		notice,
+	)
+	return &mapToolbar{target, follow, status, cancel, finish, notice, toolbar}
+}
+
+// showStatus shows the instructions while a pose is set or a zone is drawn on the map, or hides them if
+// text is empty. The finish button is shown when a zone can be finished.
+func (t *mapToolbar) showStatus(text string, canFinish bool) {
+	t.status.SetText(text)
+	if text == "" {
+		t.status.Hide()
+		t.cancel.Hide()
+	} else {
+		t.status.Show()
+		t.cancel.Show()
+	}
+	if canFinish {
+		t.finish.Show()
+	} else {
+		t.finish.Hide()
+	}
+}
+
+// showNotice shows why a goal was moved or rejected, or that a robot entered a zone.
+func (t *mapToolbar) showNotice(text string) {
+	t.notice.SetText(time.Now().Format("15:04:05") + " " + text)
+	t.notice.Show()
+}
+
+// addRobot makes an initialized robot available as goal target and for following.
+func (t *mapToolbar) addRobot(id int) {
+	label := "NRF-" + strconv.Itoa(id)
+	t.target.Options = append(t.target.Options, label)
+	t.target.Refresh()
+	t.follow.Options = append(t.follow.Options, label)
+	t.follow.Refresh()
+}
+
+// goal returns the command for a goal at (x, y) for the selected target.
+func (t *mapToolbar) goal(x, y int) types.Command {
+	if id, ok := robotIdFromLabel(t.target.Selected); ok {
+		return types.Command{CommandType: types.ManualCommand, Id: id, X: x, Y: y, Source: types.SourceGui, Operator: operator}
+	}
+	return types.Command{CommandType: types.AutomaticCommand, Id: -1, X: x, Y: y, Source: types.SourceGui, Operator: operator}
+}
+
+func sendGoalFromMap(chG2bCommand chan<- types.Command, toolbar *mapToolbar, x, y int) {
+	command := toolbar.goal(x, y)
+	logger.Info("Goal from map", "target", toolbar.target.Selected, "x", x, "y", y)
+	chG2bCommand <- command
+}
+
+// robotIdFromLabel parses labels like "NRF-5".
+func robotIdFromLabel(label string) (int, bool) {
+	if !strings.HasPrefix(label, "NRF-") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(label, "NRF-"))
+	return id, err == nil
+}
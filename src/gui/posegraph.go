@@ -0,0 +1,75 @@
+package gui
+
+import (
+	"golang-server/types"
+	"image/color"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+)
+
+var (
+	graphEdgeColor = color.RGBA{0x00, 0x00, 0xff, 0xa0} //transparent blue
+	graphLoopColor = color.RGBA{0x00, 0xa0, 0x00, 0xff} //dark green
+	graphNodeColor = color.RGBA{0x00, 0x00, 0x8b, 0xff} //dark blue
+)
+
+const graphNodeSize = 4 //px
+
+// poseGraphOverlay draws the keyframes and edges of the backend pose graph on top of the map.
+type poseGraphOverlay struct {
+	view      types.PoseGraphView
+	container *fyne.Container
+	viewport  *mapViewport
+}
+
+func initPoseGraphOverlay(viewport *mapViewport) *poseGraphOverlay {
+	overlay := &poseGraphOverlay{viewport: viewport}
+	overlay.container = container.New(overlay)
+	overlay.container.Hide()
+	return overlay
+}
+
+func (o *poseGraphOverlay) setView(view types.PoseGraphView) {
+	o.view = view
+	objects := make([]fyne.CanvasObject, 0, len(view.Edges)+len(view.LoopClosures)+len(view.Nodes))
+	for range view.Edges {
+		objects = append(objects, initLine(graphEdgeColor, fyne.NewPos(0, 0), fyne.NewPos(0, 0), 1))
+	}
+	for range view.LoopClosures {
+		objects = append(objects, initLine(graphLoopColor, fyne.NewPos(0, 0), fyne.NewPos(0, 0), 2))
+	}
+	for range view.Nodes {
+		objects = append(objects, canvas.NewCircle(graphNodeColor))
+	}
+	o.container.Objects = objects
+	o.Layout(objects, o.container.Size())
+	o.container.Refresh()
+}
+
+// Layout is called to pack all child objects into a specified size.
+func (o *poseGraphOverlay) Layout(objects []fyne.CanvasObject, size fyne.Size) {
+	if len(objects) != len(o.view.Edges)+len(o.view.LoopClosures)+len(o.view.Nodes) {
+		return
+	}
+	i := 0
+	for _, lines := range [][][4]int{o.view.Edges, o.view.LoopClosures} {
+		for _, l := range lines {
+			line := objects[i].(*canvas.Line)
+			line.Position1 = o.viewport.mapToScreen(size, float32(l[0]), float32(l[1]))
+			line.Position2 = o.viewport.mapToScreen(size, float32(l[2]), float32(l[3]))
+			i++
+		}
+	}
+	for _, node := range o.view.Nodes {
+		objects[i].Resize(fyne.NewSize(graphNodeSize, graphNodeSize))
+		objects[i].Move(o.viewport.mapToScreen(size, float32(node[0]), float32(node[1])).SubtractXY(graphNodeSize/2, graphNodeSize/2))
+		i++
+	}
+}
+
+// MinSize finds the smallest size that satisfies all the child objects.
+func (o *poseGraphOverlay) MinSize(objects []fyne.CanvasObject) fyne.Size {
+	return fyne.NewSize(0, 0)
+}
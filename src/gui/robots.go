@@ -3,7 +3,7 @@ package gui
 import (
 	"fmt"
 	"golang-server/config"
-	"golang-server/utilities"
+	"golang-server/geometry"
 	"image/color"
 	"strconv"
 
@@ -18,29 +18,52 @@ import (
 
 type robotLayout struct {
 	lines           [3]*canvas.Line
+	shape           [3]robotLine //the lines at ratio 1, facing up
 	poseLabel       *canvas.Text
 	currentRatio    float32
 	currentRotation float64
+	x, y            float32 //cm, map coordinates
+	viewport        *mapViewport
 }
 
-func initRobotLayout(lines [3]*canvas.Line) *robotLayout {
+type robotLine struct {
+	p1, p2      geometry.Vec2
+	strokeWidth float32
+}
+
+func initRobotLayout(lines [3]*canvas.Line, viewport *mapViewport) *robotLayout {
 	poseLabel := &canvas.Text{Text: "(0, 0, 0)", Alignment: fyne.TextAlignLeading, TextSize: 8, Color: red}
 	poseLabel.Move(fyne.NewPos(0, -20))
-	return &robotLayout{lines, poseLabel, 1, 90}
+	var shape [3]robotLine
+	for i, line := range lines {
+		shape[i] = robotLine{
+			geometry.Vec2{X: float64(line.Position1.X), Y: float64(line.Position1.Y)},
+			geometry.Vec2{X: float64(line.Position2.X), Y: float64(line.Position2.Y)},
+			line.StrokeWidth,
+		}
+	}
+	return &robotLayout{lines, shape, poseLabel, 1, 90, 0, 0, viewport}
 }
 
 // Layout is called to pack all child objects into a specified size.
 func (m *robotLayout) Layout(objects []fyne.CanvasObject, size fyne.Size) {
-	var ratio float32 = fyne.Min(size.Height, size.Width) / config.MapSize
-	adjustment := ratio / m.currentRatio
-	for _, line := range m.lines {
-		line.Position1.X *= adjustment
-		line.Position1.Y *= adjustment
-		line.Position2.X *= adjustment
-		line.Position2.Y *= adjustment
-		line.StrokeWidth *= adjustment
+	if ratio := m.viewport.ratio(size); ratio != m.currentRatio {
+		m.currentRatio = ratio
+		m.redraw()
+	}
+}
+
+// redraw places the lines from the shape, so rotating and zooming many times does not distort the robot.
+func (m *robotLayout) redraw() {
+	//negative because the rotation is clockwise (flipped y-axis)
+	rotation := geometry.Deg(-(m.currentRotation - 90)).Rad()
+	ratio := float64(m.currentRatio)
+	for i, line := range m.lines {
+		p1, p2 := m.shape[i].p1.Rotate(rotation), m.shape[i].p2.Rotate(rotation)
+		line.Position1 = fyne.NewPos(float32(p1.X*ratio), float32(p1.Y*ratio))
+		line.Position2 = fyne.NewPos(float32(p2.X*ratio), float32(p2.Y*ratio))
+		line.StrokeWidth = m.shape[i].strokeWidth * m.currentRatio
 	}
-	m.currentRatio = ratio
 }
 
 // MinSize finds the smallest size that satisfies all the child objects.
@@ -54,15 +77,8 @@ func (m *robotLayout) MinSize(objects []fyne.CanvasObject) fyne.Size {
 
 func (m *robotLayout) Rotate(thetaDeg float64) {
 	if thetaDeg != m.currentRotation {
-		diffTheta := -(thetaDeg - m.currentRotation) //negative because the rotation is clockwise (flipped y-axis)
-		for _, line := range m.lines {
-			x, y := utilities.Rotate(float64(line.Position1.X), float64(line.Position1.Y), float64(diffTheta))
-			line.Position1.X, line.Position1.Y = float32(x), float32(y)
-
-			x, y = utilities.Rotate(float64(line.Position2.X), float64(line.Position2.Y), float64(diffTheta))
-			line.Position2.X, line.Position2.Y = float32(x), float32(y)
-		}
 		m.currentRotation = thetaDeg
+		m.redraw()
 	}
 }
 
@@ -79,12 +95,12 @@ func initLine(
 	return l
 }
 
-func initRobotGui() *robotLayout {
+func initRobotGui(viewport *mapViewport) *robotLayout {
 	mainBody := initLine(blue, fyne.NewPos(0, -10), fyne.NewPos(0, 10), 13)
 	wheels := initLine(blue, fyne.NewPos(-10, 0), fyne.NewPos(10, 0), 6.5)
 	directionIndicator := initLine(red, fyne.NewPos(0, 0), fyne.NewPos(0, -9), 3)
 	robotLines := [3]*canvas.Line{mainBody, directionIndicator, wheels}
-	robotHandle := initRobotLayout(robotLines)
+	robotHandle := initRobotLayout(robotLines, viewport)
 	return robotHandle
 }
 
@@ -95,16 +111,21 @@ func initRobotGui() *robotLayout {
 type multiRobotLayout struct {
 	robots      []*robotLayout
 	currentSize fyne.Size
+	viewport    *mapViewport
 }
 
-func initMultiRobotLayout() *multiRobotLayout {
-	return &multiRobotLayout{nil, fyne.NewSize(config.MapSize, config.MapSize)}
+func initMultiRobotLayout(viewport *mapViewport) *multiRobotLayout {
+	return &multiRobotLayout{nil, fyne.NewSize(config.MapSize, config.MapSize), viewport}
 }
 
 // Layout is called to pack all child objects into a specified size.
 func (m *multiRobotLayout) Layout(objects []fyne.CanvasObject, newSize fyne.Size) {
-	for _, child := range objects {
+	for i, child := range objects {
 		child.Resize(newSize)
+		//the viewport may have changed, so the robots are placed again
+		if i < len(m.robots) {
+			child.Move(m.viewport.mapToScreen(newSize, m.robots[i].x, m.robots[i].y))
+		}
 	}
 	m.currentSize = newSize
 }
@@ -131,20 +152,11 @@ func (m *multiRobotHandle) Rotate(index int, theta float64) {
 	m.layout.robots[index].Rotate(theta)
 }
 
-func (m *multiRobotHandle) Move(index int, position fyne.Position) {
-	currentSize := m.layout.currentSize
-	ratio := fyne.Min(currentSize.Height, currentSize.Width) / config.MapSize
-	scalePosition := fyne.NewPos(float32(position.X)*ratio, float32(position.Y)*ratio)
-
-	//The map is square and centered, but we must offset the position of the robots relative to the top left corner
-	dx, dy := float32(config.MapCenterX)*ratio, float32(config.MapCenterY)*ratio
-	if currentSize.Height > currentSize.Width {
-		dy += (currentSize.Height - currentSize.Width) / 2
-	} else {
-		dx += (currentSize.Width - currentSize.Height) / 2
-	}
-
-	m.container.Objects[index].Move(scalePosition.AddXY(dx, dy))
+// Move places the robot at (x, y) in map coordinates.
+func (m *multiRobotHandle) Move(index int, x, y float32) {
+	robot := m.layout.robots[index]
+	robot.x, robot.y = x, y
+	m.container.Objects[index].Move(m.layout.viewport.mapToScreen(m.layout.currentSize, x, y))
 }
 
 func (m *multiRobotHandle) setPoseLabel(index int, x, y, theta int) {
@@ -154,7 +166,7 @@ func (m *multiRobotHandle) setPoseLabel(index int, x, y, theta int) {
 }
 
 func (m *multiRobotHandle) AddRobot(id int) {
-	robot := initRobotGui()
+	robot := initRobotGui(m.layout.viewport)
 
 	m.layout.robots = append(m.layout.robots, robot)
 
@@ -168,8 +180,8 @@ func (m *multiRobotHandle) NumRobots() int {
 	return len(m.layout.robots)
 }
 
-func initMultiRobotHandle() *multiRobotHandle {
-	layout := initMultiRobotLayout()
+func initMultiRobotHandle(viewport *mapViewport) *multiRobotHandle {
+	layout := initMultiRobotLayout(viewport)
 	container := container.New(layout)
 	return &multiRobotHandle{layout, container}
 }
@@ -0,0 +1,61 @@
+package gui
+
+import (
+	"fmt"
+	"golang-server/types"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// statsPanel shows the exploration statistics computed by the backend on every update.
+type statsPanel struct {
+	elapsed   *widget.Label
+	explored  *widget.Label
+	obstacles *widget.Label
+	roi       *widget.Label
+	robots    *widget.Label
+	container *fyne.Container
+}
+
+func initStatsPanel() *statsPanel {
+	p := &statsPanel{
+		elapsed:   widget.NewLabel(""),
+		explored:  widget.NewLabel(""),
+		obstacles: widget.NewLabel(""),
+		roi:       widget.NewLabel(""),
+		robots:    widget.NewLabel(""),
+	}
+	form := widget.NewForm(
+		widget.NewFormItem("Session", p.elapsed),
+		widget.NewFormItem("Explored", p.explored),
+		widget.NewFormItem("Obstacle cells", p.obstacles),
+		widget.NewFormItem("Region of interest", p.roi),
+	)
+	p.container = container.NewVBox(form, widget.NewSeparator(), p.robots)
+	p.setStats(types.CoverageStats{RoiCoverage: -1})
+	return p
+}
+
+func (p *statsPanel) setStats(stats types.CoverageStats) {
+	p.elapsed.SetText(stats.Elapsed.Round(time.Second).String())
+	p.explored.SetText(fmt.Sprintf("%.2f m²", stats.ExploredM2))
+	p.obstacles.SetText(fmt.Sprint(stats.ObstacleCells))
+	if stats.RoiCoverage >= 0 {
+		p.roi.SetText(fmt.Sprintf("%.1f %% explored", stats.RoiCoverage*100))
+	} else {
+		p.roi.SetText("none, draw one in the Zones tab")
+	}
+	if len(stats.Robots) == 0 {
+		p.robots.SetText("No robots initialized")
+		return
+	}
+	lines := make([]string, 0, len(stats.Robots))
+	for _, robot := range stats.Robots {
+		lines = append(lines, fmt.Sprintf("NRF-%d: %.2f m travelled, %.2f m² discovered", robot.Id, robot.DistanceM, robot.DiscoveredM2))
+	}
+	p.robots.SetText(strings.Join(lines, "\n"))
+}
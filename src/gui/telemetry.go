@@ -0,0 +1,224 @@
+package gui
+
+import (
+	"fmt"
+	"golang-server/config"
+	"golang-server/types"
+	"image"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	sparklineSamples = config.TelemetryHistory * config.GuiFrameRate //one sample per update
+	sparklineWidth   = 240                                           //px
+	sparklineHeight  = 40                                            //px
+	robotTapRadius   = 12                                            //px, tapping this close to a robot opens its panel
+)
+
+// sparkline plots the last config.TelemetryHistory seconds of a value, scaled to its range.
+type sparkline struct {
+	name   string
+	unit   string
+	mu     sync.Mutex //the values are added by ThreadGuiUpdate and drawn by the gui
+	values []float64  //oldest first
+	label  *widget.Label
+	raster *canvas.Raster
+}
+
+func initSparkline(name, unit string) *sparkline {
+	s := &sparkline{name: name, unit: unit, values: make([]float64, 0, sparklineSamples), label: widget.NewLabel(name)}
+	s.raster = canvas.NewRaster(s.render)
+	s.raster.SetMinSize(fyne.NewSize(sparklineWidth, sparklineHeight))
+	return s
+}
+
+func (s *sparkline) add(value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == sparklineSamples {
+		copy(s.values, s.values[1:])
+		s.values = s.values[:len(s.values)-1]
+	}
+	s.values = append(s.values, value)
+}
+
+// refresh shows the latest value and redraws the plot.
+func (s *sparkline) refresh() {
+	s.mu.Lock()
+	if len(s.values) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	lowest, highest := s.bounds()
+	latest := s.values[len(s.values)-1]
+	s.mu.Unlock()
+	text := fmt.Sprintf("%s: %.2f", s.name, latest)
+	if s.unit != "" {
+		text += " " + s.unit
+	}
+	s.label.SetText(text + fmt.Sprintf(" (%.2f to %.2f)", lowest, highest))
+	s.raster.Refresh()
+}
+
+func (s *sparkline) bounds() (lowest, highest float64) {
+	lowest, highest = math.Inf(1), math.Inf(-1)
+	for _, value := range s.values {
+		lowest, highest = min(lowest, value), max(highest, value)
+	}
+	return lowest, highest
+}
+
+// render draws the values from left to right, the latest at the right edge. A column joins the values
+// of two samples, so the line has no gaps when the values jump.
+func (s *sparkline) render(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) < 2 || w < 2 || h < 2 {
+		return img
+	}
+	lowest, highest := s.bounds()
+	if highest == lowest {
+		lowest, highest = lowest-1, highest+1
+	}
+	row := func(value float64) int {
+		return int(math.Round((highest - value) / (highest - lowest) * float64(h-1)))
+	}
+	column := func(i int) int {
+		return w - 1 - (len(s.values)-1-i)*(w-1)/(sparklineSamples-1)
+	}
+	for i := 1; i < len(s.values); i++ {
+		y0, y1 := row(s.values[i-1]), row(s.values[i])
+		for x := max(column(i-1), 0); x <= column(i); x++ {
+			for y := min(y0, y1); y <= max(y0, y1); y++ {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+// robotPanel shows the telemetry of one robot. It keeps the plots up to date while closed, so they show
+// the whole history when it is opened.
+type robotPanel struct {
+	id         int
+	window     fyne.Window //nil while closed
+	status     *widget.Label
+	pose       *widget.Label
+	variance   *widget.Label
+	tower      *widget.Label
+	lastSeen   *widget.Label
+	sparklines []*sparkline //accel x, accel y, gyro z, message rate, x variance
+	content    fyne.CanvasObject
+}
+
+func initRobotPanel(id int) *robotPanel {
+	p := &robotPanel{
+		id:       id,
+		status:   widget.NewLabel(""),
+		pose:     widget.NewLabel(""),
+		variance: widget.NewLabel(""),
+		tower:    widget.NewLabel(""),
+		lastSeen: widget.NewLabel("never"),
+		sparklines: []*sparkline{
+			initSparkline("Accel x", ""),
+			initSparkline("Accel y", ""),
+			initSparkline("Gyro z", ""),
+			initSparkline("Message rate", "Hz"),
+			initSparkline("Variance x", ""),
+		},
+	}
+	form := widget.NewForm(
+		widget.NewFormItem("Status", p.status),
+		widget.NewFormItem("Pose", p.pose),
+		widget.NewFormItem("Covariance diagonal", p.variance),
+		widget.NewFormItem("IR tower", p.tower),
+		widget.NewFormItem("Last seen", p.lastSeen),
+	)
+	plots := container.NewVBox()
+	for _, s := range p.sparklines {
+		plots.Add(s.label)
+		plots.Add(s.raster)
+	}
+	p.content = container.NewVScroll(container.NewVBox(form, widget.NewSeparator(), plots))
+	return p
+}
+
+// setTelemetry adds a sample to the plots, and shows the telemetry if the panel is open.
+func (p *robotPanel) setTelemetry(t types.RobotTelemetry, now time.Time) {
+	for i, value := range []float64{float64(t.AccelX), float64(t.AccelY), float64(t.GyroZ), t.Rate, float64(t.Variance[0])} {
+		p.sparklines[i].add(value)
+	}
+	if p.window == nil {
+		return
+	}
+	p.status.SetText(t.Status)
+	p.pose.SetText(fmt.Sprintf("(%d, %d, %d)", t.X, t.Y, t.Theta))
+	p.variance.SetText(fmt.Sprintf("%.3g, %.3g, %.3g, %.3g, %.3g", t.Variance[0], t.Variance[1], t.Variance[2], t.Variance[3], t.Variance[4]))
+	valid := "valid"
+	if t.Valid == 0 {
+		valid = "invalid"
+	}
+	p.tower.SetText(fmt.Sprintf("%d°, %s", t.IrTowerAngle, valid))
+	if !t.LastSeen.IsZero() {
+		p.lastSeen.SetText(fmt.Sprintf("%s ago, %.1f msg/s", now.Sub(t.LastSeen).Round(100*time.Millisecond), t.Rate))
+	}
+	for _, s := range p.sparklines {
+		s.refresh()
+	}
+}
+
+// robotPanels are the panels of the initialized robots.
+type robotPanels struct {
+	mu     sync.Mutex //updated by ThreadGuiUpdate, opened from the gui
+	panels map[int]*robotPanel
+}
+
+func initRobotPanels() *robotPanels {
+	return &robotPanels{panels: map[int]*robotPanel{}}
+}
+
+func (r *robotPanels) panel(id int) *robotPanel {
+	p, exist := r.panels[id]
+	if !exist {
+		p = initRobotPanel(id)
+		r.panels[id] = p
+	}
+	return p
+}
+
+func (r *robotPanels) setTelemetry(telemetry []types.RobotTelemetry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, t := range telemetry {
+		r.panel(t.Id).setTelemetry(t, now)
+	}
+}
+
+// show opens the panel of a robot, or brings it to the front if it is open.
+func (r *robotPanels) show(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.panel(id)
+	if p.window != nil {
+		p.window.RequestFocus()
+		return
+	}
+	p.window = fyne.CurrentApp().NewWindow("NRF-" + strconv.Itoa(id))
+	p.window.SetContent(p.content)
+	p.window.SetOnClosed(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		p.window = nil
+	})
+	p.window.Show()
+}
@@ -0,0 +1,198 @@
+package gui
+
+import (
+	"golang-server/config"
+	"golang-server/types"
+	"math"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Keys used for teleoperation, as linear and angular input.
+var teleopKeys = map[fyne.KeyName][2]float64{
+	fyne.KeyW: {1, 0}, fyne.KeyUp: {1, 0},
+	fyne.KeyS: {-1, 0}, fyne.KeyDown: {-1, 0},
+	fyne.KeyA: {0, 1}, fyne.KeyLeft: {0, 1},
+	fyne.KeyD: {0, -1}, fyne.KeyRight: {0, -1},
+}
+
+// teleop drives one robot at a time with velocity commands from the keyboard or a gamepad. Commands are
+// repeated at config.TeleopRate while input is held. When the input is released, a stop is sent
+// (deadman). Key releases are not seen when the keyboard focus moves to a widget or away from the window,
+// so the keys are also released then, and when no key event has arrived for config.TeleopKeyTimeout.
+type teleop struct {
+	mu           sync.Mutex
+	id           int           //robot being driven, -1 when teleop is off
+	check        *widget.Check //checkbox of the robot being driven
+	keys         map[fyne.KeyName]bool
+	keyEvent     time.Time  //latest key event, including key repeats
+	gamepad      [2]float64 //linear, angular in [-1, 1], zero unless the deadman button is held
+	last         [2]int     //latest velocity sent
+	wake         chan struct{}
+	window       fyne.Window
+	chG2bCommand chan<- types.Command
+}
+
+func initTeleop(window fyne.Window, chG2bCommand chan<- types.Command) *teleop {
+	return &teleop{id: -1, keys: map[fyne.KeyName]bool{}, wake: make(chan struct{}, 1), window: window, chG2bCommand: chG2bCommand}
+}
+
+func (t *teleop) setKey(key fyne.KeyName, pressed bool) {
+	if _, exist := teleopKeys[key]; !exist {
+		return
+	}
+	t.mu.Lock()
+	if pressed {
+		t.keys[key] = true
+	} else {
+		delete(t.keys, key)
+	}
+	t.keyEvent = time.Now()
+	t.mu.Unlock()
+	t.poke()
+}
+
+// repeatKey tells that a key is still held, from the key repeats of the operating system.
+func (t *teleop) repeatKey(key fyne.KeyName) {
+	if _, exist := teleopKeys[key]; !exist {
+		return
+	}
+	t.mu.Lock()
+	t.keyEvent = time.Now()
+	t.mu.Unlock()
+}
+
+// releaseKeys forgets the held keys, for when key releases can no longer be seen.
+func (t *teleop) releaseKeys() {
+	t.mu.Lock()
+	t.keys = map[fyne.KeyName]bool{}
+	t.mu.Unlock()
+	t.poke()
+}
+
+func (t *teleop) setGamepad(linear, angular float64) {
+	t.mu.Lock()
+	released := t.gamepad != [2]float64{} && linear == 0 && angular == 0
+	t.gamepad = [2]float64{linear, angular}
+	t.mu.Unlock()
+	if released {
+		t.poke()
+	}
+}
+
+// poke makes run send the current input right away.
+func (t *teleop) poke() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enable starts driving the robot with the given id, and stops the robot driven before.
+func (t *teleop) enable(id int, check *widget.Check) {
+	t.mu.Lock()
+	previousId, previousCheck := t.id, t.check
+	t.id, t.check = id, check
+	t.keys = map[fyne.KeyName]bool{}
+	t.last = [2]int{}
+	t.mu.Unlock()
+	//keys only reach the teleop when no widget has the keyboard focus
+	t.window.Canvas().Unfocus()
+	logger.Info("Teleoperation enabled", "robot", id)
+	if previousId != -1 && previousId != id {
+		//unchecking the previous checkbox does nothing more, since it is no longer the robot being driven
+		t.send(previousId, [2]int{})
+		previousCheck.SetChecked(false)
+	}
+}
+
+// teleopCheck is the checkbox that turns teleoperation of a robot on and off.
+func teleopCheck(t *teleop, id int) *widget.Check {
+	check := widget.NewCheck("Teleop (WASD / arrows, gamepad LB + stick)", nil)
+	check.OnChanged = func(checked bool) {
+		if checked {
+			t.enable(id, check)
+		} else {
+			t.disable(id)
+		}
+	}
+	return check
+}
+
+// disable stops the robot if it is the one being driven.
+func (t *teleop) disable(id int) {
+	t.mu.Lock()
+	if t.id != id {
+		t.mu.Unlock()
+		return
+	}
+	t.id, t.check = -1, nil
+	t.keys = map[fyne.KeyName]bool{}
+	t.mu.Unlock()
+	t.send(id, [2]int{})
+	logger.Info("Teleoperation disabled", "robot", id)
+}
+
+func (t *teleop) run() {
+	ticker := time.NewTicker(time.Second / config.TeleopRate)
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		t.update()
+	}
+}
+
+// update sends the velocity from the current input. A stop is only sent once.
+func (t *teleop) update() {
+	focused := t.window.Canvas().Focused() != nil
+	t.mu.Lock()
+	id := t.id
+	if id == -1 {
+		t.mu.Unlock()
+		return
+	}
+	if len(t.keys) > 0 && time.Since(t.keyEvent) > config.TeleopKeyTimeout*time.Millisecond {
+		logger.Warn("No key events while driving, teleop keys released", "robot", id)
+		focused = true
+	}
+	if focused {
+		t.keys = map[fyne.KeyName]bool{}
+	}
+	linear, angular := t.gamepad[0], t.gamepad[1]
+	for key := range t.keys {
+		linear += teleopKeys[key][0]
+		angular += teleopKeys[key][1]
+	}
+	velocity := [2]int{
+		int(math.Round(max(min(linear, 1), -1) * config.TeleopLinearSpeed)),
+		int(math.Round(max(min(angular, 1), -1) * config.TeleopAngularSpeed)),
+	}
+	changed := velocity != t.last
+	t.last = velocity
+	t.mu.Unlock()
+
+	if !changed && velocity == [2]int{} {
+		return
+	}
+	if changed {
+		logger.Debug("Teleoperating robot", "robot", id, "linear", velocity[0], "angular", velocity[1])
+	}
+	t.send(id, velocity)
+}
+
+func (t *teleop) send(id int, velocity [2]int) {
+	t.chG2bCommand <- types.Command{CommandType: types.TeleopCommand, Id: id, Linear: velocity[0], Angular: velocity[1], Source: types.SourceGui, Operator: operator}
+}
+
+// gamepadDeadzone removes small stick values, and scales the rest to [-1, 1].
+func gamepadDeadzone(value float64) float64 {
+	if math.Abs(value) < config.TeleopGamepadDeadzone {
+		return 0
+	}
+	return math.Copysign((math.Abs(value)-config.TeleopGamepadDeadzone)/(1-config.TeleopGamepadDeadzone), value)
+}
@@ -0,0 +1,70 @@
+package gui
+
+import (
+	"golang-server/types"
+	"image/color"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+)
+
+var (
+	waitingColor = color.RGBA{0xff, 0xa5, 0x00, 0xa0} //transparent orange
+	blockedColor = color.RGBA{0xff, 0x00, 0x00, 0xa0} //transparent red
+)
+
+const trafficMarkerSize = 16 //px
+
+// trafficOverlay marks where robots are predicted to meet, and which robot gives way.
+type trafficOverlay struct {
+	conflicts []types.TrafficConflict
+	container *fyne.Container
+	viewport  *mapViewport
+}
+
+func initTrafficOverlay(viewport *mapViewport) *trafficOverlay {
+	overlay := &trafficOverlay{viewport: viewport}
+	overlay.container = container.New(overlay)
+	return overlay
+}
+
+func (o *trafficOverlay) setConflicts(conflicts []types.TrafficConflict) {
+	if len(conflicts) == 0 && len(o.conflicts) == 0 {
+		return
+	}
+	o.conflicts = conflicts
+	objects := make([]fyne.CanvasObject, 0, 2*len(conflicts))
+	for _, conflict := range conflicts {
+		c, text := blockedColor, "NRF-"+strconv.Itoa(conflict.Id)+" blocked by NRF-"+strconv.Itoa(conflict.Other)
+		if conflict.Waiting {
+			c, text = waitingColor, "NRF-"+strconv.Itoa(conflict.Id)+" waits for NRF-"+strconv.Itoa(conflict.Other)
+		}
+		label := canvas.NewText(text, c)
+		label.TextSize = 10
+		objects = append(objects, canvas.NewCircle(c), label)
+	}
+	o.container.Objects = objects
+	o.Layout(objects, o.container.Size())
+	o.container.Refresh()
+}
+
+// Layout is called to pack all child objects into a specified size.
+func (o *trafficOverlay) Layout(objects []fyne.CanvasObject, size fyne.Size) {
+	if len(objects) != 2*len(o.conflicts) {
+		return
+	}
+	for i, conflict := range o.conflicts {
+		pos := o.viewport.mapToScreen(size, float32(conflict.X), float32(conflict.Y))
+		objects[2*i].Resize(fyne.NewSize(trafficMarkerSize, trafficMarkerSize))
+		objects[2*i].Move(pos.SubtractXY(trafficMarkerSize/2, trafficMarkerSize/2))
+		objects[2*i+1].Resize(objects[2*i+1].MinSize())
+		objects[2*i+1].Move(pos.AddXY(trafficMarkerSize/2, -trafficMarkerSize/2))
+	}
+}
+
+// MinSize finds the smallest size that satisfies all the child objects.
+func (o *trafficOverlay) MinSize(objects []fyne.CanvasObject) fyne.Size {
+	return fyne.NewSize(0, 0)
+}
@@ -0,0 +1,152 @@
+package gui
+
+import (
+	"fmt"
+	"golang-server/types"
+	"image/color"
+	"io"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	keepOutColor  = color.RGBA{0xff, 0x00, 0xff, 0xc0} //transparent magenta, red is used for obstacles
+	allowedColor  = color.RGBA{0x00, 0xa0, 0x00, 0xc0} //transparent dark green
+	interestColor = color.RGBA{0x00, 0x60, 0xff, 0xc0} //transparent blue
+	draftColor    = color.RGBA{0xff, 0xa5, 0x00, 0xff} //orange
+)
+
+// zoneOverlay draws the geofences, and the zone being drawn, on top of the map.
+type zoneOverlay struct {
+	mu        sync.Mutex
+	zones     []types.Zone //as reported by the backend
+	draft     [][2]int     //vertices of the zone being drawn
+	edges     [][4]int     //x1, y1, x2, y2 [cm] of all lines, the draft last
+	colors    []color.Color
+	container *fyne.Container
+	viewport  *mapViewport
+}
+
+func initZoneOverlay(viewport *mapViewport) *zoneOverlay {
+	overlay := &zoneOverlay{viewport: viewport}
+	overlay.container = container.New(overlay)
+	return overlay
+}
+
+func (o *zoneOverlay) setZones(zones []types.Zone) {
+	o.mu.Lock()
+	o.zones = zones
+	o.mu.Unlock()
+	o.rebuild()
+}
+
+func (o *zoneOverlay) setDraft(points [][2]int) {
+	o.mu.Lock()
+	o.draft = points
+	o.mu.Unlock()
+	o.rebuild()
+}
+
+func (o *zoneOverlay) getZones() []types.Zone {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.zones
+}
+
+// rebuild makes one line per zone edge. The draft is not closed until it is finished.
+func (o *zoneOverlay) rebuild() {
+	o.mu.Lock()
+	o.edges, o.colors = nil, nil
+	for _, zone := range o.zones {
+		var c color.Color
+		switch zone.Kind {
+		case types.ZoneKeepOut:
+			c = keepOutColor
+		case types.ZoneAllowed:
+			c = allowedColor
+		case types.ZoneInterest:
+			c = interestColor
+		}
+		for i, j := 0, len(zone.Points)-1; i < len(zone.Points); j, i = i, i+1 {
+			o.edges = append(o.edges, [4]int{zone.Points[j][0], zone.Points[j][1], zone.Points[i][0], zone.Points[i][1]})
+			o.colors = append(o.colors, c)
+		}
+	}
+	for i := 1; i < len(o.draft); i++ {
+		o.edges = append(o.edges, [4]int{o.draft[i-1][0], o.draft[i-1][1], o.draft[i][0], o.draft[i][1]})
+		o.colors = append(o.colors, draftColor)
+	}
+	objects := make([]fyne.CanvasObject, 0, len(o.edges))
+	for _, c := range o.colors {
+		objects = append(objects, initLine(c, fyne.NewPos(0, 0), fyne.NewPos(0, 0), 2))
+	}
+	o.mu.Unlock()
+	o.container.Objects = objects
+	o.Layout(objects, o.container.Size())
+	o.container.Refresh()
+}
+
+// Layout is called to pack all child objects into a specified size.
+func (o *zoneOverlay) Layout(objects []fyne.CanvasObject, size fyne.Size) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(objects) != len(o.edges) {
+		return
+	}
+	for i, edge := range o.edges {
+		line := objects[i].(*canvas.Line)
+		line.Position1 = o.viewport.mapToScreen(size, float32(edge[0]), float32(edge[1]))
+		line.Position2 = o.viewport.mapToScreen(size, float32(edge[2]), float32(edge[3]))
+	}
+}
+
+// MinSize finds the smallest size that satisfies all the child objects.
+func (o *zoneOverlay) MinSize(objects []fyne.CanvasObject) fyne.Size {
+	return fyne.NewSize(0, 0)
+}
+
+// initZonesTab lets the operator draw zones on the map, load them from a file or clear them. The whole
+// list of zones is sent to the backend, which saves it and sends it back to be drawn.
+func initZonesTab(w fyne.Window, mapView *mapView, chG2bZones chan<- []types.Zone) *fyne.Container {
+	addZone := func(zone types.Zone) {
+		zones := append([]types.Zone{}, mapView.zones.getZones()...)
+		zone.Name = fmt.Sprintf("%s %d", zone.Kind, len(zones)+1)
+		chG2bZones <- append(zones, zone)
+		logger.Info("Geofence drawn", "zone", zone.Name, "points", zone.Points)
+	}
+	drawKeepOut := widget.NewButton("Draw keep-out zone", func() { mapView.startZoneDrawing(types.ZoneKeepOut, addZone) })
+	drawAllowed := widget.NewButton("Draw allowed zone", func() { mapView.startZoneDrawing(types.ZoneAllowed, addZone) })
+	drawInterest := widget.NewButton("Draw region of interest", func() { mapView.startZoneDrawing(types.ZoneInterest, addZone) })
+	load := widget.NewButton("Load zones from file", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			if err == nil {
+				var zones []types.Zone
+				if zones, err = types.ParseZones(data); err == nil {
+					chG2bZones <- zones
+					logger.Info("Geofences loaded", "file", reader.URI().Path())
+					return
+				}
+			}
+			logger.Error("Failed to load geofences", "file", reader.URI().Path(), "err", err)
+			dialog.ShowError(err, w)
+		}, w)
+	})
+	clearZones := widget.NewButton("Clear zones", func() {
+		dialog.ShowConfirm("Clear zones", "Remove all zones and regions of interest?", func(ok bool) {
+			if ok {
+				chG2bZones <- []types.Zone{}
+			}
+		}, w)
+	})
+	return container.NewVBox(drawKeepOut, drawAllowed, drawInterest, load, clearZones)
+}
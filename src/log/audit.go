@@ -0,0 +1,93 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// The audit log has one JSON object per line for every command sent to the backend, whether it was sent
+// to the robots or not. Read it with e.g. pandas.read_json("audit.jsonl", lines=True) or jq.
+
+// AuditRecord is one line of the audit log.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"` //E.g. types.SourceGui
+	Operator  string    `json:"operator"`
+	Role      string    `json:"role,omitempty"` //only with config.UseOperatorRoles
+	Command   string    `json:"command"`        //E.g. "goal"
+	Robot     int       `json:"robot"`          //-1 for all robots, or if no robot was found
+	Requested *[2]int   `json:"requested_cm,omitempty"`
+	Map       *[2]int   `json:"map_cm,omitempty"`  //goal after the geofences, map frame
+	Body      *[2]int   `json:"body_mm,omitempty"` //goal sent to the robot, in its own frame
+	Velocity  *[2]int   `json:"velocity,omitempty"`
+	Outcome   string    `json:"outcome"` //E.g. AuditSent
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Outcomes of a command. Sent commands are queued for the robots, which may still drop a goal if the
+// robot is paused by traffic management when it is published.
+const (
+	AuditSent     = "sent"
+	AuditDropped  = "dropped"
+	AuditRejected = "rejected" //not allowed for the role of the operator
+)
+
+type AuditLogger struct {
+	file   io.WriteCloser
+	buf    *bufio.Writer
+	closed bool
+}
+
+// InitAuditLogger creates audit.jsonl in the session directory.
+func InitAuditLogger() (*AuditLogger, error) {
+	file, err := CreateSessionFile("audit.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditLogger(file), nil
+}
+
+// NewAuditLogger writes the audit log to file, e.g. a buffer in tests.
+func NewAuditLogger(file io.WriteCloser) *AuditLogger {
+	return &AuditLogger{file: file, buf: bufio.NewWriter(file)}
+}
+
+// Write adds a record. Records are flushed right away, so the log is complete if the server is killed.
+func (l *AuditLogger) Write(record AuditRecord) error {
+	if l == nil || l.closed {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	l.buf.Write(line)
+	l.buf.WriteByte('\n')
+	return l.buf.Flush()
+}
+
+func (l *AuditLogger) Close() error {
+	if l == nil || l.closed {
+		return nil
+	}
+	l.closed = true
+	l.buf.Flush()
+	return l.file.Close()
+}
+
+// ReadAudit reads an audit log.
+func ReadAudit(r io.Reader) ([]AuditRecord, error) {
+	records := []AuditRecord{}
+	decoder := json.NewDecoder(r)
+	for {
+		var record AuditRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
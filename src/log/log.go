@@ -1,32 +1,129 @@
 package log
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"golang-server/config"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Everything written during one run of the server goes to its own session directory under
+// config.LogDir, so earlier runs are kept. GGeneralLogger writes general.log there, with a level and
+// key/value fields on every line, as text or JSON. Until Init is called, for example in tests, only
+// warnings and errors are written, to stderr.
+
 // global logging variable
-var GGeneralLogger *log.Logger = initGeneralLogger()
+var GGeneralLogger = slog.New(&sessionHandler{})
+
+var (
+	current atomic.Pointer[slog.Handler]
+	level   = new(slog.LevelVar)
+
+	sessionOnce sync.Once
+	sessionDir  string
+	sessionErr  error
+)
 
-func initGeneralLogger() *log.Logger {
-	// Flags: Create if needed, write only, remove contents. 0666 is read/write permission for everyone.
-	file, err := os.OpenFile("general.log", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+func init() {
+	var stderr slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})
+	current.Store(&stderr)
+}
+
+// Init creates the session directory and sends all logging to its general.log.
+func Init() error {
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return fmt.Errorf("config.LogLevel: %w", err)
+	}
+	dir, err := SessionDir()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	var logger = log.New(file, "", 0)
-	logger.SetFlags(log.Ltime | log.Lmicroseconds)
-	return logger
+	file, err := openRotatingFile(filepath.Join(dir, "general.log"), config.LogMaxSizeMB*1024*1024, config.LogMaxAge*time.Hour, config.LogMaxBackups)
+	if err != nil {
+		return err
+	}
+	var handler slog.Handler
+	options := &slog.HandlerOptions{Level: level}
+	switch config.LogFormat {
+	case "json":
+		handler = slog.NewJSONHandler(file, options)
+	case "text":
+		handler = slog.NewTextHandler(file, options)
+	default:
+		return fmt.Errorf("config.LogFormat: unknown format %q", config.LogFormat)
+	}
+	current.Store(&handler)
+	return nil
+}
+
+// Component returns a logger that adds the component to every line, e.g. "backend".
+func Component(name string) *slog.Logger {
+	return GGeneralLogger.With("component", name)
 }
 
-func InitPositionLogger() *log.Logger {
-	// Flags: Create if needed, write only, remove contents. 0666 is read/write permission for everyone.
-	file, err := os.OpenFile("positions.csv", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+// SessionDir returns the output directory of this run, and creates it the first time.
+func SessionDir() (string, error) {
+	sessionOnce.Do(func() {
+		base := filepath.Join(config.LogDir, time.Now().Format("2006-01-02_15-04-05"))
+		sessionDir = base
+		for i := 2; ; i++ {
+			//Mkdir fails if the directory exists, so two runs started in the same second do not share it
+			if sessionErr = os.MkdirAll(config.LogDir, 0777); sessionErr != nil {
+				return
+			}
+			if sessionErr = os.Mkdir(sessionDir, 0777); !os.IsExist(sessionErr) {
+				return
+			}
+			sessionDir = fmt.Sprintf("%s_%d", base, i)
+		}
+	})
+	return sessionDir, sessionErr
+}
+
+// CreateSessionFile creates a file in the session directory, e.g. for recordings.
+func CreateSessionFile(name string) (*os.File, error) {
+	dir, err := SessionDir()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	var logger = log.New(file, "", 0)
-	logger.Println("time id x[cm] y[cm] theta[degrees] EKFcovarianceMatrix[25] (the delimiter is a space)")
-	logger.SetFlags(log.Ltime | log.Lmicroseconds)
-	return logger
+	return os.Create(filepath.Join(dir, name))
+}
+
+// sessionHandler passes records on to the current handler, so loggers made before Init, like the ones
+// kept by each package, also write to the session.
+type sessionHandler struct {
+	with []func(slog.Handler) slog.Handler //attributes and groups added by With and WithGroup, in order
+}
+
+func (h *sessionHandler) handler() slog.Handler {
+	handler := *current.Load()
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler
+}
+
+func (h *sessionHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return (*current.Load()).Enabled(ctx, l)
+}
+
+func (h *sessionHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *sessionHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.add(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *sessionHandler) WithGroup(name string) slog.Handler {
+	return h.add(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *sessionHandler) add(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &sessionHandler{append(append([]func(slog.Handler) slog.Handler{}, h.with...), with)}
 }
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "general.log")
+	f, err := openRotatingFile(path, 10, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//every line is larger than half the limit, so each one starts a new file and the first is removed
+	for name, want := range map[string]string{"general.log": "fourth\n", "general.log.1": "third\n", "general.log.2": "second\n"} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", name, want, got, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 rotated files")
+	}
+}
+
+func TestLoggerMadeBeforeInit(t *testing.T) {
+	logger := Component("backend")
+	previous := current.Load()
+	defer current.Store(previous)
+
+	var buf bytes.Buffer
+	var handler slog.Handler = slog.NewJSONHandler(&buf, nil)
+	current.Store(&handler)
+	logger.Info("Robot initialized", "robot", 5)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected one JSON line, got %q", buf.String())
+	}
+	if line["component"] != "backend" || line["robot"] != 5.0 || line["level"] != "INFO" {
+		t.Errorf("Fields missing from %v", line)
+	}
+}
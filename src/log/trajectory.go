@@ -0,0 +1,257 @@
+package log
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// The trajectory log has one row per message from an initialized robot. CSV and JSON Lines have the
+// same columns, see trajectoryHeader, and both load directly into pandas:
+//
+//	pandas.read_csv("trajectory.csv", parse_dates=["wall_time"])
+//	pandas.read_json("trajectory.jsonl", lines=True)
+//
+// Parquet would need an external library, so it is not written here. Convert with
+// pandas.read_csv(...).to_parquet(...) if needed.
+
+var sessionStart = time.Now() //holds a monotonic clock reading
+
+// TrajectoryRow is one row of the trajectory log.
+type TrajectoryRow struct {
+	WallTime     time.Time
+	Monotonic    time.Duration //since the server started, not affected by changes to the wall clock
+	Id           int
+	RawX, RawY   int //mm, robot frame, as reported by the robot
+	RawTheta     int //degrees, robot frame
+	X, Y         int //cm, map frame
+	Theta        int //degrees, map frame
+	Covariance   [25]float32
+	Ir           [4][2]int //mm, robot frame, x and y of each IR sensor
+	IrTowerAngle int       //degrees
+	Valid        uint8
+}
+
+type TrajectoryLogger struct {
+	file   io.WriteCloser
+	buf    *bufio.Writer
+	csv    *csv.Writer //nil for JSON Lines
+	closed bool
+}
+
+// trajectoryHeader returns the column names, also used as JSON keys.
+func trajectoryHeader() []string {
+	header := []string{"wall_time", "monotonic_s", "robot_id", "raw_x_mm", "raw_y_mm", "raw_theta_deg", "x_cm", "y_cm", "theta_deg"}
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			header = append(header, fmt.Sprintf("cov_%d%d", i, j))
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		header = append(header, fmt.Sprintf("ir%d_x_mm", i), fmt.Sprintf("ir%d_y_mm", i))
+	}
+	return append(header, "ir_tower_angle_deg", "valid")
+}
+
+// InitTrajectoryLogger creates trajectory.csv or trajectory.jsonl in the session directory. The format
+// is "csv" or "jsonl".
+func InitTrajectoryLogger(format string) (*TrajectoryLogger, error) {
+	if format != "csv" && format != "jsonl" {
+		return nil, fmt.Errorf("unknown trajectory log format %q", format)
+	}
+	file, err := CreateSessionFile("trajectory." + format)
+	if err != nil {
+		return nil, err
+	}
+	return newTrajectoryLogger(file, format)
+}
+
+func newTrajectoryLogger(file io.WriteCloser, format string) (*TrajectoryLogger, error) {
+	l := &TrajectoryLogger{file: file, buf: bufio.NewWriter(file)}
+	if format == "csv" {
+		l.csv = csv.NewWriter(l.buf)
+		if err := l.csv.Write(trajectoryHeader()); err != nil {
+			return nil, err
+		}
+	}
+	return l, l.flush()
+}
+
+// NewTrajectoryRow returns a row timestamped now.
+func NewTrajectoryRow() TrajectoryRow {
+	now := time.Now()
+	return TrajectoryRow{WallTime: now, Monotonic: now.Sub(sessionStart)}
+}
+
+// Write adds a row. Rows are flushed right away, so the log is complete if the server is killed.
+func (l *TrajectoryLogger) Write(row TrajectoryRow) error {
+	if l == nil || l.closed {
+		return nil
+	}
+	values := row.values()
+	if l.csv != nil {
+		if err := l.csv.Write(values); err != nil {
+			return err
+		}
+		return l.flush()
+	}
+	//the values are already formatted, so they are written as raw JSON in header order
+	header := trajectoryHeader()
+	l.buf.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			l.buf.WriteByte(',')
+		}
+		switch {
+		case i == 0:
+			value = strconv.Quote(value)
+		case value == "NaN" || value == "+Inf" || value == "-Inf":
+			value = "null" //not valid JSON, pandas reads null as NaN
+		}
+		fmt.Fprintf(l.buf, "%q:%s", header[i], value)
+	}
+	l.buf.WriteString("}\n")
+	return l.flush()
+}
+
+func (l *TrajectoryLogger) flush() error {
+	if l.csv != nil {
+		l.csv.Flush()
+		if err := l.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return l.buf.Flush()
+}
+
+func (l *TrajectoryLogger) Close() error {
+	if l == nil || l.closed {
+		return nil
+	}
+	l.closed = true
+	l.flush()
+	return l.file.Close()
+}
+
+// values formats the row in the order of trajectoryHeader. All but the wall time are numbers.
+func (row TrajectoryRow) values() []string {
+	values := []string{
+		row.WallTime.Format(time.RFC3339Nano),
+		strconv.FormatFloat(row.Monotonic.Seconds(), 'f', 6, 64),
+	}
+	for _, v := range []int{row.Id, row.RawX, row.RawY, row.RawTheta, row.X, row.Y, row.Theta} {
+		values = append(values, strconv.Itoa(v))
+	}
+	for _, v := range row.Covariance {
+		values = append(values, strconv.FormatFloat(float64(v), 'g', -1, 32))
+	}
+	for _, ir := range row.Ir {
+		values = append(values, strconv.Itoa(ir[0]), strconv.Itoa(ir[1]))
+	}
+	return append(values, strconv.Itoa(row.IrTowerAngle), strconv.Itoa(int(row.Valid)))
+}
+
+// ReadTrajectory reads a trajectory log in the format "csv" or "jsonl". Columns are matched by name, so
+// logs with missing columns can be read, the missing values are zero.
+func ReadTrajectory(r io.Reader, format string) ([]TrajectoryRow, error) {
+	rows := []TrajectoryRow{}
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return rows, nil
+			} else if err != nil {
+				return nil, err
+			}
+			values := map[string]string{}
+			for i, name := range header {
+				values[name] = record[i]
+			}
+			row, err := parseTrajectoryRow(values)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", len(rows)+1, err)
+			}
+			rows = append(rows, row)
+		}
+	case "jsonl":
+		decoder := json.NewDecoder(r)
+		for {
+			var object map[string]json.RawMessage
+			if err := decoder.Decode(&object); err == io.EOF {
+				return rows, nil
+			} else if err != nil {
+				return nil, err
+			}
+			values := map[string]string{}
+			for name, raw := range object {
+				value := string(raw)
+				if value == "null" {
+					value = "NaN"
+				} else if unquoted, err := strconv.Unquote(value); err == nil {
+					value = unquoted
+				}
+				values[name] = value
+			}
+			row, err := parseTrajectoryRow(values)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %w", len(rows)+1, err)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return nil, fmt.Errorf("unknown trajectory log format %q", format)
+}
+
+// parseTrajectoryRow is the inverse of values, from the values by column name.
+func parseTrajectoryRow(values map[string]string) (TrajectoryRow, error) {
+	var row TrajectoryRow
+	var err error
+	parseInt := func(name string, v *int) {
+		if s, exist := values[name]; exist && err == nil {
+			*v, err = strconv.Atoi(s)
+		}
+	}
+	if s, exist := values["wall_time"]; exist {
+		if row.WallTime, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return row, err
+		}
+	}
+	if s, exist := values["monotonic_s"]; exist {
+		seconds, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return row, err
+		}
+		row.Monotonic = time.Duration(seconds * float64(time.Second))
+	}
+	for name, v := range map[string]*int{"robot_id": &row.Id, "raw_x_mm": &row.RawX, "raw_y_mm": &row.RawY,
+		"raw_theta_deg": &row.RawTheta, "x_cm": &row.X, "y_cm": &row.Y, "theta_deg": &row.Theta, "ir_tower_angle_deg": &row.IrTowerAngle} {
+		parseInt(name, v)
+	}
+	for i := 1; i <= 4; i++ {
+		parseInt(fmt.Sprintf("ir%d_x_mm", i), &row.Ir[i-1][0])
+		parseInt(fmt.Sprintf("ir%d_y_mm", i), &row.Ir[i-1][1])
+	}
+	valid := 0
+	parseInt("valid", &valid)
+	row.Valid = uint8(valid)
+	for i := range row.Covariance {
+		if s, exist := values[fmt.Sprintf("cov_%d%d", i/5, i%5)]; exist && err == nil {
+			var v float64
+			v, err = strconv.ParseFloat(s, 32)
+			row.Covariance[i] = float32(v)
+		}
+	}
+	return row, err
+}
@@ -0,0 +1,103 @@
+package log
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func testRow() TrajectoryRow {
+	row := TrajectoryRow{WallTime: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), Monotonic: 1500 * time.Millisecond}
+	row.Id, row.RawX, row.RawY, row.RawTheta, row.X, row.Y, row.Theta = 5, 1000, -20, 90, 100, -2, 180
+	row.Covariance[0], row.Covariance[24] = 0.25, float32(math.NaN())
+	row.Ir[3] = [2]int{-150, 300}
+	row.Valid = 1
+	return row
+}
+
+func TestTrajectoryCSV(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newTrajectoryLogger(nopCloser{&buf}, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(testRow())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected a header and one row, got %v (%v)", records, err)
+	}
+	header, row := records[0], records[1]
+	if len(header) != len(row) || len(header) != 9+25+8+2 {
+		t.Fatalf("Expected %d columns, got %d in the header and %d in the row", 9+25+8+2, len(header), len(row))
+	}
+	got := map[string]string{}
+	for i, name := range header {
+		got[name] = row[i]
+	}
+	for name, want := range map[string]string{
+		"wall_time": "2026-10-19T12:00:00Z", "monotonic_s": "1.500000", "robot_id": "5", "raw_x_mm": "1000",
+		"x_cm": "100", "theta_deg": "180", "cov_00": "0.25", "cov_44": "NaN", "ir4_x_mm": "-150", "valid": "1",
+	} {
+		if got[name] != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got[name])
+		}
+	}
+}
+
+func TestTrajectoryJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newTrajectoryLogger(nopCloser{&buf}, "jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(testRow())
+	l.Write(testRow())
+
+	decoder := json.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var row map[string]any
+		if err := decoder.Decode(&row); err != nil {
+			t.Fatalf("Line %d is not valid JSON: %v", i+1, err)
+		}
+		if row["wall_time"] != "2026-10-19T12:00:00Z" || row["raw_theta_deg"] != 90.0 || row["cov_44"] != nil || row["ir4_y_mm"] != 300.0 {
+			t.Errorf("Unexpected values in %v", row)
+		}
+	}
+}
+
+func TestReadTrajectory(t *testing.T) {
+	for _, format := range []string{"csv", "jsonl"} {
+		var buf bytes.Buffer
+		l, err := newTrajectoryLogger(nopCloser{&buf}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Write(testRow())
+		l.Write(testRow())
+
+		rows, err := ReadTrajectory(&buf, format)
+		if err != nil || len(rows) != 2 {
+			t.Fatalf("%s: expected two rows, got %d (%v)", format, len(rows), err)
+		}
+		want, got := testRow(), rows[1]
+		if !math.IsNaN(float64(got.Covariance[24])) {
+			t.Errorf("%s: expected cov_44 to be NaN, got %v", format, got.Covariance[24])
+		}
+		got.Covariance[24], want.Covariance[24] = 0, 0
+		if !got.WallTime.Equal(want.WallTime) {
+			t.Errorf("%s: expected wall time %v, got %v", format, want.WallTime, got.WallTime)
+		}
+		got.WallTime = want.WallTime
+		if got != want {
+			t.Errorf("%s: expected %+v, got %+v", format, want, got)
+		}
+	}
+}
@@ -1,56 +1,147 @@
 package main
 
 import (
+	"fmt"
 	"golang-server/backend"
 	"golang-server/communication"
+	"golang-server/config"
 	"golang-server/gui"
+	"golang-server/log"
+	"golang-server/metrics"
 	"golang-server/types"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
+	//go run . export ..., see cli.go
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+
+	//everything from this run is written to a new directory under config.LogDir
+	if err := log.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, "Logging to stderr, failed to create the session log: ", err)
+	} else {
+		dir, _ := log.SessionDir()
+		log.GGeneralLogger.Info("Session started", "dir", dir)
+	}
+
 	//Most channels are buffered for efficiency.
 
 	//only backend can publish and receive
-	chPublish := make(chan [3]int, 3)
+	chPublish := make(chan types.PublishMsg, 3)
+	chPublishControl := make(chan types.PublishMsg, 64) //stop, pause and resume from the backend and the gui, published before chPublish
 	chReceive := make(chan types.AdvMsg, 3)
 	chCamera := make(chan types.CameraMsg, 16)
 
 	//g2b = gui to backend
 	chG2bRobotInit := make(chan [4]int, 3)
 	chG2bCommand := make(chan types.Command)
+	chG2bMergeReject := make(chan int, 3)
+	chG2bZones := make(chan []types.Zone, 3)
+	chG2bExport := make(chan types.ExportRequest, 3)
 
 	//b2g = backend to gui
 	chB2gUpdate := make(chan types.UpdateGui, 3) //Buffered so it won't block ThreadBackend(types.AdvMsg
 	chB2gRobotPendingInit := make(chan int, 3)   //Buffered so it won't block ThreadBackend()
+	chB2gMergeProposal := make(chan types.MergeProposal, 3)
+	chB2gNotice := make(chan string, 16) //dropped by ThreadBackend() if full
+
+	//ThreadBackend() writes its summary and closes its files, then closes the received channel
+	chShutdown := make(chan chan struct{})
+
+	//exported on /metrics, see metrics/slam.go
+	metrics.WatchChannel("chPublish", chPublish)
+	metrics.WatchChannel("chPublishControl", chPublishControl)
+	metrics.WatchChannel("chReceive", chReceive)
+	metrics.WatchChannel("chCamera", chCamera)
+	metrics.WatchChannel("chG2bRobotInit", chG2bRobotInit)
+	metrics.WatchChannel("chG2bCommand", chG2bCommand)
+	metrics.WatchChannel("chG2bMergeReject", chG2bMergeReject)
+	metrics.WatchChannel("chG2bZones", chG2bZones)
+	metrics.WatchChannel("chG2bExport", chG2bExport)
+	metrics.WatchChannel("chB2gUpdate", chB2gUpdate)
+	metrics.WatchChannel("chB2gRobotPendingInit", chB2gRobotPendingInit)
+	metrics.WatchChannel("chB2gMergeProposal", chB2gMergeProposal)
+	metrics.WatchChannel("chB2gNotice", chB2gNotice)
+	if config.MetricsAddress != "" {
+		go func() {
+			log.GGeneralLogger.Error("Metrics endpoint stopped", "err", metrics.Serve(config.MetricsAddress))
+		}()
+	}
 
 	go backend.ThreadBackend(
 		chPublish,
+		chPublishControl,
 		chReceive,
 		chCamera,
 		chB2gRobotPendingInit,
 		chB2gUpdate,
 		chG2bRobotInit,
 		chG2bCommand,
+		chB2gMergeProposal,
+		chG2bMergeReject,
+		chG2bZones,
+		chB2gNotice,
+		chG2bExport,
+		chShutdown,
 	)
 
-	client := communication.InitMqtt()
-	communication.Subscribe(client, chReceive)
-	communication.SubscribeCamera(client, chCamera)
-	go communication.ThreadMqttPublish(client, chPublish)
+	//MQTT, UDP or serial, selected by config.Transport
+	transport, err := communication.InitTransport()
+	if err != nil {
+		log.GGeneralLogger.Error("Failed to connect to the robots", "transport", config.Transport, "err", err)
+		panic(err)
+	}
+	defer transport.Close()
+	communication.Subscribe(transport, chReceive)
+	communication.SubscribeCamera(transport, chCamera)
+	go communication.ThreadPublish(transport, chPublish, chPublishControl)
 
 	//window.ShowAndRun() must be run in the main thread. So the GUI must be initialized here.
-	window, mapImage, mapCanvas, allRobotsHandle, manualInput, initInput := gui.InitGui(chG2bCommand)
+	window, mapImage, mapView, allRobotsHandle, graphOverlay, teleop, motion, stats, panels, manualInput, initInput := gui.InitGui(chG2bCommand, chG2bZones, chG2bExport, chPublishControl)
 	go gui.ThreadGuiUpdate(
 		mapImage,
-		mapCanvas,
+		mapView,
 		allRobotsHandle,
+		graphOverlay,
+		teleop,
+		motion,
+		stats,
+		panels,
 		manualInput, initInput,
 		chG2bCommand,
 		chG2bRobotInit,
 		chB2gRobotPendingInit,
 		chB2gUpdate,
+		chB2gMergeProposal,
+		chG2bMergeReject,
+		chB2gNotice,
 	)
 
+	//Ctrl+C closes the window like the operator would, so the session is finished properly
+	chSignal := make(chan os.Signal, 1)
+	signal.Notify(chSignal, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-chSignal
+		window.Close()
+	}()
+
 	window.ShowAndRun()
 
+	//the backend may be blocked, e.g. by the gui, so it is not waited for too long
+	done := make(chan struct{})
+	timeout := time.After(2 * time.Second)
+	select {
+	case chShutdown <- done:
+		select {
+		case <-done:
+		case <-timeout:
+		}
+	case <-timeout:
+	}
+	log.GGeneralLogger.Info("Session ended")
 }
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	//only the metrics made here are written
+	saved := registry
+	registry = nil
+	defer func() { registry = saved }()
+	messages := NewCounter("test_messages_total", "Messages.", "robot")
+	messages.Inc("5")
+	messages.Add(2, "5")
+	messages.Inc("1")
+	depth := NewGauge("test_depth", "Depth.")
+	depth.SetFunc(func() float64 { return 3 })
+	latency := NewHistogram("test_seconds", "Latency.", []float64{0.1, 1}, "kind")
+	latency.Observe(0.05, "goal")
+	latency.Observe(0.5, "goal")
+	latency.Observe(5, "goal")
+
+	var buf bytes.Buffer
+	Write(&buf)
+	want := `# HELP test_depth Depth.
+# TYPE test_depth gauge
+test_depth 3
+# HELP test_messages_total Messages.
+# TYPE test_messages_total counter
+test_messages_total{robot="1"} 1
+test_messages_total{robot="5"} 3
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{kind="goal",le="0.1"} 1
+test_seconds_bucket{kind="goal",le="1"} 2
+test_seconds_bucket{kind="goal",le="+Inf"} 3
+test_seconds_sum{kind="goal"} 5.55
+test_seconds_count{kind="goal"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestWatchChannel(t *testing.T) {
+	ch := make(chan int, 3)
+	ch <- 1
+	WatchChannel("test", ch)
+	var buf bytes.Buffer
+	Write(&buf)
+	if !strings.Contains(buf.String(), `slam_channel_depth{channel="test"} 1`) || !strings.Contains(buf.String(), `slam_channel_capacity{channel="test"} 3`) {
+		t.Errorf("Channel depth and capacity missing from:\n%s", buf.String())
+	}
+}
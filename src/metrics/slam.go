@@ -0,0 +1,36 @@
+package metrics
+
+// The metrics exported by the server. Rates are computed by Prometheus from the counters, e.g.
+// rate(slam_robot_messages_total[1m]).
+
+// latencyBuckets are in seconds, from 100 µs to 1 s.
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+var (
+	RobotMessages = NewCounter("slam_robot_messages_total",
+		"Messages received from the robots.", "robot", "topic")
+	DecodeFailures = NewCounter("slam_decode_failures_total",
+		"Messages from the robots that were dropped, by reason.", "topic", "reason")
+	CommandsDropped = NewCounter("slam_commands_dropped_total",
+		"Commands to the robots that were not published, by reason.", "reason")
+	ChannelDepth = NewGauge("slam_channel_depth",
+		"Messages waiting in a channel between the threads.", "channel")
+	ChannelCapacity = NewGauge("slam_channel_capacity",
+		"Buffer size of a channel between the threads.", "channel")
+	BackendLoopSeconds = NewHistogram("slam_backend_loop_seconds",
+		"Time spent by the backend on one event, by event.", latencyBuckets, "event")
+	GuiFrameSeconds = NewHistogram("slam_gui_frame_seconds",
+		"Time spent by the gui applying one update from the backend.", latencyBuckets)
+	MapCells = NewGauge("slam_map_cells",
+		"Map cells by state.", "state")
+	MapChanges = NewCounter("slam_map_changes_total",
+		"Map cells that changed between open and obstacle, by kind.", "kind")
+	CommandPublishSeconds = NewHistogram("slam_command_publish_seconds",
+		"Time to publish one command to the broker, by kind.", latencyBuckets, "kind")
+)
+
+// WatchChannel exports the depth and capacity of a channel.
+func WatchChannel[T any](name string, ch chan T) {
+	ChannelDepth.SetFunc(func() float64 { return float64(len(ch)) }, name)
+	ChannelCapacity.Set(float64(cap(ch)), name)
+}
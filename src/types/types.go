@@ -3,6 +3,13 @@ package types
 //This package contains types that are used by multiple packages.
 //Generally they are used by channels to communicate between packages.
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
 type AdvMsg struct {
 	Id                       int
 	X                        int
@@ -18,6 +25,8 @@ type AdvMsg struct {
 	Ir4y                     int
 	IrTowerAngle             int
 	Valid                    uint8
+	AccelX, AccelY           float32 //IMU, as sent by the robot
+	GyroZ                    float32
 	CovarianceMatrixNumber1  float32
 	CovarianceMatrixNumber2  float32
 	CovarianceMatrixNumber3  float32
@@ -45,9 +54,38 @@ type AdvMsg struct {
 	CovarianceMatrixNumber25 float32
 }
 
-// CameraMsg represents a camera line segment reported by a camera module.
+// Covariance returns the EKF covariance matrix, row by row.
+func (m AdvMsg) Covariance() [25]float32 {
+	return [25]float32{
+		m.CovarianceMatrixNumber1, m.CovarianceMatrixNumber2, m.CovarianceMatrixNumber3, m.CovarianceMatrixNumber4, m.CovarianceMatrixNumber5,
+		m.CovarianceMatrixNumber6, m.CovarianceMatrixNumber7, m.CovarianceMatrixNumber8, m.CovarianceMatrixNumber9, m.CovarianceMatrixNumber10,
+		m.CovarianceMatrixNumber11, m.CovarianceMatrixNumber12, m.CovarianceMatrixNumber13, m.CovarianceMatrixNumber14, m.CovarianceMatrixNumber15,
+		m.CovarianceMatrixNumber16, m.CovarianceMatrixNumber17, m.CovarianceMatrixNumber18, m.CovarianceMatrixNumber19, m.CovarianceMatrixNumber20,
+		m.CovarianceMatrixNumber21, m.CovarianceMatrixNumber22, m.CovarianceMatrixNumber23, m.CovarianceMatrixNumber24, m.CovarianceMatrixNumber25,
+	}
+}
+
+// SetCovariance is the inverse of Covariance.
+func (m *AdvMsg) SetCovariance(c [25]float32) {
+	m.CovarianceMatrixNumber1, m.CovarianceMatrixNumber2, m.CovarianceMatrixNumber3, m.CovarianceMatrixNumber4, m.CovarianceMatrixNumber5 = c[0], c[1], c[2], c[3], c[4]
+	m.CovarianceMatrixNumber6, m.CovarianceMatrixNumber7, m.CovarianceMatrixNumber8, m.CovarianceMatrixNumber9, m.CovarianceMatrixNumber10 = c[5], c[6], c[7], c[8], c[9]
+	m.CovarianceMatrixNumber11, m.CovarianceMatrixNumber12, m.CovarianceMatrixNumber13, m.CovarianceMatrixNumber14, m.CovarianceMatrixNumber15 = c[10], c[11], c[12], c[13], c[14]
+	m.CovarianceMatrixNumber16, m.CovarianceMatrixNumber17, m.CovarianceMatrixNumber18, m.CovarianceMatrixNumber19, m.CovarianceMatrixNumber20 = c[15], c[16], c[17], c[18], c[19]
+	m.CovarianceMatrixNumber21, m.CovarianceMatrixNumber22, m.CovarianceMatrixNumber23, m.CovarianceMatrixNumber24, m.CovarianceMatrixNumber25 = c[20], c[21], c[22], c[23], c[24]
+}
+
+// CameraMsg represents one camera frame reported by a camera module. A frame without segments means
+// that nothing was detected inside the field of view.
 type CameraMsg struct {
-	Id         int
+	Id           int
+	FrameId      int
+	TimestampMs  int //robot clock
+	IrTowerAngle int //degrees 0-180, at capture time
+	Segments     []CameraSegment
+}
+
+// CameraSegment is a detected object, given in mm in the camera frame.
+type CameraSegment struct {
 	StartMM    int
 	WidthMM    int
 	DistanceMM int
@@ -56,11 +94,68 @@ type CameraMsg struct {
 const (
 	AutomaticCommand = iota
 	ManualCommand
+	TeleopCommand
+	StopCommand   //emergency stop of all robots, goals are dropped
+	PauseCommand  //all robots halt, but keep their goals
+	ResumeCommand //continue after a stop or pause
+)
+
+// Motion state of the robots, set by StopCommand, PauseCommand and ResumeCommand.
+const (
+	MotionRunning = iota
+	MotionPaused
+	MotionStopped
 )
 
 type Command struct {
-	CommandType int //E.g. AutomaticCommand
-	Id, X, Y    int
+	CommandType     int //E.g. AutomaticCommand
+	Id, X, Y        int
+	Linear, Angular int    //TeleopCommand velocities, mm/s and degrees/s (positive is counterclockwise)
+	Source          string //E.g. SourceGui, recorded in the audit log
+	Operator        string //who sent the command, see the operator roles below
+}
+
+// Sources of commands. Only the Fyne gui sends commands so far.
+const (
+	SourceGui         = "gui"
+	SourceWeb         = "web"
+	SourceApi         = "api"
+	SourceMission     = "mission"
+	SourceExploration = "exploration"
+)
+
+// Operator roles, only used with config.UseOperatorRoles. Everyone may stop the robots. Operators may
+// also send goals and teleop commands, pause them and resume after a pause. Only supervisors may resume
+// after an emergency stop. Operators that are not listed in config.OperatorsFile are viewers.
+const (
+	RoleViewer     = "viewer"
+	RoleOperator   = "operator"
+	RoleSupervisor = "supervisor"
+)
+
+// Kinds of messages published to the robots on v2/server/NRF_x/cmd. The kind is the first byte of the
+// payload, followed by two little-endian int16 values.
+const (
+	PublishTarget   = 2 //x, y [mm] in the robot frame
+	PublishVelocity = 3 //linear [mm/s], angular [degrees/s]
+	PublishStop     = 4 //halt and drop the goal, values are unused
+	PublishPause    = 5 //halt and keep the goal, values are unused
+	PublishResume   = 6 //continue after a stop or pause, values are unused
+)
+
+type PublishMsg struct {
+	Kind    uint8 //E.g. PublishTarget
+	Id      int
+	Values  [2]int
+	Traffic bool //a pause or resume from traffic management, not retained
+}
+
+// MergeProposal is an estimated initial pose for a robot, found by matching its local map to the global map.
+type MergeProposal struct {
+	Id         int
+	X, Y       int     //cm
+	Theta      int     //degrees
+	Confidence float64 //0-1
 }
 
 type RobotState struct {
@@ -70,8 +165,115 @@ type RobotState struct {
 }
 
 type UpdateGui struct {
-	MultiRobot  []RobotState
-	Id2index    map[int]int
-	NewOpen     [][2]int
-	NewObstacle [][2]int
+	MultiRobot   []RobotState
+	Id2index     map[int]int
+	NewOpen      [][2]int
+	NewObstacle  [][2]int
+	NewUnknown   [][2]int       //cells that were reset, e.g. when the map is rebuilt
+	PoseGraph    *PoseGraphView //nil if the pose graph has not changed since the last update
+	Motion       int            //E.g. MotionStopped
+	Zones        []Zone
+	ZonesChanged bool //Zones is only set when the zones have changed
+	Traffic      []TrafficConflict
+	Coverage     CoverageStats
+	Changes      []MapChange //since the last update
+	Costmap      []uint8     //nil if unchanged, cost of each cell for the default robot radius, see backend/costmap.go
+	Telemetry    []RobotTelemetry
+}
+
+// RobotTelemetry is the latest state of an initialized robot, for the robot panels of the gui.
+type RobotTelemetry struct {
+	Id             int
+	X, Y, Theta    int        //cm, degrees, map frame
+	Variance       [5]float32 //diagonal of the EKF covariance matrix
+	AccelX, AccelY float32    //IMU, as sent by the robot
+	GyroZ          float32
+	IrTowerAngle   int //degrees
+	Valid          uint8
+	Rate           float64 //messages per second
+	LastSeen       time.Time
+	Status         string //what the robot is doing, e.g. "driving to (50, 20)"
+}
+
+// MapChange is a cell where the map changed, see backend/dynamic.go. X and Y are map indices.
+type MapChange struct {
+	X, Y int
+	Kind uint8 //E.g. ChangeAppeared
+}
+
+const (
+	ChangeCleared  uint8 = iota //the change is no longer highlighted
+	ChangeAppeared              //an obstacle where the map was open
+	ChangeVanished              //open where the map had an obstacle
+)
+
+// CoverageStats describe the exploration so far, see backend/coverage.go.
+type CoverageStats struct {
+	Elapsed       time.Duration //since the server started
+	ExploredM2    float64       //open and obstacle cells
+	ObstacleCells int
+	RoiCoverage   float64         //fraction of the regions of interest that is explored, -1 without any
+	Robots        []RobotCoverage //sorted by id
+}
+
+type RobotCoverage struct {
+	Id           int
+	DistanceM    float64 //travelled since the robot was initialized, jumps from re-localizing excluded
+	DiscoveredM2 float64 //cells explored for the first time by the sensors of this robot
+}
+
+// TrafficConflict is a predicted conflict between the paths of two robots, see backend/traffic.go.
+type TrafficConflict struct {
+	Id      int  //robot that gives way
+	Other   int  //robot it conflicts with
+	X, Y    int  //cm, where the robots are predicted to meet
+	Waiting bool //Id is paused until the conflict is gone, otherwise Other is not moving and Id is blocked
+}
+
+// PoseGraphView is the part of the pose graph that is drawn in the GUI. Given in map coordinates (cm).
+type PoseGraphView struct {
+	Nodes        [][2]int //x, y
+	Edges        [][4]int //x1, y1, x2, y2
+	LoopClosures [][4]int //x1, y1, x2, y2
+}
+
+// Zone kinds. Goals are not allowed inside keep-out zones, and if there are allowed zones, goals must be
+// inside one of them. Regions of interest are only used for the coverage statistics.
+const (
+	ZoneKeepOut  = "keep-out"
+	ZoneAllowed  = "allowed"
+	ZoneInterest = "interest"
+)
+
+// Zone is a geofence polygon in map coordinates (cm).
+type Zone struct {
+	Name   string   `json:"name"`
+	Kind   string   `json:"kind"` //E.g. ZoneKeepOut
+	Points [][2]int `json:"points"`
+}
+
+// ParseZones reads zones from JSON, a list of objects with name, kind and points.
+func ParseZones(data []byte) ([]Zone, error) {
+	zones := []Zone{}
+	if err := json.Unmarshal(data, &zones); err != nil {
+		return nil, err
+	}
+	for _, zone := range zones {
+		if zone.Kind != ZoneKeepOut && zone.Kind != ZoneAllowed && zone.Kind != ZoneInterest {
+			return nil, fmt.Errorf("zone %q has unknown kind %q", zone.Name, zone.Kind)
+		}
+		if len(zone.Points) < 3 {
+			return nil, fmt.Errorf("zone %q has %d points, at least 3 are needed", zone.Name, len(zone.Points))
+		}
+	}
+	return zones, nil
+}
+
+// ExportRequest asks the backend to export the map and what the robots did, see the export package.
+type ExportRequest struct {
+	Format string   //E.g. "svg"
+	Layers []string //names of the layers to draw, e.g. "map"
+	Crop   bool
+	Writer io.WriteCloser //closed by the backend when the export is done
+	Name   string         //of the file, for the notice
 }
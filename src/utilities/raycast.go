@@ -0,0 +1,86 @@
+package utilities
+
+import (
+	"math"
+	"slices"
+)
+
+// Cells of a grid are centered on whole coordinates, so cell (x, y) covers [x-0.5, x+0.5) x [y-0.5, y+0.5).
+// Unlike BresenhamAlgorithm the points do not have to be cell centers, a ray from a robot between two
+// cells visits the cells it actually crosses.
+
+// Cell returns the cell that contains the point (x, y).
+func Cell(x, y float64) (int, int) {
+	return int(math.Floor(x + 0.5)), int(math.Floor(y + 0.5))
+}
+
+// SupercoverLine visits every cell crossed by the line from (x0, y0) to (x1, y1), in order from the
+// cell of the first point to the cell of the last (Amanatides-Woo traversal). Where the line passes
+// exactly through a corner, both cells next to the corner are visited as well.
+func SupercoverLine(x0, y0, x1, y1 float64, visit func(x, y int)) {
+	x, y := Cell(x0, y0)
+	xEnd, yEnd := Cell(x1, y1)
+	stepX, tDeltaX, tMaxX := gridStep(x0, x1-x0)
+	stepY, tDeltaY, tMaxY := gridStep(y0, y1-y0)
+	const epsilon = 1e-9
+
+	visit(x, y)
+	for (x != xEnd || y != yEnd) && min(tMaxX, tMaxY) <= 1+epsilon {
+		switch {
+		case math.Abs(tMaxX-tMaxY) < epsilon:
+			visit(x+stepX, y)
+			visit(x, y+stepY)
+			x, tMaxX = x+stepX, tMaxX+tDeltaX
+			y, tMaxY = y+stepY, tMaxY+tDeltaY
+		case tMaxX < tMaxY:
+			x, tMaxX = x+stepX, tMaxX+tDeltaX
+		default:
+			y, tMaxY = y+stepY, tMaxY+tDeltaY
+		}
+		visit(x, y)
+	}
+}
+
+// gridStep returns the direction along one axis, the part of the line between two cell borders, and
+// the part of the line before the first border.
+func gridStep(origin, delta float64) (step int, tDelta, tMax float64) {
+	if delta == 0 {
+		return 0, math.Inf(1), math.Inf(1)
+	}
+	border := math.Floor(origin+0.5) + 0.5
+	if delta > 0 {
+		return 1, 1 / delta, (border - origin) / delta
+	}
+	return -1, -1 / delta, (border - 1 - origin) / delta
+}
+
+// FillPolygon visits every cell whose center is inside the polygon, row by row. The polygon may be
+// concave, but the edges must not cross. Cells that are only partly covered are not visited, trace the
+// edges with SupercoverLine to include them.
+func FillPolygon(polygon [][2]float64, visit func(x, y int)) {
+	if len(polygon) < 3 {
+		return
+	}
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range polygon {
+		minY, maxY = min(minY, p[1]), max(maxY, p[1])
+	}
+	crossings := make([]float64, 0, 4)
+	for y := math.Ceil(minY); y <= maxY; y++ {
+		//x where the edges cross the row through the cell centers. Edges are half open, so a vertex on the
+		//row is only counted once.
+		crossings = crossings[:0]
+		for i, a := range polygon {
+			b := polygon[(i+1)%len(polygon)]
+			if (a[1] <= y) != (b[1] <= y) {
+				crossings = append(crossings, a[0]+(y-a[1])*(b[0]-a[0])/(b[1]-a[1]))
+			}
+		}
+		slices.Sort(crossings)
+		for i := 0; i+1 < len(crossings); i += 2 {
+			for x := math.Ceil(crossings[i]); x <= crossings[i+1]; x++ {
+				visit(int(x), int(y))
+			}
+		}
+	}
+}
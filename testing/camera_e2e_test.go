@@ -14,7 +14,7 @@ import (
 
 // Make sure the server is running and a robot is connected first. Match the robotID.
 // Run in separate terminal with: go test -v
-// TestCameraPublish publishes a sample camera segment payload to the MQTT broker.
+// TestCameraPublish publishes a sample camera frame to the MQTT broker.
 func TestCameraPublish(t *testing.T) {
 	broker := os.Getenv("MQTT_BROKER")
 	if broker == "" {
@@ -43,19 +43,24 @@ func TestCameraPublish(t *testing.T) {
 	}
 	defer client.Disconnect(250)
 
-	topic := "v2/robot/cam"
+	topic := fmt.Sprintf("v2/robot/NRF_%d/cam", robotID)
 
-	// Example values (mm): start = 0 (center), width = 400, distance = 400
-	start := int16(0)
-	width := int16(400)
-	distance := int16(400)
+	// Example segments (mm): start, width, distance. Leave empty to publish a "nothing seen" frame.
+	segments := [][3]int16{
+		{-200, 400, 400},
+		{300, 150, 700},
+	}
+	frameId := uint16(time.Now().Unix()) // increases between runs, so the server does not drop it as stale
+	towerAngle := uint8(90)
 
 	buf := new(bytes.Buffer)
-	// Write identifier (uint8) first, then three little-endian int16 values
+	// Header: identifier, frame id, timestamp (ms), tower angle, number of segments, all little-endian
 	binary.Write(buf, binary.LittleEndian, uint8(robotID))
-	binary.Write(buf, binary.LittleEndian, start)
-	binary.Write(buf, binary.LittleEndian, width)
-	binary.Write(buf, binary.LittleEndian, distance)
+	binary.Write(buf, binary.LittleEndian, frameId)
+	binary.Write(buf, binary.LittleEndian, uint32(time.Now().UnixMilli()))
+	binary.Write(buf, binary.LittleEndian, towerAngle)
+	binary.Write(buf, binary.LittleEndian, uint8(len(segments)))
+	binary.Write(buf, binary.LittleEndian, segments)
 
 	t.Logf("Publishing camera test payload to %s (broker=%s:%d)", topic, broker, port)
 	token := client.Publish(topic, 1, false, buf.Bytes())